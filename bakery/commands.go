@@ -26,6 +26,11 @@ func GetCommands() []cli.Command {
 					Name:  "config, c",
 					Usage: "Required. Path to config.json with properties and build commands defined.",
 				},
+				cli.StringFlag{
+					Name:   "profile",
+					Usage:  "Optional. Name of the config profile whose properties and commands are overlaid on the config.",
+					EnvVar: "BAKERY_PROFILE",
+				},
 				cli.StringFlag{
 					Name:  "rootDir, rd",
 					Usage: "Optional. Used to override rootDir of the dockerfiles location. Can be defined in config.json, provided in this argument or determined dynamically from the base dir of config file.",
@@ -34,6 +39,26 @@ func GetCommands() []cli.Command {
 					Name:  "property, p",
 					Usage: "Optional. Allows for providing additional multiple properties that can be used during templating. Overrides properties defined in config.json file. Expected format is: -p propertyName=propertyValue",
 				},
+				cli.BoolFlag{
+					Name:  "pin-digests",
+					Usage: "Optional. False by default. If this flag is set the parent image in the FROM clause is pinned to its digest from the lock file.",
+				},
+				cli.BoolFlag{
+					Name:  "all, a",
+					Usage: "Optional. False by default. If this flag is set Dockerfiles of all images in the hierarchy are filled next to their templates, input and output are ignored.",
+				},
+				cli.BoolFlag{
+					Name:  "check",
+					Usage: "Optional. False by default. If this flag is set Dockerfiles are not written, instead they are compared with the rendered templates and the command fails (printing the unified diff) when any of them differs.",
+				},
+				cli.BoolFlag{
+					Name:  "quiet, q",
+					Usage: "Optional. False by default. If this flag is set the discovered images hierarchy is not printed.",
+				},
+				cli.BoolFlag{
+					Name:  "no-cache",
+					Usage: "Optional. False by default. If this flag is set the cache of the parsed templates and the remote versions is neither used nor updated.",
+				},
 			},
 			Usage:  "Used to fill Dockerfile.template file. Values needed for template are taken from the config file and from dynamic properties provided during runtime.",
 			Before: commands.InitConfiguration,
@@ -55,6 +80,11 @@ func GetCommands() []cli.Command {
 					Name:  "config, c",
 					Usage: "Required. Path to config.json with properties and build commands defined.",
 				},
+				cli.StringFlag{
+					Name:   "profile",
+					Usage:  "Optional. Name of the config profile whose properties and commands are overlaid on the config.",
+					EnvVar: "BAKERY_PROFILE",
+				},
 				cli.StringFlag{
 					Name:  "root-dir, rd",
 					Usage: "Optional. Used to override rootDir of the dockerfiles location. Can be defined in config.json, provided in this argument or determined dynamically from the base dir of config file.",
@@ -67,6 +97,39 @@ func GetCommands() []cli.Command {
 					Name:  "property, p",
 					Usage: "Optional. Allows for providing additional multiple properties that can be used during templating. Overrides properties defined in config.json file. Expected format is: -p propertyName=propertyValue",
 				},
+				cli.BoolFlag{
+					Name:  "quiet, q",
+					Usage: "Optional. False by default. If this flag is set the discovered images hierarchy is not printed.",
+				},
+				cli.StringFlag{
+					Name:  "root",
+					Usage: "Optional. Name of the image whose subtree is built (when dockerfile is not provided the root image is built).",
+				},
+				cli.StringSliceFlag{
+					Name:  "include",
+					Usage: "Optional. Glob pattern matching names or directories (relative to the rootDir) of the images that are built as dependants, can be provided multiple times. Example: --include 'python-*' --include 'legacy/**'",
+				},
+				cli.StringSliceFlag{
+					Name:  "exclude",
+					Usage: "Optional. Glob pattern matching names or directories (relative to the rootDir) of the images that are not built as dependants, can be provided multiple times.",
+				},
+				cli.BoolFlag{
+					Name:  "no-cache",
+					Usage: "Optional. False by default. If this flag is set the cache of the parsed templates and the remote versions is neither used nor updated.",
+				},
+				cli.StringFlag{
+					Name:  "log-dir",
+					Usage: "Optional. Directory where the output of the build of every image is written to its own file (<image>-build.log). Console shows only the progress and the tail of the failing log.",
+				},
+				cli.StringFlag{
+					Name:  "log-format",
+					Value: "text",
+					Usage: "Optional. Format of the output: text or json. In json format processing events (image started, command executed, image finished) are printed as JSON lines to stdout and the progress messages to stderr.",
+				},
+				cli.StringSliceFlag{
+					Name:  "report-format",
+					Usage: "Optional. Format of the report written to the reportFileName (bakery-report.json when not configured): json (default), junit or markdown, can be provided multiple times. JUnit and Markdown reports are written next to the JSON one with .xml and .md extension.",
+				},
 			},
 			Usage:  "Used to build next version of the images in given scope. Optionally it can skip build of dependant images.",
 			Before: commands.InitConfiguration,
@@ -88,6 +151,11 @@ func GetCommands() []cli.Command {
 					Name:  "config, c",
 					Usage: "Required. Path to config.json with properties and build commands defined.",
 				},
+				cli.StringFlag{
+					Name:   "profile",
+					Usage:  "Optional. Name of the config profile whose properties and commands are overlaid on the config.",
+					EnvVar: "BAKERY_PROFILE",
+				},
 				cli.StringFlag{
 					Name:  "rootDir, rd",
 					Usage: "Optional. Used to override rootDir of the dockerfiles location. Can be defined in config.json, provided in this argument or determined dynamically from the base dir of config file.",
@@ -96,6 +164,39 @@ func GetCommands() []cli.Command {
 					Name:  "skip-dependants, sd",
 					Usage: "Optional. False be default. If this flag is set build of the parent will not trigger dependant builds.",
 				},
+				cli.BoolFlag{
+					Name:  "quiet, q",
+					Usage: "Optional. False by default. If this flag is set the discovered images hierarchy is not printed.",
+				},
+				cli.StringFlag{
+					Name:  "root",
+					Usage: "Optional. Name of the image whose subtree is pushed (when dockerfile is not provided the root image is pushed).",
+				},
+				cli.StringSliceFlag{
+					Name:  "include",
+					Usage: "Optional. Glob pattern matching names or directories (relative to the rootDir) of the images that are pushed as dependants, can be provided multiple times. Example: --include 'python-*' --include 'legacy/**'",
+				},
+				cli.StringSliceFlag{
+					Name:  "exclude",
+					Usage: "Optional. Glob pattern matching names or directories (relative to the rootDir) of the images that are not pushed as dependants, can be provided multiple times.",
+				},
+				cli.BoolFlag{
+					Name:  "no-cache",
+					Usage: "Optional. False by default. If this flag is set the cache of the parsed templates and the remote versions is neither used nor updated.",
+				},
+				cli.StringFlag{
+					Name:  "log-dir",
+					Usage: "Optional. Directory where the output of the push of every image is written to its own file (<image>-push.log). Console shows only the progress and the tail of the failing log.",
+				},
+				cli.StringFlag{
+					Name:  "log-format",
+					Value: "text",
+					Usage: "Optional. Format of the output: text or json. In json format processing events (image started, command executed, image finished) are printed as JSON lines to stdout and the progress messages to stderr.",
+				},
+				cli.StringSliceFlag{
+					Name:  "report-format",
+					Usage: "Optional. Format of the report written to the reportFileName (bakery-report.json when not configured): json (default), junit or markdown, can be provided multiple times. JUnit and Markdown reports are written next to the JSON one with .xml and .md extension.",
+				},
 			},
 			Usage:  "Used to push next version of the images in given scope. Optionally it can skip push of dependant images.",
 			Before: commands.InitConfiguration,
@@ -110,13 +211,140 @@ func GetCommands() []cli.Command {
 					Name:  "config, c",
 					Usage: "Required. Path to config.json with properties and build commands defined.",
 				},
+				cli.StringFlag{
+					Name:   "profile",
+					Usage:  "Optional. Name of the config profile whose properties and commands are overlaid on the config.",
+					EnvVar: "BAKERY_PROFILE",
+				},
+				cli.StringFlag{
+					Name:  "rootDir, rd",
+					Usage: "Optional. Used to override rootDir of the dockerfiles location. Can be defined in config.json, provided in this argument or determined dynamically from the base dir of config file.",
+				},
+				cli.StringFlag{
+					Name:  "format",
+					Usage: "Optional. Format of the hierarchy, one of: tree, json, dot, mermaid. Every format includes image name, directory, latest version, parent, external and excluded flags.",
+					Value: "tree",
+				},
+				cli.StringFlag{
+					Name:  "file-name, file, f",
+					Usage: "Optional. File name where the hierarchy will be stored. Printed to the standard output when not provided.",
+				},
+				cli.StringFlag{
+					Name:  "root",
+					Usage: "Optional. Name of the image whose subtree is shown.",
+				},
+				cli.StringSliceFlag{
+					Name:  "include",
+					Usage: "Optional. Glob pattern matching names or directories (relative to the rootDir) of the images that are shown, can be provided multiple times. Example: --include 'python-*' --include 'legacy/**'",
+				},
+				cli.StringSliceFlag{
+					Name:  "exclude",
+					Usage: "Optional. Glob pattern matching names or directories (relative to the rootDir) of the images that are not shown, can be provided multiple times.",
+				},
+				cli.BoolFlag{
+					Name:  "no-cache",
+					Usage: "Optional. False by default. If this flag is set the cache of the parsed templates and the remote versions is neither used nor updated.",
+				},
+			},
+			Usage:  "Used to display hierarchy of the images, as a tree or in a machine-readable format (json, dot or mermaid).",
+			Action: commands.ShowStructureCmd,
+		},
+		{
+			Name:      "ancestors",
+			Hidden:    false,
+			ArgsUsage: "IMAGE",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "config, c",
+					Usage: "Required. Path to config.json with properties and build commands defined.",
+				},
+				cli.StringFlag{
+					Name:   "profile",
+					Usage:  "Optional. Name of the config profile whose properties and commands are overlaid on the config.",
+					EnvVar: "BAKERY_PROFILE",
+				},
+				cli.StringFlag{
+					Name:  "rootDir, rd",
+					Usage: "Optional. Used to override rootDir of the dockerfiles location. Can be defined in config.json, provided in this argument or determined dynamically from the base dir of config file.",
+				},
+				cli.StringFlag{
+					Name:  "format",
+					Usage: "Optional. Output format, one of: text, json.",
+					Value: "text",
+				},
+				cli.BoolFlag{
+					Name:  "no-cache",
+					Usage: "Optional. False by default. If this flag is set the cache of the parsed templates and the remote versions is neither used nor updated.",
+				},
+			},
+			Usage:  "Used to list the parent chain of the image up to the external root image.",
+			Action: commands.AncestorsCmd,
+		},
+		{
+			Name:      "descendants",
+			Hidden:    false,
+			ArgsUsage: "IMAGE",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "config, c",
+					Usage: "Required. Path to config.json with properties and build commands defined.",
+				},
+				cli.StringFlag{
+					Name:   "profile",
+					Usage:  "Optional. Name of the config profile whose properties and commands are overlaid on the config.",
+					EnvVar: "BAKERY_PROFILE",
+				},
+				cli.StringFlag{
+					Name:  "rootDir, rd",
+					Usage: "Optional. Used to override rootDir of the dockerfiles location. Can be defined in config.json, provided in this argument or determined dynamically from the base dir of config file.",
+				},
+				cli.StringFlag{
+					Name:  "format",
+					Usage: "Optional. Output format, one of: text, json.",
+					Value: "text",
+				},
+				cli.IntFlag{
+					Name:  "depth",
+					Usage: "Optional. Limits how deep the descendants are listed, 1 lists only the direct dependants. Unlimited by default.",
+				},
+				cli.BoolFlag{
+					Name:  "no-cache",
+					Usage: "Optional. False by default. If this flag is set the cache of the parsed templates and the remote versions is neither used nor updated.",
+				},
+			},
+			Usage:  "Used to list images rebuilt after the image is bumped. Images excluded from the automatic builds are listed but their dependants are not.",
+			Action: commands.DescendantsCmd,
+		},
+		{
+			Name:      "why",
+			Hidden:    false,
+			ArgsUsage: "IMAGE_A IMAGE_B",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "config, c",
+					Usage: "Required. Path to config.json with properties and build commands defined.",
+				},
+				cli.StringFlag{
+					Name:   "profile",
+					Usage:  "Optional. Name of the config profile whose properties and commands are overlaid on the config.",
+					EnvVar: "BAKERY_PROFILE",
+				},
 				cli.StringFlag{
 					Name:  "rootDir, rd",
 					Usage: "Optional. Used to override rootDir of the dockerfiles location. Can be defined in config.json, provided in this argument or determined dynamically from the base dir of config file.",
 				},
+				cli.StringFlag{
+					Name:  "format",
+					Usage: "Optional. Output format, one of: text, json.",
+					Value: "text",
+				},
+				cli.BoolFlag{
+					Name:  "no-cache",
+					Usage: "Optional. False by default. If this flag is set the cache of the parsed templates and the remote versions is neither used nor updated.",
+				},
 			},
-			Usage:  "Used to display hierarchy of the images",
-			Action: commands.InitConfiguration,
+			Usage:  "Used to show the path linking two images through their closest common ancestor.",
+			Action: commands.WhyCmd,
 		},
 		{
 			Name:    "dump-latest-versions",
@@ -127,6 +355,11 @@ func GetCommands() []cli.Command {
 					Name:  "config, c",
 					Usage: "Required. Path to config.json with properties and build commands defined.",
 				},
+				cli.StringFlag{
+					Name:   "profile",
+					Usage:  "Optional. Name of the config profile whose properties and commands are overlaid on the config.",
+					EnvVar: "BAKERY_PROFILE",
+				},
 				cli.StringFlag{
 					Name:  "rootDir, rd",
 					Usage: "Optional. Used to override rootDir of the dockerfiles location. Can be defined in config.json, provided in this argument or determined dynamically from the base dir of config file.",
@@ -140,6 +373,26 @@ func GetCommands() []cli.Command {
 					Name:  "exclude-dirs, e",
 					Usage: "Optional. Pattern used to exclude images located in directories that match provided argument.",
 				},
+				cli.BoolFlag{
+					Name:  "quiet, q",
+					Usage: "Optional. False by default. If this flag is set the discovered images hierarchy is not printed.",
+				},
+				cli.StringFlag{
+					Name:  "root",
+					Usage: "Optional. Name of the image whose subtree is dumped.",
+				},
+				cli.StringSliceFlag{
+					Name:  "include",
+					Usage: "Optional. Glob pattern matching names or directories (relative to the rootDir) of the images that are dumped, can be provided multiple times. Example: --include 'python-*' --include 'legacy/**'",
+				},
+				cli.StringSliceFlag{
+					Name:  "exclude",
+					Usage: "Optional. Glob pattern matching names or directories (relative to the rootDir) of the images that are not dumped, can be provided multiple times.",
+				},
+				cli.BoolFlag{
+					Name:  "no-cache",
+					Usage: "Optional. False by default. If this flag is set the cache of the parsed templates and the remote versions is neither used nor updated.",
+				},
 			},
 			Usage:  "Used to dump data about latest versions of images to the provided file",
 			Before: commands.InitConfiguration,
@@ -154,6 +407,11 @@ func GetCommands() []cli.Command {
 					Name:  "config, c",
 					Usage: "Required. Path to config.json with properties and build commands defined.",
 				},
+				cli.StringFlag{
+					Name:   "profile",
+					Usage:  "Optional. Name of the config profile whose properties and commands are overlaid on the config.",
+					EnvVar: "BAKERY_PROFILE",
+				},
 				cli.StringFlag{
 					Name:  "rootDir, rd",
 					Usage: "Optional. Used to override rootDir of the dockerfiles location. Can be defined in config.json, provided in this argument or determined dynamically from the base dir of config file.",
@@ -174,10 +432,130 @@ func GetCommands() []cli.Command {
 					Name:  "replace, rp",
 					Usage: "Optional. Allows for providing additional string replacements, to automate updates in new family. Will change all occurrences in the child images. Expected format is: -rp originalString=replacementString. Example: -rp python3.8=python3.9",
 				},
+				cli.BoolFlag{
+					Name:  "quiet, q",
+					Usage: "Optional. False by default. If this flag is set the discovered images hierarchy is not printed.",
+				},
+				cli.BoolFlag{
+					Name:  "no-cache",
+					Usage: "Optional. False by default. If this flag is set the cache of the parsed templates and the remote versions is neither used nor updated.",
+				},
 			},
 			Usage:  "This command takes 'new-parent-image' image and copies images hierarchy from 'base-image', for example to simplify new image versions when updating some dependencies (for example from Ubuntu 22 to Ubuntu 24, without loosing previous images) ",
 			Before: commands.InitConfiguration,
 			Action: commands.GenerateImagesTree,
 		},
+		{
+			Name:    "show-config",
+			Aliases: []string{"config"},
+			Hidden:  false,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "config, c",
+					Usage: "Required. Path to config.json with properties and build commands defined.",
+				},
+				cli.StringFlag{
+					Name:   "profile",
+					Usage:  "Optional. Name of the config profile whose properties and commands are overlaid on the config.",
+					EnvVar: "BAKERY_PROFILE",
+				},
+				cli.StringFlag{
+					Name:  "rootDir, rd",
+					Usage: "Optional. Used to override rootDir of the dockerfiles location. Can be defined in config.json, provided in this argument or determined dynamically from the base dir of config file.",
+				},
+				cli.StringSliceFlag{
+					Name:  "property, p",
+					Usage: "Optional. Allows for providing additional multiple properties that can be used during templating. Overrides properties defined in config.json file. Expected format is: -p propertyName=propertyValue",
+				},
+				cli.StringFlag{
+					Name:  "dockerfile, d",
+					Usage: "Optional. Path to dockerfile/dockerfile.template whose directory overrides should be applied.",
+				},
+				cli.BoolFlag{
+					Name:  "quiet, q",
+					Usage: "Optional. False by default. If this flag is set the discovered images hierarchy is not printed.",
+				},
+				cli.BoolFlag{
+					Name:  "no-cache",
+					Usage: "Optional. False by default. If this flag is set the cache of the parsed templates and the remote versions is neither used nor updated.",
+				},
+			},
+			Usage:  "Used to print config layers in order of precedence along with the effective properties (and the layer providing their values) and commands.",
+			Before: commands.InitConfiguration,
+			Action: commands.ShowConfigCmd,
+		},
+		{
+			Name:    "affected-images",
+			Aliases: []string{"affected"},
+			Hidden:  false,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "config, c",
+					Usage: "Required. Path to config.json with properties and build commands defined.",
+				},
+				cli.StringFlag{
+					Name:   "profile",
+					Usage:  "Optional. Name of the config profile whose properties and commands are overlaid on the config.",
+					EnvVar: "BAKERY_PROFILE",
+				},
+				cli.StringFlag{
+					Name:  "rootDir, rd",
+					Usage: "Optional. Used to override rootDir of the dockerfiles location. Can be defined in config.json, provided in this argument or determined dynamically from the base dir of config file.",
+				},
+				cli.StringSliceFlag{
+					Name:  "changed-file, f",
+					Usage: "Required. Path of the changed file, can be provided multiple times. Example: -f base/Dockerfile.template -f partials/labels.tmpl",
+				},
+				cli.BoolFlag{
+					Name:  "quiet, q",
+					Usage: "Optional. False by default. If this flag is set the discovered images hierarchy is not printed.",
+				},
+				cli.BoolFlag{
+					Name:  "no-cache",
+					Usage: "Optional. False by default. If this flag is set the cache of the parsed templates and the remote versions is neither used nor updated.",
+				},
+			},
+			Usage:  "Used to print names of the images affected by the changed files (files in the image build context or partials used by its template) along with their dependants.",
+			Before: commands.InitConfiguration,
+			Action: commands.AffectedImagesCmd,
+		},
+		{
+			Name:   "validate",
+			Hidden: false,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "config, c",
+					Usage: "Required. Path to config.json with properties and build commands defined.",
+				},
+				cli.StringFlag{
+					Name:   "profile",
+					Usage:  "Optional. Name of the config profile whose properties and commands are overlaid on the config.",
+					EnvVar: "BAKERY_PROFILE",
+				},
+				cli.StringFlag{
+					Name:  "rootDir, rd",
+					Usage: "Optional. Used to override rootDir of the dockerfiles location. Can be defined in config.json, provided in this argument or determined dynamically from the base dir of config file.",
+				},
+				cli.StringSliceFlag{
+					Name:  "property, p",
+					Usage: "Optional. Allows for providing additional multiple properties that can be used during templating. Overrides properties defined in config.json file. Expected format is: -p propertyName=propertyValue",
+				},
+			},
+			Usage:  "Used to validate config, Dockerfile templates and images hierarchy. Prints all problems found and exits with non-zero code if there is any. Does not require access to the git remote.",
+			Action: commands.ValidateCmd,
+		},
+		{
+			Name:    "config-schema",
+			Aliases: []string{"schema"},
+			Hidden:  false,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "file-name, file, f",
+					Usage: "Optional. File name where the schema will be stored. Printed to the standard output when not provided.",
+				},
+			},
+			Usage:  "Used to print JSON Schema of the config file that can be used by editors to validate and autocomplete the config.",
+			Action: commands.ConfigSchemaCmd,
+		},
 	}
 }
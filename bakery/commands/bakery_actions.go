@@ -2,39 +2,150 @@
 package commands
 
 import (
+	"context"
+	"fmt"
+
+	cliCommons "github.com/smartrecruiters/docker-bakery/bakery/commons/cli"
 	"github.com/smartrecruiters/docker-bakery/bakery/service"
 	"github.com/urfave/cli"
 )
 
-// InitConfiguration initializes configuration for the rest of invoked commands.
-// Receives config file path and optionally root directory to override the config section.
+// engine is initialized by InitConfiguration and used by the invoked command
+var engine *service.Engine
+
+// newEngine creates the engine with the options provided in the command flags.
+func newEngine(c *cli.Context) *service.Engine {
+	options := service.Options{
+		ConfigFile:    c.String("c"),
+		RootDir:       c.String("rd"),
+		Profile:       c.String("profile"),
+		Properties:    c.StringSlice("p"),
+		PinDigests:    c.Bool("pin-digests"),
+		DisableCache:  c.Bool("no-cache"),
+		LogDir:        c.String("log-dir"),
+		LogFormat:     c.String("log-format"),
+		ReportFormats: c.StringSlice("report-format"),
+	}
+	if c.String("root") != "" || len(c.StringSlice("include")) > 0 || len(c.StringSlice("exclude")) > 0 {
+		options.Filter = &service.ImageFilter{Root: c.String("root"), Include: c.StringSlice("include"), Exclude: c.StringSlice("exclude")}
+	}
+	return service.NewEngine(options)
+}
+
+// InitConfiguration initializes configuration for the rest of invoked commands and prints the images hierarchy unless quiet.
+// Receives config file path and optionally root directory to override the config section and the profile to apply.
 func InitConfiguration(c *cli.Context) error {
-	return service.InitConfiguration(c.String("c"), c.String("rd"), c.StringSlice("p"))
+	err := initConfiguration(c)
+	if err == nil && !c.Bool("quiet") {
+		err = engine.PrintImageHierarchy()
+	}
+	return err
+}
+
+func initConfiguration(c *cli.Context) error {
+	engine = newEngine(c)
+	return engine.InitConfiguration(context.Background())
 }
 
-// FillTemplateCmd fills input dockerfile template and stores the result under provided output.
+// ShowStructureCmd prints the images hierarchy in the requested format or stores it in the provided file.
+func ShowStructureCmd(c *cli.Context) error {
+	err := initConfiguration(c)
+	if err != nil {
+		return err
+	}
+	return engine.ShowStructure(c.String("format"), c.String("f"))
+}
+
+// FillTemplateCmd fills input dockerfile template (or templates of all images) and stores the result under provided output.
+// Optionally pins the parent image to its locked digest or only checks whenever the output is up to date.
 func FillTemplateCmd(c *cli.Context) error {
-	return service.FillTemplate(c.String("i"), c.String("o"))
+	if c.Bool("all") {
+		return engine.FillAllTemplates(c.Bool("check"))
+	}
+	if c.Bool("check") {
+		return engine.CheckTemplate(c.String("i"), c.String("o"))
+	}
+	return engine.FillTemplate(c.String("i"), c.String("o"))
 }
 
 // BuildDockerfileCmd invokes docker build command on the provided file with the provided change scope.
-// Optionally it skips builds of dependant images.
+// Optionally it skips builds of dependant images. First interruption stops running builds gracefully, second forces the exit.
 func BuildDockerfileCmd(c *cli.Context) error {
-	return service.BuildDockerfile(c.String("d"), c.String("s"), !c.Bool("sd"))
+	ctx, stop := cliCommons.InterruptionContext()
+	defer stop()
+	return engine.BuildDockerfile(ctx, c.String("d"), c.String("s"), !c.Bool("sd"))
 }
 
 // PushDockerImagesCmd invokes docker push command on the provided file with the provided change scope.
-// Optionally it skips pushes of dependant images.
+// Optionally it skips pushes of dependant images. First interruption stops running pushes gracefully, second forces the exit.
 func PushDockerImagesCmd(c *cli.Context) error {
-	return service.PushDockerImages(c.String("d"), c.String("s"), !c.Bool("sd"))
+	ctx, stop := cliCommons.InterruptionContext()
+	defer stop()
+	return engine.PushDockerImages(ctx, c.String("d"), c.String("s"), !c.Bool("sd"))
+}
+
+// AncestorsCmd prints the parent chain of the image provided as the argument.
+func AncestorsCmd(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return fmt.Errorf("expecting exactly one argument with the image name")
+	}
+	err := initConfiguration(c)
+	if err != nil {
+		return err
+	}
+	return engine.PrintAncestors(c.Args().Get(0), c.String("format"))
+}
+
+// DescendantsCmd prints images rebuilt after the image provided as the argument is bumped, optionally limited by depth.
+func DescendantsCmd(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return fmt.Errorf("expecting exactly one argument with the image name")
+	}
+	err := initConfiguration(c)
+	if err != nil {
+		return err
+	}
+	return engine.PrintDescendants(c.Args().Get(0), c.Int("depth"), c.String("format"))
+}
+
+// WhyCmd prints the path linking two images provided as the arguments.
+func WhyCmd(c *cli.Context) error {
+	if c.NArg() != 2 {
+		return fmt.Errorf("expecting exactly two arguments with the image names")
+	}
+	err := initConfiguration(c)
+	if err != nil {
+		return err
+	}
+	return engine.PrintWhy(c.Args().Get(0), c.Args().Get(1), c.String("format"))
 }
 
 // DumpLatestVersionsCmd dumps information about images and their latest versions to file in json format.
 func DumpLatestVersionsCmd(c *cli.Context) error {
-	return service.DumpLatestVersions(c.String("f"), c.String("e"))
+	return engine.DumpLatestVersions(c.String("f"), c.String("e"))
 }
 
 // GenerateImagesTree generate ancestors for a given image with a new parent image
 func GenerateImagesTree(c *cli.Context) error {
-	return service.GenerateImagesTree(c.String("base-image"), c.Bool("r"), c.Bool("skip-existing-dirs"), c.StringSlice("replace"))
+	return engine.GenerateImagesTree(c.String("base-image"), c.Bool("r"), c.Bool("skip-existing-dirs"), c.StringSlice("replace"))
+}
+
+// ShowConfigCmd prints config layers and effective properties, optionally for the provided dockerfile.
+func ShowConfigCmd(c *cli.Context) error {
+	return engine.PrintConfig(c.String("d"))
+}
+
+// AffectedImagesCmd prints names of the images affected by the changed files.
+func AffectedImagesCmd(c *cli.Context) error {
+	return engine.PrintAffectedImages(c.StringSlice("f"))
+}
+
+// ValidateCmd validates config, Dockerfile templates and images hierarchy and prints all problems found.
+func ValidateCmd(c *cli.Context) error {
+	return newEngine(c).Validate()
+}
+
+// ConfigSchemaCmd prints or stores JSON Schema of the config file.
+func ConfigSchemaCmd(c *cli.Context) error {
+	return service.WriteConfigSchema(c.String("f"))
 }
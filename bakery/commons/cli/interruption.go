@@ -0,0 +1,44 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/fatih/color"
+	"github.com/urfave/cli"
+)
+
+// InterruptionContext returns context cancelled on the first interruption signal (Ctrl-C or SIGTERM), so the running
+// commands can be terminated gracefully and the report written. The second signal forces the exit.
+// Returned function stops handling of the signals and has to be called once the processing is done.
+func InterruptionContext() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	stopped := make(chan struct{})
+
+	go func() {
+		select {
+		case sig := <-signals:
+			fmt.Fprintf(cli.ErrWriter, color.YellowString("Received %s, stopping running commands (interrupt again to force the exit)\n", sig))
+			cancel()
+		case <-stopped:
+			return
+		}
+		select {
+		case sig := <-signals:
+			fmt.Fprintf(cli.ErrWriter, color.RedString("Received %s again, forcing the exit\n", sig))
+			cli.OsExiter(1)
+		case <-stopped:
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(signals)
+		close(stopped)
+		cancel()
+	}
+}
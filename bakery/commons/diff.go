@@ -0,0 +1,36 @@
+package commons
+
+import (
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// UnifiedDiff returns unified diff (with 3 lines of context) between the original and the changed content.
+// Returns empty string when the contents are equal.
+func UnifiedDiff(originalName, changedName, original, changed string) string {
+	if original == changed {
+		return ""
+	}
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        splitLines(original),
+		B:        splitLines(changed),
+		FromFile: originalName,
+		ToFile:   changedName,
+		Context:  3,
+	})
+	if err != nil {
+		Debugf("Unable to calculate diff of %s, err: %s", originalName, err)
+	}
+	return diff
+}
+
+// splitLines splits the text into lines keeping the line endings, last line without ending gets one.
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		return lines[:len(lines)-1]
+	}
+	lines[len(lines)-1] += "\n"
+	return lines
+}
@@ -0,0 +1,22 @@
+package commons
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/smartrecruiters/docker-bakery/bakery/commons/testassist"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	testCases := []testassist.TestCase{
+		{Expected: "", Input: []string{"FROM base\nRUN true\n", "FROM base\nRUN true\n"}},
+		{Expected: "--- Dockerfile\n+++ rendered\n@@ -1,2 +1,2 @@\n FROM base\n-RUN true\n+RUN false\n", Input: []string{"FROM base\nRUN true\n", "FROM base\nRUN false\n"}},
+		{Expected: "--- Dockerfile\n+++ rendered\n@@ -0,0 +1 @@\n+FROM base\n", Input: []string{"", "FROM base\n"}},
+	}
+
+	for i, tc := range testCases {
+		args := tc.Input.([]string)
+		actual := UnifiedDiff("Dockerfile", "rendered", args[0], args[1])
+		testassist.VerifyCondition(tc.Expected.(string) == actual, fmt.Sprintf("TestCase: %d Expected %q, got %q", i, tc.Expected, actual), t)
+	}
+}
@@ -1,11 +1,13 @@
 package commons
 
 import (
+	"crypto/sha256"
 	"encoding/json"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path"
-	"text/template"
 )
 
 const (
@@ -14,38 +16,33 @@ const (
 )
 
 // FillTemplate fills source template from file and stores it under provided destination. To fill the template provided map with mappings is used.
-func FillTemplate(templatePath, finalPath string, mapping map[string]string) error {
-	t, err := template.ParseFiles(templatePath)
+func FillTemplate(templatePath, finalPath string, mapping map[string]string, options TemplateOptions) error {
+	content, err := RenderTemplate(templatePath, mapping, options)
 	if err != nil {
-		Debugf("Getting template failed, err: %s", err)
 		return err
 	}
+	return WriteRenderedFile(templatePath, finalPath, content)
+}
 
+// WriteRenderedFile stores content rendered from the template under provided destination with the same permissions as the template.
+// Creates missing directories of the destination.
+func WriteRenderedFile(templatePath, finalPath string, content []byte) error {
 	base := path.Dir(finalPath)
-	err = MakeDir(base)
+	err := MakeDir(base)
 	if err != nil {
 		Debugf("Creating dir structure for new file failed, err: %s", err)
 		return err
 	}
 
-	f, err := os.Create(finalPath)
-	if err != nil {
-		Debugf("Creating new file failed, err: %s", err)
-		return err
-	}
-	defer func() {
-		f.Close()
-	}()
-
-	err = t.Execute(f, mapping)
+	si, err := os.Stat(templatePath)
 	if err != nil {
-		Debugf("Parsing failed, err: %s", err)
+		Debugf("Getting stats for template file failed, err: %s", err)
 		return err
 	}
 
-	si, err := os.Stat(templatePath)
+	err = ioutil.WriteFile(finalPath, content, si.Mode())
 	if err != nil {
-		Debugf("Getting stats for template file failed, err: %s", err)
+		Debugf("Writing new file failed, err: %s", err)
 		return err
 	}
 
@@ -57,6 +54,22 @@ func FillTemplate(templatePath, finalPath string, mapping map[string]string) err
 	return err
 }
 
+// RenderTemplate fills source template from file with provided mappings and returns the result.
+func RenderTemplate(templatePath string, mapping map[string]string, options TemplateOptions) ([]byte, error) {
+	t, err := ParseTemplateFile(templatePath, options)
+	if err != nil {
+		Debugf("Getting template failed, err: %s", err)
+		return nil, err
+	}
+
+	content, err := ExecuteTemplate(t, templatePath, mapping)
+	if err != nil {
+		Debugf("Parsing failed, err: %s", err)
+		return nil, err
+	}
+	return content, nil
+}
+
 // MakeDir creates directory under a given path.
 func MakeDir(path string) error {
 	var err error
@@ -71,10 +84,35 @@ func MakeDir(path string) error {
 
 // WriteToJSONFile marshalls provided content to json and stores it in file with provided name.
 func WriteToJSONFile(content interface{}, fileName string) error {
+	return WriteToFilteredJSONFile(content, fileName, nil)
+}
+
+// WriteToFilteredJSONFile marshalls provided content to json, passes it through the filter (if provided)
+// and stores it in file with provided name.
+func WriteToFilteredJSONFile(content interface{}, fileName string, filter func(string) string) error {
 	data, err := json.MarshalIndent(content, prefix, indent)
 	if err != nil {
 		return err
 	}
 
-	return ioutil.WriteFile(fileName, []byte(string(data[:])+"\n"), 0755)
+	text := string(data[:])
+	if filter != nil {
+		text = filter(text)
+	}
+	return ioutil.WriteFile(fileName, []byte(text+"\n"), 0755)
+}
+
+// FileSHA256 returns sha256 checksum of the file content in the `sha256:<hex>` format.
+func FileSHA256(fileName string) (string, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("sha256:%x", h.Sum(nil)), nil
 }
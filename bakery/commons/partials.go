@@ -0,0 +1,143 @@
+package commons
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"text/template"
+	"text/template/parse"
+)
+
+// includeFuncName is the name of the function that renders the partial and returns it as a string
+const includeFuncName = "include"
+
+// Partials holds templates shared across the Dockerfile templates. Every file found in the partials dir
+// is available as a template named after its path relative to the dir (`{{template "common/cleanup.tmpl" .}}`),
+// templates defined in the files are available under their names (`{{template "labels" .}}`).
+type Partials struct {
+	Dir string
+	// files maps path of the partial file (relative to the dir) to its content
+	files map[string]string
+	// definedIn maps names of the templates to the relative paths of the partial files defining them
+	definedIn map[string]string
+}
+
+// LoadPartials reads all partial files from the dir and its subdirectories.
+func LoadPartials(dir string) (*Partials, error) {
+	p := &Partials{Dir: dir, files: make(map[string]string), definedIn: make(map[string]string)}
+	err := filepath.Walk(dir, func(sourcePath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		relPath, err := filepath.Rel(dir, sourcePath)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+		content, err := ioutil.ReadFile(sourcePath)
+		if err != nil {
+			return err
+		}
+		names, err := definedTemplates(relPath, string(content))
+		if err != nil {
+			return err
+		}
+		p.files[relPath] = string(content)
+		for _, name := range names {
+			p.definedIn[name] = relPath
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to load partials from %s: %s", dir, err)
+	}
+	return p, nil
+}
+
+// definedTemplates returns names of the templates defined in the partial file, including the file itself.
+// Functions are not checked as they are provided at the time the partial is added to the template.
+func definedTemplates(name, content string) ([]string, error) {
+	tree := parse.New(name)
+	tree.Mode = parse.SkipFuncCheck
+	treeSet := make(map[string]*parse.Tree)
+	if _, err := tree.Parse(content, "", "", treeSet); err != nil {
+		return nil, err
+	}
+	names := []string{name}
+	for definedName := range treeSet {
+		if definedName != name {
+			names = append(names, definedName)
+		}
+	}
+	return names, nil
+}
+
+// FileNames returns sorted relative paths of all partial files.
+func (p *Partials) FileNames() []string {
+	return SortMapKeys(p.files)
+}
+
+// Content returns content of the partial file.
+func (p *Partials) Content(fileName string) string {
+	return p.files[fileName]
+}
+
+// addTo parses all partials as templates associated with the provided one.
+func (p *Partials) addTo(t *template.Template) error {
+	for _, fileName := range p.FileNames() {
+		if _, err := t.New(fileName).Parse(p.files[fileName]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UsedFiles returns sorted relative paths of the partial files used by the template, directly or through other partials.
+// Partials are used with the `template` action or with the include function called with a string literal.
+func (p *Partials) UsedFiles(t *template.Template) []string {
+	if p == nil {
+		return []string{}
+	}
+
+	used := make(map[string]string)
+	walkTemplates(t, func(node parse.Node, sameDot bool) {
+		for _, referenced := range referencedTemplates(node) {
+			if fileName, isPartial := p.definedIn[referenced]; isPartial && referenced != t.Name() {
+				used[fileName] = fileName
+			}
+		}
+	})
+	return SortMapKeys(used)
+}
+
+// referencedTemplates returns names of the templates referenced by the node with the `template` action or the include function.
+func referencedTemplates(node parse.Node) []string {
+	switch n := node.(type) {
+	case *parse.TemplateNode:
+		return []string{n.Name}
+	case *parse.CommandNode:
+		if len(n.Args) < 2 {
+			return nil
+		}
+		identifier, isIdentifier := n.Args[0].(*parse.IdentifierNode)
+		name, isString := n.Args[1].(*parse.StringNode)
+		if isIdentifier && isString && identifier.Ident == includeFuncName {
+			return []string{name.Text}
+		}
+	}
+	return nil
+}
+
+// MissingTemplates returns sorted names of the templates (e.g. partials) referenced in the template but not defined.
+func MissingTemplates(t *template.Template) []string {
+	missing := make(map[string]string)
+	walkTemplates(t, func(node parse.Node, sameDot bool) {
+		for _, name := range referencedTemplates(node) {
+			if t.Lookup(name) == nil {
+				missing[name] = name
+			}
+		}
+	})
+	return SortMapKeys(missing)
+}
@@ -0,0 +1,98 @@
+package commons
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/smartrecruiters/docker-bakery/bakery/commons/testassist"
+)
+
+func givenPartials(t *testing.T) *Partials {
+	dir := t.TempDir()
+	files := map[string]string{
+		"labels.tmpl":         `{{define "labels"}}LABEL maintainer={{.MAINTAINER}}{{end}}{{define "user"}}{{include "common/user.tmpl" .}}{{end}}`,
+		"common/cleanup.tmpl": "RUN rm -rf {{.CACHE_DIR}}",
+		"common/user.tmpl":    "USER {{.USER}}",
+		"common/unused.tmpl":  "RUN echo unused",
+	}
+	for name, content := range files {
+		fileName := filepath.Join(dir, filepath.FromSlash(name))
+		err := os.MkdirAll(filepath.Dir(fileName), 0755)
+		testassist.VerifyCondition(err == nil, fmt.Sprintf("Unexpected error %s", err), t)
+		err = ioutil.WriteFile(fileName, []byte(content), 0644)
+		testassist.VerifyCondition(err == nil, fmt.Sprintf("Unexpected error %s", err), t)
+	}
+
+	partials, err := LoadPartials(dir)
+	testassist.VerifyCondition(err == nil, fmt.Sprintf("Unexpected error %s", err), t)
+	return partials
+}
+
+func TestParseTemplateWithPartials(t *testing.T) {
+	partials := givenPartials(t)
+	tmpl, err := ParseTemplate("Dockerfile.template", "FROM base\n{{template \"labels\" .}}\n{{template \"user\" .}}\n{{include \"common/cleanup.tmpl\" . | upper}}", TemplateOptions{Partials: partials})
+	testassist.VerifyCondition(err == nil, fmt.Sprintf("Unexpected error %s", err), t)
+
+	content, err := ExecuteTemplate(tmpl, "Dockerfile.template", map[string]string{"MAINTAINER": "bakery", "USER": "app", "CACHE_DIR": "/var/cache"})
+
+	expected := "FROM base\nLABEL maintainer=bakery\nUSER app\nRUN RM -RF /VAR/CACHE"
+	testassist.VerifyCondition(err == nil, fmt.Sprintf("Unexpected error %s", err), t)
+	testassist.VerifyCondition(string(content) == expected, fmt.Sprintf("Expected %s, got %s", expected, content), t)
+}
+
+func TestUsedPartialFiles(t *testing.T) {
+	partials := givenPartials(t)
+	testCases := []testassist.TestCase{
+		{Expected: []string{"common/user.tmpl", "labels.tmpl"}, Input: `{{template "user" .}}`},
+		{Expected: []string{"common/cleanup.tmpl", "labels.tmpl"}, Input: `{{template "labels" .}}{{include "common/cleanup.tmpl" .}}`},
+		{Expected: []string{}, Input: `FROM base`},
+	}
+
+	for i, tc := range testCases {
+		tmpl, err := ParseTemplate("Dockerfile.template", tc.Input.(string), TemplateOptions{Partials: partials})
+		testassist.VerifyCondition(err == nil, fmt.Sprintf("TestCase: %d Unexpected error %s", i, err), t)
+		actual := partials.UsedFiles(tmpl)
+		testassist.VerifyCondition(reflect.DeepEqual(tc.Expected, actual), fmt.Sprintf("TestCase: %d Expected %s, got %s", i, tc.Expected, actual), t)
+	}
+}
+
+func TestReferencedFieldsOfUsedPartials(t *testing.T) {
+	tmpl, err := ParseTemplate("Dockerfile.template", `{{template "labels" .}}{{template "user" .}}{{include "common/user.tmpl" .}}`, TemplateOptions{Partials: givenPartials(t)})
+	testassist.VerifyCondition(err == nil, fmt.Sprintf("Unexpected error %s", err), t)
+
+	expected := []string{"MAINTAINER", "USER"}
+	actual := ReferencedFields(tmpl)
+	testassist.VerifyCondition(reflect.DeepEqual(expected, actual), fmt.Sprintf("Expected %s, got %s", expected, actual), t)
+}
+
+func TestExecuteStrictTemplateReportsMissingKeyInPartial(t *testing.T) {
+	partials := givenPartials(t)
+	tmpl, err := ParseTemplate("Dockerfile.template", "FROM base\n{{include \"common/cleanup.tmpl\" .}}", TemplateOptions{Strict: true, Partials: partials})
+	testassist.VerifyCondition(err == nil, fmt.Sprintf("Unexpected error %s", err), t)
+
+	_, err = ExecuteTemplate(tmpl, "app/Dockerfile.template", map[string]string{})
+
+	expected := "common/cleanup.tmpl:1: undefined property CACHE_DIR"
+	testassist.VerifyCondition(err != nil && err.Error() == expected, fmt.Sprintf("Expected %s, got %v", expected, err), t)
+
+	tmpl, err = ParseTemplate("Dockerfile.template", "FROM base\n{{template \"labels\" .}}", TemplateOptions{Strict: true, Partials: partials})
+	testassist.VerifyCondition(err == nil, fmt.Sprintf("Unexpected error %s", err), t)
+
+	_, err = ExecuteTemplate(tmpl, "app/Dockerfile.template", map[string]string{})
+
+	expected = "labels.tmpl:1: undefined property MAINTAINER"
+	testassist.VerifyCondition(err != nil && err.Error() == expected, fmt.Sprintf("Expected %s, got %v", expected, err), t)
+}
+
+func TestMissingTemplates(t *testing.T) {
+	tmpl, err := ParseTemplate("Dockerfile.template", `{{template "labels" .}}{{include "common/user.tmpl" .}}{{include "common/missing.tmpl" .}}{{template "other" .}}`, TemplateOptions{Partials: givenPartials(t)})
+	testassist.VerifyCondition(err == nil, fmt.Sprintf("Unexpected error %s", err), t)
+
+	expected := []string{"common/missing.tmpl", "other"}
+	actual := MissingTemplates(tmpl)
+	testassist.VerifyCondition(reflect.DeepEqual(expected, actual), fmt.Sprintf("Expected %s, got %s", expected, actual), t)
+}
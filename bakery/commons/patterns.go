@@ -0,0 +1,222 @@
+package commons
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// IgnorePatterns holds ignore rules in the .dockerignore format. Rules are evaluated in order, the last matching rule wins.
+// Rules starting with `!` re-include previously ignored paths.
+type IgnorePatterns struct {
+	patterns []ignorePattern
+}
+
+type ignorePattern struct {
+	negate bool
+	// dirOnly patterns match only directories
+	dirOnly bool
+	// glob is the rule without the `!` prefix, used to tell whenever negated rule may match inside a directory
+	glob   string
+	regexp *regexp.Regexp
+}
+
+// NewIgnorePatterns parses provided rules. Empty lines and lines starting with `#` are skipped.
+func NewIgnorePatterns(rules []string) (*IgnorePatterns, error) {
+	return newIgnorePatterns(rules, false)
+}
+
+// NewGitIgnorePatterns parses provided rules in the .gitignore format. Unlike in the .dockerignore format, patterns without
+// a slash (apart from the trailing one) match at any depth, leading slash anchors the pattern to the directory of the rules
+// and trailing slash makes the pattern match only directories.
+func NewGitIgnorePatterns(rules []string) (*IgnorePatterns, error) {
+	return newIgnorePatterns(rules, true)
+}
+
+func newIgnorePatterns(rules []string, gitignore bool) (*IgnorePatterns, error) {
+	ip := &IgnorePatterns{patterns: make([]ignorePattern, 0, len(rules))}
+	for _, rule := range rules {
+		rule = strings.TrimSpace(rule)
+		if rule == "" || strings.HasPrefix(rule, "#") {
+			continue
+		}
+
+		negate := strings.HasPrefix(rule, "!")
+		rule = strings.TrimPrefix(rule, "!")
+		dirOnly := false
+		if gitignore {
+			dirOnly = strings.HasSuffix(rule, "/")
+			rule = strings.TrimSuffix(rule, "/")
+			if !strings.Contains(rule, "/") {
+				rule = "**/" + rule
+			}
+		}
+		rule = strings.TrimPrefix(path.Clean("/"+rule), "/")
+		re, err := GlobToRegexp(rule)
+		if err != nil {
+			return nil, err
+		}
+		ip.patterns = append(ip.patterns, ignorePattern{negate: negate, dirOnly: dirOnly, glob: rule, regexp: re})
+	}
+	return ip, nil
+}
+
+// ReadIgnoreFile reads ignore rules from the provided file. Returns empty rules when file does not exist.
+func ReadIgnoreFile(fileName string) (*IgnorePatterns, error) {
+	rules, err := readRules(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return NewIgnorePatterns(rules)
+}
+
+// ReadGitIgnoreFile reads ignore rules in the .gitignore format from the provided file followed by the additional rules.
+// Returns only the additional rules when file does not exist.
+func ReadGitIgnoreFile(fileName string, additionalRules []string) (*IgnorePatterns, error) {
+	rules, err := readRules(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return NewGitIgnorePatterns(append(rules, additionalRules...))
+}
+
+func readRules(fileName string) ([]string, error) {
+	rules := make([]string, 0)
+	f, err := os.Open(fileName)
+	if os.IsNotExist(err) {
+		return rules, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		rules = append(rules, scanner.Text())
+	}
+	return rules, scanner.Err()
+}
+
+// Matches returns true if the slash separated path (relative to the directory of the rules) is ignored.
+// Path is also ignored when any of its parent directories is ignored.
+func (ip *IgnorePatterns) Matches(relPath string) bool {
+	return ip.MatchesPath(relPath, false)
+}
+
+// MatchesPath returns true if the slash separated path (relative to the directory of the rules) is ignored,
+// isDir tells whenever the path is a directory matched by the directory only patterns.
+// Path is also ignored when any of its parent directories is ignored.
+func (ip *IgnorePatterns) MatchesPath(relPath string, isDir bool) bool {
+	relPath = strings.TrimPrefix(path.Clean("/"+relPath), "/")
+	ignored := false
+	for _, p := range ip.patterns {
+		if p.matchesPathOrParent(relPath, isDir) {
+			ignored = !p.negate
+		}
+	}
+	return ignored
+}
+
+// MayReinclude returns true when any negated rule may match a path inside the slash separated directory.
+// Ignored directory can be skipped as a whole only when none of its files can be re-included.
+func (ip *IgnorePatterns) MayReinclude(dirPath string) bool {
+	dirSegments := strings.Split(strings.TrimPrefix(path.Clean("/"+dirPath), "/"), "/")
+	for _, p := range ip.patterns {
+		if p.negate && p.mayMatchInside(dirSegments) {
+			return true
+		}
+	}
+	return false
+}
+
+// mayMatchInside compares the pattern with the directory segment by segment, `**` may match anything below the directory.
+// Pattern matching the directory itself or any of its parents matches everything inside it as well.
+func (p ignorePattern) mayMatchInside(dirSegments []string) bool {
+	patternSegments := strings.Split(p.glob, "/")
+	for i, dirSegment := range dirSegments {
+		if i >= len(patternSegments) {
+			return true
+		}
+		if strings.Contains(patternSegments[i], "**") {
+			return true
+		}
+		if !MatchGlob(patternSegments[i], dirSegment) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p ignorePattern) matchesPathOrParent(relPath string, isDir bool) bool {
+	for candidate := relPath; candidate != "." && candidate != ""; candidate = path.Dir(candidate) {
+		// parents of the path are always directories
+		candidateIsDir := isDir || candidate != relPath
+		if (!p.dirOnly || candidateIsDir) && p.regexp.MatchString(candidate) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchGlob returns true if the slash separated name matches the glob pattern.
+// Apart from the path.Match syntax the `**` matches any number of directories.
+func MatchGlob(pattern, name string) bool {
+	re, err := GlobToRegexp(pattern)
+	if err != nil {
+		return false
+	}
+	return re.MatchString(name)
+}
+
+// GlobToRegexp converts glob pattern into the regular expression matching entire slash separated path.
+// `*` matches any sequence of non separator characters, `?` matches single non separator character,
+// `**` matches any number of directories and `[...]` matches character class.
+func GlobToRegexp(pattern string) (*regexp.Regexp, error) {
+	var re strings.Builder
+	re.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+				if i+1 < len(pattern) && pattern[i+1] == '/' {
+					// `**/` matches zero or more directories
+					i++
+					re.WriteString("(.*/)?")
+				} else {
+					re.WriteString(".*")
+				}
+			} else {
+				re.WriteString("[^/]*")
+			}
+		case '?':
+			re.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[i:], ']')
+			if end < 0 {
+				re.WriteString(regexp.QuoteMeta(pattern[i:]))
+				i = len(pattern)
+				continue
+			}
+			class := pattern[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			re.WriteString("[" + class + "]")
+			i += end
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+			}
+			re.WriteString(regexp.QuoteMeta(string(pattern[i])))
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	re.WriteString("$")
+	return regexp.Compile(re.String())
+}
@@ -0,0 +1,94 @@
+package commons
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/smartrecruiters/docker-bakery/bakery/commons/testassist"
+)
+
+func TestMatchGlob(t *testing.T) {
+	testCases := []testassist.TestCase{
+		{Expected: true, Input: []string{"jdk-*", "jdk-14"}},
+		{Expected: false, Input: []string{"jdk-*", "base/jdk-14"}},
+		{Expected: true, Input: []string{"**/jdk-*", "base/jdk-14"}},
+		{Expected: true, Input: []string{"**/jdk-*", "jdk-14"}},
+		{Expected: true, Input: []string{"base/**", "base/java/jdk-14"}},
+		{Expected: true, Input: []string{"jdk-1?", "jdk-14"}},
+		{Expected: true, Input: []string{"jdk-[0-9]*", "jdk-14"}},
+		{Expected: false, Input: []string{"jdk-[!0-9]*", "jdk-14"}},
+		{Expected: false, Input: []string{"jdk.*", "jdk-14"}},
+	}
+
+	for i, tc := range testCases {
+		args := tc.Input.([]string)
+		actual := MatchGlob(args[0], args[1])
+		testassist.VerifyCondition(tc.Expected.(bool) == actual, fmt.Sprintf("TestCase: %d Expected %v, got %v for %s", i, tc.Expected, actual, args), t)
+	}
+}
+
+func TestIgnorePatterns(t *testing.T) {
+	patterns, err := NewIgnorePatterns([]string{"# comment", "*.md", "tmp", "!README.md", "/build/**/*.o"})
+	testassist.VerifyCondition(err == nil, fmt.Sprintf("Unexpected error %s", err), t)
+
+	testCases := []testassist.TestCase{
+		{Expected: true, Input: "CHANGELOG.md"},
+		{Expected: false, Input: "README.md"},
+		{Expected: false, Input: "docs/CHANGELOG.md"},
+		{Expected: true, Input: "tmp"},
+		{Expected: true, Input: "tmp/file.txt"},
+		{Expected: true, Input: "build/a/b/main.o"},
+		{Expected: false, Input: "build/main.c"},
+		{Expected: false, Input: "Dockerfile"},
+	}
+
+	for i, tc := range testCases {
+		actual := patterns.Matches(tc.Input.(string))
+		testassist.VerifyCondition(tc.Expected.(bool) == actual, fmt.Sprintf("TestCase: %d Expected %v, got %v for %s", i, tc.Expected, actual, tc.Input), t)
+	}
+}
+
+func TestIgnorePatternsMayReinclude(t *testing.T) {
+	patterns, err := NewIgnorePatterns([]string{"*", "!src/main.go", "!docs/**/*.md", "!lib/*/include"})
+	testassist.VerifyCondition(err == nil, fmt.Sprintf("Unexpected error %s", err), t)
+
+	testCases := []testassist.TestCase{
+		{Expected: true, Input: "src"},
+		{Expected: false, Input: "src/vendor"},
+		{Expected: false, Input: "build"},
+		{Expected: true, Input: "docs/api/v1"},
+		{Expected: true, Input: "lib/openssl"},
+		{Expected: true, Input: "lib/openssl/include/crypto"},
+		{Expected: false, Input: "lib/openssl/src"},
+	}
+
+	for i, tc := range testCases {
+		actual := patterns.MayReinclude(tc.Input.(string))
+		testassist.VerifyCondition(tc.Expected.(bool) == actual, fmt.Sprintf("TestCase: %d Expected %v, got %v for %s", i, tc.Expected, actual, tc.Input), t)
+	}
+	testassist.VerifyCondition(!patterns.Matches("src/main.go"), "src/main.go should be re-included", t)
+}
+
+func TestGitIgnorePatterns(t *testing.T) {
+	patterns, err := NewGitIgnorePatterns([]string{"node_modules", "/archive", "fixtures/", "docs/*.tmp", "!keep.tmp"})
+	testassist.VerifyCondition(err == nil, fmt.Sprintf("Unexpected error %s", err), t)
+
+	testCases := []testassist.TestCase{
+		{Expected: true, Input: []interface{}{"node_modules", true}},
+		{Expected: true, Input: []interface{}{"web/node_modules/lib/Dockerfile.template", false}},
+		{Expected: true, Input: []interface{}{"archive/old", true}},
+		{Expected: false, Input: []interface{}{"images/archive", true}},
+		{Expected: true, Input: []interface{}{"test/fixtures", true}},
+		{Expected: true, Input: []interface{}{"test/fixtures/Dockerfile.template", false}},
+		{Expected: false, Input: []interface{}{"test/fixtures", false}},
+		{Expected: true, Input: []interface{}{"docs/a.tmp", false}},
+		{Expected: false, Input: []interface{}{"docs/keep.tmp", false}},
+		{Expected: false, Input: []interface{}{"base/Dockerfile.template", false}},
+	}
+
+	for i, tc := range testCases {
+		args := tc.Input.([]interface{})
+		actual := patterns.MatchesPath(args[0].(string), args[1].(bool))
+		testassist.VerifyCondition(tc.Expected.(bool) == actual, fmt.Sprintf("TestCase: %d Expected %v, got %v for %s", i, tc.Expected, actual, args), t)
+	}
+}
@@ -0,0 +1,87 @@
+package commons
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/Masterminds/semver"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+)
+
+// TemplateFuncs returns functions available in all templates. Relative paths passed to the file functions
+// are resolved against the provided dir.
+//   - upper, lower, title, trim - change case of the string or trim the white spaces
+//   - replace OLD NEW STRING - replaces all occurrences of OLD with NEW
+//   - default DEFAULT VALUE - returns VALUE or DEFAULT when VALUE is empty
+//   - coalesce VALUES... - returns the first non empty value
+//   - semverMajor, semverMinor, semverPatch VERSION - returns part of the semantic version
+//   - env NAME - returns value of the environment variable
+//   - sha256file PATH - returns hex encoded sha256 checksum of the file
+func TemplateFuncs(dir string) template.FuncMap {
+	return template.FuncMap{
+		"upper":       strings.ToUpper,
+		"lower":       strings.ToLower,
+		"title":       title,
+		"trim":        strings.TrimSpace,
+		"replace":     replaceAll,
+		"default":     defaultValue,
+		"coalesce":    coalesce,
+		"semverMajor": semverPart(func(v *semver.Version) int64 { return v.Major() }),
+		"semverMinor": semverPart(func(v *semver.Version) int64 { return v.Minor() }),
+		"semverPatch": semverPart(func(v *semver.Version) int64 { return v.Patch() }),
+		"env":         os.Getenv,
+		"sha256file":  sha256File(dir),
+	}
+}
+
+// title upper cases the first letter of every word, words are recognised with the Unicode rules.
+func title(s string) string {
+	return cases.Title(language.Und, cases.NoLower).String(s)
+}
+
+func replaceAll(old, new, s string) string {
+	return strings.Replace(s, old, new, -1)
+}
+
+func defaultValue(defaultValue, value string) string {
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+func coalesce(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func semverPart(part func(v *semver.Version) int64) func(string) (string, error) {
+	return func(version string) (string, error) {
+		v, err := semver.NewVersion(version)
+		if err != nil {
+			return "", fmt.Errorf("invalid semantic version %q: %s", version, err)
+		}
+		return fmt.Sprintf("%d", part(v)), nil
+	}
+}
+
+func sha256File(dir string) func(string) (string, error) {
+	return func(fileName string) (string, error) {
+		if !filepath.IsAbs(fileName) {
+			fileName = filepath.Join(dir, fileName)
+		}
+		checksum, err := FileSHA256(fileName)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimPrefix(checksum, "sha256:"), nil
+	}
+}
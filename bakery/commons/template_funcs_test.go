@@ -0,0 +1,48 @@
+package commons
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"text/template"
+
+	"github.com/smartrecruiters/docker-bakery/bakery/commons/testassist"
+)
+
+func TestTemplateFuncs(t *testing.T) {
+	dir := t.TempDir()
+	err := ioutil.WriteFile(filepath.Join(dir, "requirements.txt"), []byte("flask\n"), 0644)
+	testassist.VerifyCondition(err == nil, fmt.Sprintf("Unexpected error %s", err), t)
+	t.Setenv("BAKERY_TEST_ENV", "from-env")
+
+	testCases := []testassist.TestCase{
+		{Expected: "OPENJDK", Input: `{{upper .NAME}}`},
+		{Expected: "openjdk", Input: `{{lower "OpenJDK"}}`},
+		{Expected: "Open Jdk", Input: `{{title "open jdk"}}`},
+		{Expected: "Ölbild OpenJDK", Input: `{{title "ölbild openJDK"}}`},
+		{Expected: "jdk", Input: `{{trim "  jdk "}}`},
+		{Expected: "open_jdk", Input: `{{replace "-" "_" "open-jdk"}}`},
+		{Expected: "8u144", Input: `{{default "8u144" .MISSING}}`},
+		{Expected: "openjdk", Input: `{{.NAME | default "8u144"}}`},
+		{Expected: "openjdk", Input: `{{coalesce "" .MISSING .NAME}}`},
+		{Expected: "1.2.3", Input: `{{semverMajor .VERSION}}.{{semverMinor .VERSION}}.{{semverPatch .VERSION}}`},
+		{Expected: "from-env", Input: `{{env "BAKERY_TEST_ENV"}}`},
+		{Expected: "76f8bae45e807865955344c1a58882d38c8ceb4f855f58091642b7d48290af97", Input: `{{sha256file "requirements.txt"}}`},
+	}
+
+	for i, tc := range testCases {
+		tmpl := template.Must(NewTemplate("test", TemplateOptions{Dir: dir}).Parse(tc.Input.(string)))
+		actual, err := ExecuteTemplate(tmpl, "test", map[string]string{"NAME": "openjdk", "MISSING": "", "VERSION": "1.2.3"})
+		testassist.VerifyCondition(err == nil, fmt.Sprintf("TestCase: %d Unexpected error %s", i, err), t)
+		testassist.VerifyCondition(tc.Expected.(string) == string(actual), fmt.Sprintf("TestCase: %d Expected %s, got %s", i, tc.Expected, actual), t)
+	}
+}
+
+func TestTemplateFuncsReportInvalidVersion(t *testing.T) {
+	tmpl := template.Must(NewTemplate("test", TemplateOptions{}).Parse(`{{semverMajor .VERSION}}`))
+
+	_, err := ExecuteTemplate(tmpl, "test", map[string]string{"VERSION": "latest"})
+
+	testassist.VerifyCondition(err != nil, "Expected error for invalid semantic version", t)
+}
@@ -0,0 +1,281 @@
+package commons
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// TemplateOptions configures parsing and execution of the templates.
+type TemplateOptions struct {
+	// Strict makes the execution fail when template references a key that is missing in the mapping
+	// instead of rendering it as `<no value>`.
+	Strict bool
+	// Dir is used to resolve relative paths passed to the template functions, defaults to the dir of the template file
+	Dir string
+	// Funcs are added to the functions available in all templates (see TemplateFuncs)
+	Funcs template.FuncMap
+	// Partials are added to the parsed templates, nil when there are no partials
+	Partials *Partials
+}
+
+// MissingKeyError is returned when strict template references a key that is missing in the mapping.
+type MissingKeyError struct {
+	// Source is the template file path or name of the template
+	Source string
+	Line   int
+	Key    string
+}
+
+func (e *MissingKeyError) Error() string {
+	return fmt.Sprintf("%s:%d: undefined property %s", e.Source, e.Line, e.Key)
+}
+
+// NewTemplate creates new empty template configured with provided options.
+// Besides the functions from the options the template is provided with the include function
+// that renders the associated template (e.g. partial) and returns the result as a string.
+func NewTemplate(name string, options TemplateOptions) *template.Template {
+	t := template.New(name).Funcs(TemplateFuncs(options.Dir)).Funcs(options.Funcs)
+	t.Funcs(template.FuncMap{includeFuncName: func(name string, data interface{}) (string, error) {
+		var buf bytes.Buffer
+		err := t.ExecuteTemplate(&buf, name, data)
+		return buf.String(), err
+	}})
+	if options.Strict {
+		t = t.Option("missingkey=error")
+	}
+	return t
+}
+
+// ParseTemplate parses template from the text along with the partials from the options.
+// Templates defined in the text take precedence over the partials with the same name.
+func ParseTemplate(name, text string, options TemplateOptions) (*template.Template, error) {
+	t := NewTemplate(name, options)
+	if options.Partials != nil {
+		if err := options.Partials.addTo(t); err != nil {
+			return nil, err
+		}
+	}
+	return t.Parse(text)
+}
+
+// ParseTemplateFile parses template from the file, template is named after the file base name.
+func ParseTemplateFile(templatePath string, options TemplateOptions) (*template.Template, error) {
+	content, err := ioutil.ReadFile(templatePath)
+	if err != nil {
+		return nil, err
+	}
+	if options.Dir == "" {
+		options.Dir = filepath.Dir(templatePath)
+	}
+	return ParseTemplate(filepath.Base(templatePath), string(content), options)
+}
+
+// missingKeyErrorPattern matches the error of the template execution caused by the key missing in the mapping
+var missingKeyErrorPattern = regexp.MustCompile(`map has no entry for key ("(?:[^"\\]|\\.)*")$`)
+
+// ExecuteTemplate executes template with the provided mapping and returns the result.
+// Missing keys of the strict template are reported as MissingKeyError naming the source, line and the key.
+func ExecuteTemplate(t *template.Template, source string, mapping map[string]string) ([]byte, error) {
+	var buf bytes.Buffer
+	err := t.Execute(&buf, mapping)
+	if err != nil {
+		if missingKey := findMissingKey(t, source, mapping, err); missingKey != nil {
+			return nil, missingKey
+		}
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// findMissingKey returns the missing key the execution error was caused by, nil when the error has other cause.
+// When the key is missing in multiple places, the one in the template that failed is preferred.
+func findMissingKey(t *template.Template, source string, mapping map[string]string, err error) *MissingKeyError {
+	var execErr template.ExecError
+	if !errors.As(err, &execErr) {
+		return nil
+	}
+	groups := missingKeyErrorPattern.FindStringSubmatch(execErr.Err.Error())
+	if groups == nil {
+		return nil
+	}
+	key, unquoteErr := strconv.Unquote(groups[1])
+	if unquoteErr != nil {
+		return nil
+	}
+
+	var found *MissingKeyError
+	for _, missingKey := range missingKeys(t, source, mapping) {
+		if missingKey.Key != key {
+			continue
+		}
+		templateName := missingKey.Source
+		if templateName == source {
+			templateName = t.Name()
+		}
+		if templateName == execErr.Name {
+			return missingKey
+		}
+		if found == nil {
+			found = missingKey
+		}
+	}
+	return found
+}
+
+// missingKeys returns the fields referenced in the template and the templates it uses that are missing in the mapping,
+// in the order of their occurrence. Fields missing in the partials are reported with the partial as the source.
+func missingKeys(t *template.Template, source string, mapping map[string]string) []*MissingKeyError {
+	missing := make([]*MissingKeyError, 0)
+	walkTrees(t, func(tree *parse.Tree, node parse.Node, sameDot bool) {
+		field, isField := node.(*parse.FieldNode)
+		if !isField || !sameDot || len(field.Ident) == 0 {
+			return
+		}
+		if _, exists := mapping[field.Ident[0]]; exists {
+			return
+		}
+		missingKey := &MissingKeyError{Source: source, Key: field.Ident[0]}
+		// location has the form of name:line:column, name being the template (file) the node was parsed from
+		location, _ := tree.ErrorContext(node)
+		parts := strings.Split(location, ":")
+		if len(parts) >= 3 {
+			missingKey.Line, _ = strconv.Atoi(parts[len(parts)-2])
+			if name := strings.Join(parts[:len(parts)-2], ":"); name != t.Name() {
+				missingKey.Source = name
+			}
+		}
+		missing = append(missing, missingKey)
+	})
+	return missing
+}
+
+// FunctionCall describes call of the template function.
+type FunctionCall struct {
+	Name string
+	// Args holds values of the string literal arguments, other arguments are empty
+	Args []string
+}
+
+// ReferencedFields returns sorted names of the top level fields (`{{.NAME}}`) referenced in the template and the templates it uses.
+// Fields referenced inside `range` and `with` blocks are skipped as the dot is rebound there.
+func ReferencedFields(t *template.Template) []string {
+	fields := make(map[string]bool)
+	walkTemplates(t, func(node parse.Node, sameDot bool) {
+		if field, isField := node.(*parse.FieldNode); isField && sameDot && len(field.Ident) > 0 {
+			fields[field.Ident[0]] = true
+		}
+	})
+
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// FunctionCalls returns calls of the functions in the template and the templates it uses.
+func FunctionCalls(t *template.Template) []FunctionCall {
+	calls := make([]FunctionCall, 0)
+	walkTemplates(t, func(node parse.Node, sameDot bool) {
+		cmd, isCmd := node.(*parse.CommandNode)
+		if !isCmd || len(cmd.Args) == 0 {
+			return
+		}
+		identifier, isIdentifier := cmd.Args[0].(*parse.IdentifierNode)
+		if !isIdentifier {
+			return
+		}
+		call := FunctionCall{Name: identifier.Ident, Args: make([]string, 0, len(cmd.Args)-1)}
+		for _, arg := range cmd.Args[1:] {
+			value := ""
+			if str, isString := arg.(*parse.StringNode); isString {
+				value = str.Text
+			}
+			call.Args = append(call.Args, value)
+		}
+		calls = append(calls, call)
+	})
+	return calls
+}
+
+// walkTemplates visits all nodes of the template and the associated templates it uses, directly or through other templates.
+// Associated templates that are not used (e.g. partials not included by the template) are not visited, every used one is visited once.
+// Visitor is informed whenever the dot in the visited node is the same as the one passed to the template.
+func walkTemplates(t *template.Template, visit func(node parse.Node, sameDot bool)) {
+	walkTrees(t, func(tree *parse.Tree, node parse.Node, sameDot bool) {
+		visit(node, sameDot)
+	})
+}
+
+// walkTrees works like walkTemplates, visitor is also provided with the parse tree of the visited node.
+func walkTrees(t *template.Template, visit func(tree *parse.Tree, node parse.Node, sameDot bool)) {
+	visited := map[string]bool{t.Name(): true}
+	queue := []string{t.Name()}
+	for len(queue) > 0 {
+		associated := t.Lookup(queue[0])
+		queue = queue[1:]
+		if associated == nil || associated.Tree == nil {
+			continue
+		}
+		tree := associated.Tree
+		walkNode(tree.Root, true, func(node parse.Node, sameDot bool) {
+			visit(tree, node, sameDot)
+			for _, referenced := range referencedTemplates(node) {
+				if !visited[referenced] {
+					visited[referenced] = true
+					queue = append(queue, referenced)
+				}
+			}
+		})
+	}
+}
+
+func walkNode(node parse.Node, sameDot bool, visit func(node parse.Node, sameDot bool)) {
+	if node == nil || reflect.ValueOf(node).IsNil() {
+		return
+	}
+	visit(node, sameDot)
+	switch n := node.(type) {
+	case *parse.ListNode:
+		for _, child := range n.Nodes {
+			walkNode(child, sameDot, visit)
+		}
+	case *parse.ActionNode:
+		walkNode(n.Pipe, sameDot, visit)
+	case *parse.IfNode:
+		walkBranch(&n.BranchNode, sameDot, sameDot, visit)
+	case *parse.RangeNode:
+		walkBranch(&n.BranchNode, sameDot, false, visit)
+	case *parse.WithNode:
+		walkBranch(&n.BranchNode, sameDot, false, visit)
+	case *parse.TemplateNode:
+		walkNode(n.Pipe, sameDot, visit)
+	case *parse.PipeNode:
+		for _, cmd := range n.Cmds {
+			walkNode(cmd, sameDot, visit)
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			walkNode(arg, sameDot, visit)
+		}
+	case *parse.ChainNode:
+		walkNode(n.Node, sameDot, visit)
+	}
+}
+
+func walkBranch(n *parse.BranchNode, sameDot, listSameDot bool, visit func(node parse.Node, sameDot bool)) {
+	walkNode(n.Pipe, sameDot, visit)
+	walkNode(n.List, listSameDot, visit)
+	walkNode(n.ElseList, sameDot, visit)
+}
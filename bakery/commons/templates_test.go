@@ -0,0 +1,77 @@
+package commons
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"text/template"
+
+	"github.com/smartrecruiters/docker-bakery/bakery/commons/testassist"
+)
+
+func TestReferencedFields(t *testing.T) {
+	tmpl := template.Must(template.New("Dockerfile.template").Parse(`FROM {{.REGISTRY}}/base:{{.BASE_VERSION}}
+{{if .DEBUG}}RUN echo {{.DEBUG_LEVEL}}{{else}}RUN echo {{.RELEASE}}{{end}}
+{{range .ITEMS}}RUN echo {{.Name}}{{end}}
+{{with .LABELS}}LABEL {{.Inner}}{{else}}LABEL {{.DEFAULT_LABEL}}{{end}}
+LABEL version={{.IMAGE_VERSION | printf "%s"}}
+{{template "partial" .}}
+{{define "partial"}}ENV PARTIAL={{.PARTIAL}}{{end}}
+{{define "unused"}}ENV UNUSED={{.UNUSED}}{{end}}`))
+
+	expected := []string{"BASE_VERSION", "DEBUG", "DEBUG_LEVEL", "DEFAULT_LABEL", "IMAGE_VERSION", "ITEMS", "LABELS", "PARTIAL", "REGISTRY", "RELEASE"}
+	actual := ReferencedFields(tmpl)
+	testassist.VerifyCondition(reflect.DeepEqual(expected, actual), fmt.Sprintf("Expected %s, got %s", expected, actual), t)
+}
+
+func TestExecuteStrictTemplateReportsMissingKey(t *testing.T) {
+	tmpl := template.Must(NewTemplate("Dockerfile.template", TemplateOptions{Strict: true}).Parse("FROM base:{{.BASE_VERSION}}\nENV JDK={{.JDK8_VERSON}}\n"))
+
+	_, err := ExecuteTemplate(tmpl, "jdk8/Dockerfile.template", map[string]string{"BASE_VERSION": "1.0.0", "JDK8_VERSION": "8u144"})
+
+	expected := &MissingKeyError{Source: "jdk8/Dockerfile.template", Line: 2, Key: "JDK8_VERSON"}
+	testassist.VerifyCondition(reflect.DeepEqual(expected, err), fmt.Sprintf("Expected %v, got %v", expected, err), t)
+	testassist.VerifyCondition(err.Error() == "jdk8/Dockerfile.template:2: undefined property JDK8_VERSON", fmt.Sprintf("Unexpected error message %s", err), t)
+}
+
+func TestExecuteStrictTemplateReportsMissingKeyThatFailedTheExecution(t *testing.T) {
+	tmpl := template.Must(NewTemplate("Dockerfile.template", TemplateOptions{Strict: true}).Parse(
+		"FROM {{template \"TAG\" .}}\nENV TAG={{.TAG}} REGISTRY={{.REGISTRY}}\n{{define \"TAG\"}}{{.REGISTRY_URL}}/base:{{.VERSION}}{{end}}"))
+
+	_, err := ExecuteTemplate(tmpl, "app/Dockerfile.template", map[string]string{"REGISTRY_URL": "registry.local"})
+
+	expected := &MissingKeyError{Source: "app/Dockerfile.template", Line: 3, Key: "VERSION"}
+	testassist.VerifyCondition(reflect.DeepEqual(expected, err), fmt.Sprintf("Expected %v, got %v", expected, err), t)
+
+	_, err = ExecuteTemplate(tmpl, "app/Dockerfile.template", map[string]string{"REGISTRY_URL": "registry.local", "VERSION": "1.0.0", "TAG": "1.0.0"})
+
+	expected = &MissingKeyError{Source: "app/Dockerfile.template", Line: 2, Key: "REGISTRY"}
+	testassist.VerifyCondition(reflect.DeepEqual(expected, err), fmt.Sprintf("Expected %v, got %v", expected, err), t)
+}
+
+func TestExecuteLenientTemplateRendersMissingKey(t *testing.T) {
+	tmpl := template.Must(NewTemplate("Dockerfile.template", TemplateOptions{}).Parse("ENV JDK={{.JDK8_VERSON}}"))
+
+	content, err := ExecuteTemplate(tmpl, "jdk8/Dockerfile.template", map[string]string{})
+
+	testassist.VerifyCondition(err == nil, fmt.Sprintf("Unexpected error %s", err), t)
+	testassist.VerifyCondition(string(content) == "ENV JDK=<no value>", fmt.Sprintf("Unexpected content %s", content), t)
+}
+
+func TestFunctionCalls(t *testing.T) {
+	tmpl := template.Must(NewTemplate("Dockerfile.template", TemplateOptions{Funcs: template.FuncMap{
+		"parentImage": func() string { return "" },
+		"imageRef":    func(string) string { return "" },
+	}}).Parse(`FROM {{parentImage}}
+COPY --from={{imageRef "builder"}} /app /app
+{{range .ITEMS}}RUN echo {{sha256file "items.txt"}} {{upper .}}{{end}}`))
+
+	expected := []FunctionCall{
+		{Name: "parentImage", Args: []string{}},
+		{Name: "imageRef", Args: []string{"builder"}},
+		{Name: "sha256file", Args: []string{"items.txt"}},
+		{Name: "upper", Args: []string{""}},
+	}
+	actual := FunctionCalls(tmpl)
+	testassist.VerifyCondition(reflect.DeepEqual(expected, actual), fmt.Sprintf("Expected %v, got %v", expected, actual), t)
+}
@@ -1,21 +1,15 @@
 package service
 
 import (
-	"bytes"
+	"context"
 	"fmt"
 	"os"
 	"os/exec"
 	"path"
 	"regexp"
 	"strings"
-	"text/template"
-
-	"os/signal"
-	"syscall"
-
 	"time"
 
-	"github.com/Masterminds/semver"
 	"github.com/fatih/color"
 	"github.com/smartrecruiters/docker-bakery/bakery/commons"
 )
@@ -25,354 +19,685 @@ const (
 	unableToDetermine         = "unable-to-determine"
 	dependencyPrefix          = "FROM "
 	outputSeparator           = "====================================================================\n"
+	// terminationGracePeriod is how long the commands may take to exit after SIGTERM is sent to them on cancellation
+	terminationGracePeriod = 10 * time.Second
 )
 
-var config *Config
-var dependencies map[string][]*DockerImage
-var commandErrors = make([]error, 0)
-var commandResults = make([]*CommandResult, 0)
-var dockerImgParser = NewDockerImageParser()
-var versions map[string]*semver.Version
-var hierarchy = NewDockerHierarchy()
-var startTime = time.Now()
-
-// InitConfiguration is called before execution of other commands, parses config and gathers docker image dependencies/hierarchy
-func InitConfiguration(configFile, rootDir string, additionalProperties []string) error {
+// initConfiguration is called before execution of other commands, parses config and gathers docker image dependencies/hierarchy.
+// Provided config is used instead of reading the config file when it is not nil.
+func (e *Engine) initConfiguration(ctx context.Context, cfg *Config, configFile, rootDir, profile string, additionalProperties []string) error {
 	var err error
-	if configFile == "" {
-		return fmt.Errorf("config file path has to be provided")
+	if cfg != nil {
+		if err = cfg.prepare(); err != nil {
+			return fmt.Errorf("invalid config: %s", err)
+		}
+		e.config = cfg
+	} else {
+		if configFile == "" {
+			return fmt.Errorf("config file path has to be provided")
+		}
+		e.config, err = ReadConfig(configFile)
+		if err != nil {
+			return fmt.Errorf("could not read config file from %s due to: %s", configFile, err)
+		}
 	}
 
-	config, err = ReadConfig(configFile)
+	err = e.config.applyProfile(profile)
 	if err != nil {
-		return fmt.Errorf("could not read config file from %s due to: %s", configFile, err)
+		return err
+	}
+	if profile != "" {
+		fmt.Fprintf(e.stdout, "Using profile: %s\n", profile)
 	}
 
-	if config.RootDir == "" {
-		config.RootDir = path.Dir(configFile)
-		fmt.Printf("RootDir not defined in config, applying config parent dir: %s\n", config.RootDir)
+	if e.config.RootDir == "" && configFile != "" {
+		e.config.RootDir = path.Dir(configFile)
+		fmt.Fprintf(e.stdout, "RootDir not defined in config, applying config parent dir: %s\n", e.config.RootDir)
 	}
 
 	if rootDir != "" {
-		fmt.Printf("Overriding config rootDir to: %s\n", rootDir)
-		config.RootDir = rootDir
+		fmt.Fprintf(e.stdout, "Overriding config rootDir to: %s\n", rootDir)
+		e.config.RootDir = rootDir
+	}
+	if e.config.RootDir == "" {
+		e.config.RootDir = "."
 	}
 
-	versions, err = GetLatestVersions()
+	err = e.loadPartials()
 	if err != nil {
 		return err
 	}
 
-	updateConfigProperties(additionalProperties)
+	e.versions, err = e.versionStore.LatestVersions(ctx)
+	if err != nil {
+		return err
+	}
 
-	err = hierarchy.AnalyzeStructure(config.RootDir, versions)
+	err = e.loadLockFile()
 	if err != nil {
 		return err
 	}
 
-	updateUnknownParentsVersions(hierarchy)
+	e.updateConfigProperties(ctx, additionalProperties)
+
+	ignored, err := e.config.readIgnoredPaths()
+	if err != nil {
+		return err
+	}
+	imageParser := e.newCachingImageParser(newDockerImageParser(e.logger, e.stdout))
+	if imageParser != nil {
+		e.dockerImgParser = imageParser
+		e.hierarchy = newDockerHierarchy(imageParser, e.logger, e.stdout)
+	}
+	err = e.hierarchy.AnalyzeStructure(e.config.RootDir, e.versions, ignored)
+	if err != nil {
+		return err
+	}
+	imageParser.save()
+	e.config.setDirectoryOverrides(e.hierarchy.GetDirectoryOverrides())
 
-	rootName := fmt.Sprintf("Dockerfiles hierarchy discovered in %s", config.RootDir)
-	hierarchy.PrintImageHierarchy(rootName)
-	dependencies = hierarchy.GetImagesWithDependants()
+	e.updateUnknownParentsVersions(e.hierarchy)
+
+	e.dependencies = e.hierarchy.GetImagesWithDependants()
 
 	return nil
 }
 
 // updateUnknownParentsVersions makes sure that when building an image whose parent x_VERSION from template is unknown,
 // we're still able to produce valid Dockerfile with parent image version set to 0.0.0
-func updateUnknownParentsVersions(h DockerHierarchy) {
+func (e *Engine) updateUnknownParentsVersions(h DockerHierarchy) {
 	nonExisting := make([]string, 0)
 	for imageName := range h.GetImages() {
 		dynamicName := dynamicImageVersionName(imageName)
-		if _, ok := config.Properties[dynamicName]; !ok {
+		if _, ok := e.config.Properties[dynamicName]; !ok {
 			nonExisting = append(nonExisting, imageName)
 		}
 	}
 	for _, imageName := range nonExisting {
-		config.setDynamicImageVersionProperty(imageName, "0.0.0")
+		e.setDynamicImageVersionProperty(imageName, "0.0.0")
 	}
 }
 
-// DumpLatestVersions saves images with their latest version in json format to file.
-// Optionally it can exclude images from provided directories.
-func DumpLatestVersions(fileName, excludeDirsPattern string) error {
-	filterOutImagesFromDirs(excludeDirsPattern)
-	filterOutNotExistingImages()
-	return commons.WriteToJSONFile(versions, fileName)
+// dumpLatestVersions saves images with their latest version in json format to file.
+// Optionally it can exclude images from provided directories, images not passing the image filter are excluded as well.
+func (e *Engine) dumpLatestVersions(fileName, excludeDirsPattern string) error {
+	err := e.filterOutImagesFromDirs(excludeDirsPattern)
+	if err != nil {
+		return err
+	}
+	e.filterOutNotExistingImages()
+	e.filterOutNotSelectedImages()
+	return commons.WriteToJSONFile(e.versions, fileName)
 }
 
 // filterOutImagesFromDirs removes images that are stored in directories that match provided pattern.
-func filterOutImagesFromDirs(excludeDirsPattern string) {
+func (e *Engine) filterOutImagesFromDirs(excludeDirsPattern string) error {
 	if len(excludeDirsPattern) <= 0 {
-		return
+		return nil
 	}
 
-	r, _ := regexp.Compile(excludeDirsPattern)
-	images := hierarchy.GetImages()
+	r, err := regexp.Compile(excludeDirsPattern)
+	if err != nil {
+		return fmt.Errorf("invalid exclude dirs pattern %s: %s", excludeDirsPattern, err)
+	}
+	images := e.hierarchy.GetImages()
 	for imgName, img := range images {
 		shouldExcludeImage := r.MatchString(img.DockerfileDir)
 		if shouldExcludeImage {
-			delete(versions, imgName)
-			commons.Debugf("Excluding image from %s as it matches pattern %s", img.DockerfileDir, excludeDirsPattern)
+			delete(e.versions, imgName)
+			e.debugf("Excluding image from %s as it matches pattern %s", img.DockerfileDir, excludeDirsPattern)
+		}
+	}
+	return nil
+}
+
+// filterOutNotSelectedImages removes images that do not pass the image filter.
+func (e *Engine) filterOutNotSelectedImages() {
+	for imgName := range e.versions {
+		if !e.config.imageFilter.selects(imgName) {
+			delete(e.versions, imgName)
+			e.debugf("Excluding image %s as it does not pass the image filter", imgName)
 		}
 	}
 }
 
 // filterOutNotExistingImages removes images that no longer exist but still may be present in git tags.
-func filterOutNotExistingImages() {
-	images := hierarchy.GetImages()
-	for imgName := range versions {
+func (e *Engine) filterOutNotExistingImages() {
+	images := e.hierarchy.GetImages()
+	for imgName := range e.versions {
 		if _, exists := images[imgName]; !exists {
-			delete(versions, imgName)
-			commons.Debugf("Excluding non existing image %s", imgName)
+			delete(e.versions, imgName)
+			e.debugf("Excluding non existing image %s", imgName)
 		}
 	}
 }
 
-func updateConfigProperties(additionalProperties []string) {
+func (e *Engine) updateConfigProperties(ctx context.Context, additionalProperties []string) {
 	// update config properties with the latest versions of available images
 	// versions are determined from the git tags
 	// this is especially useful when for the first time new child image is about to be build (without being triggered by a parent build)
 	// and there is no PARENT_VERSION property defined in the config file
 	// in such case it will be determined dynamically from the git tags and and may be used in the child Dockerfile.template
-	config.UpdateVersionProperties(versions)
+	e.updateVersionProperties(e.versions)
 
 	// update the rest of config properties that stays the same for the duration of docker-bakery execution
-	name := getValue(GetGitUserName)
-	email := getValue(GetGitUserEmail)
-	host := getValue(os.Hostname)
+	name := e.getValue(withContext(ctx, e.getGitUserName))
+	email := e.getValue(withContext(ctx, e.getGitUserEmail))
+	host := e.getValue(os.Hostname)
 
-	config.setBuilderName(name)
-	config.setBuilderEmail(email)
-	config.setBuilderHost(host)
+	e.config.setBuilderName(name)
+	e.config.setBuilderEmail(email)
+	e.config.setBuilderHost(host)
+	e.config.setGitRevision(e.getValue(withContext(ctx, e.getGitRevision)))
+	e.config.setGitRepository(e.getValue(withContext(ctx, e.getGitRemoteURL)))
 
-	overrideWithRuntimeProvidedProperties(additionalProperties)
+	e.overrideWithRuntimeProvidedProperties(additionalProperties)
 }
 
-func overrideWithRuntimeProvidedProperties(additionalProperties []string) {
+func (e *Engine) overrideWithRuntimeProvidedProperties(additionalProperties []string) {
 	for _, additionalProperty := range additionalProperties {
 		keyValuePair := strings.Split(additionalProperty, propertyKeyValueSeparator)
 		if len(keyValuePair) != 2 {
-			fmt.Printf("Unable to parse provided property: %s - expecting key and value to be separated with %s", additionalProperty, propertyKeyValueSeparator)
+			fmt.Fprintf(e.stdout, "Unable to parse provided property: %s - expecting key and value to be separated with %s", additionalProperty, propertyKeyValueSeparator)
 			continue
 		}
-		commons.Debugf("Overriding config property: %s with %s", keyValuePair[0], keyValuePair[1])
-		config.Properties[keyValuePair[0]] = keyValuePair[1]
+		e.debugf("Overriding config property: %s with %s", keyValuePair[0], keyValuePair[1])
+		e.config.setRuntimeProperty(keyValuePair[0], keyValuePair[1])
 	}
 }
 
 // valueGetterFn represents no-arg function that returns a string and possibly an error
 type valueGetterFn func() (string, error)
 
+// withContext binds the context to the value getter requiring it.
+func withContext(ctx context.Context, propertyGetter func(context.Context) (string, error)) valueGetterFn {
+	return func() (string, error) {
+		return propertyGetter(ctx)
+	}
+}
+
 // getValue function is a small helper that obtains value from provided valueGetterFn function and
 // encapsulates common error handling
-func getValue(propertyGetter valueGetterFn) string {
+func (e *Engine) getValue(propertyGetter valueGetterFn) string {
 	value, err := propertyGetter()
 	if err != nil {
 		value = unableToDetermine
-		commons.Debugf("unable to determine value err: %s", err)
+		e.debugf("unable to determine value err: %s", err)
 	}
 	return value
 }
 
-// FillTemplate takes the input Dockerfile.template and fills it to deliver Dockerfile that will be used to build the image.
+// fillTemplate takes the input Dockerfile.template and fills it reproducibly, the same way as the Dockerfiles
+// of all images are filled and checked, so the filled Dockerfile passes the check.
+func (e *Engine) fillTemplate(inputFile, outputFile string) error {
+	return e.fillTemplateFile(inputFile, outputFile, true)
+}
+
+// fillBuildDockerfile takes the input Dockerfile.template and fills it to deliver Dockerfile that will be used to build the image.
 // Uses properties defined in the config file + dynamic properties for filling the template.
 // Dynamic properties are prepared automatically after analysing entire image hierarchy.
-func FillTemplate(inputFile, outputFile string) error {
-	if _, err := os.Stat(inputFile); os.IsNotExist(err) {
-		if strings.HasSuffix(inputFile, ".template") {
-			return fmt.Errorf("%s does not exists", inputFile)
-		}
-
-		inputFileDir, err := dockerImgParser.ExtractDockerFileDir(inputFile)
-		if err != nil {
-			return err
-		}
-
-		// if inputFile does not exist check for existence of the template
-		templateFile := path.Join(inputFileDir, dockerFileTemplateName)
-		if _, err := os.Stat(templateFile); os.IsNotExist(err) {
-			return fmt.Errorf("neither %s nor %s does not exists", inputFile, templateFile)
-		}
+func (e *Engine) fillBuildDockerfile(inputFile, outputFile string) error {
+	return e.fillTemplateFile(inputFile, outputFile, false)
+}
 
-		inputFile = templateFile
+// fillTemplateFile fills the template, reproducible rendering is described at renderDockerfile.
+func (e *Engine) fillTemplateFile(inputFile, outputFile string, reproducible bool) error {
+	inputFile, err := e.resolveTemplateFile(inputFile)
+	if err != nil {
+		return err
 	}
 
 	if inputFile == outputFile {
-		fmt.Printf("Skipping templating for %s (input path is the same as output)\n", inputFile)
+		fmt.Fprintf(e.stdout, "Skipping templating for %s (input path is the same as output)\n", inputFile)
 		return nil
 	}
 
-	fmt.Printf("Templating %s to %s\n", inputFile, outputFile)
-	return commons.FillTemplate(inputFile, outputFile, config.Properties)
+	fmt.Fprintf(e.stdout, "Templating %s to %s\n", inputFile, outputFile)
+	content, err := e.renderDockerfile(inputFile, reproducible)
+	if err != nil {
+		return err
+	}
+	return commons.WriteRenderedFile(inputFile, outputFile, content)
+}
+
+// resolveTemplateFile returns path of the Dockerfile.template, when Dockerfile is provided its template is looked up.
+func (e *Engine) resolveTemplateFile(inputFile string) (string, error) {
+	if _, err := os.Stat(inputFile); !os.IsNotExist(err) {
+		return inputFile, nil
+	}
+	if strings.HasSuffix(inputFile, ".template") {
+		return "", fmt.Errorf("%s does not exists", inputFile)
+	}
+
+	inputFileDir, err := e.dockerImgParser.ExtractDockerFileDir(inputFile)
+	if err != nil {
+		return "", err
+	}
+
+	// if inputFile does not exist check for existence of the template
+	templateFile := path.Join(inputFileDir, dockerFileTemplateName)
+	if _, err := os.Stat(templateFile); os.IsNotExist(err) {
+		return "", fmt.Errorf("neither %s nor %s does not exists", inputFile, templateFile)
+	}
+	return templateFile, nil
 }
 
-// BuildDockerfile uses build command defined in the config to build provided dockerfile and potentially its dependants.
-// Prints the build report at the end of processing.
-func BuildDockerfile(dockerfile, scope string, shouldTriggerDependantBuilds bool) error {
-	defer PrintReport()
-	setupInterruptionSignalHandler()
-	err := ExecuteDockerCommand(config.Commands.DefaultBuildCommand, dockerfile, scope, nil, shouldTriggerDependantBuilds)
+// renderDockerfile renders the template with the properties applicable to its directory.
+// Reproducible rendering sets the dynamic properties of the image and renders the properties that differ
+// between the machines and runs as volatile (see reproducibleProperties).
+// Parent image is pinned to its locked digest when pinning is enabled.
+func (e *Engine) renderDockerfile(templateFile string, reproducible bool) ([]byte, error) {
+	imgName, err := e.dockerImgParser.ExtractImageName(templateFile)
 	if err != nil {
-		storeError(fmt.Errorf("error processing %s: %s", dockerfile, err))
+		return nil, err
 	}
-	return err
+	dockerImage := e.hierarchy.GetImageByName(imgName)
+
+	e.config.applyDirectoryOverrides(path.Dir(templateFile))
+	properties := e.config.Properties
+	if reproducible {
+		properties = e.reproducibleProperties(dockerImage)
+	}
+	content, err := commons.RenderTemplate(templateFile, properties, e.templateOptions(dockerImage))
+	if err != nil || !e.config.PinDigests || dockerImage == nil {
+		return content, err
+	}
+	return e.pinParentDigest(content, dockerImage)
+}
+
+// printConfig prints config layers along with the effective properties and commands.
+// When dockerfile is provided the directory overrides applicable to its directory are taken into account.
+func (e *Engine) printConfig(dockerfile string) error {
+	dir := e.config.RootDir
+	if dockerfile != "" {
+		dockerfileDir, err := e.dockerImgParser.ExtractDockerFileDir(dockerfile)
+		if err != nil {
+			return err
+		}
+		dir = dockerfileDir
+	}
+	e.config.applyDirectoryOverrides(dir)
+	e.printLayers(dir)
+	return nil
 }
 
-// PushDockerImages uses push command defined in the config to build provided dockerfile and potentially its dependants.
-// Prints the build report at the end of processing.
-func PushDockerImages(dockerfile, scope string, shouldTriggerDependantBuilds bool) error {
-	defer PrintReport()
-	setupInterruptionSignalHandler()
-	err := ExecuteDockerCommand(config.Commands.DefaultPushCommand, dockerfile, scope, NewPostPushListener(), shouldTriggerDependantBuilds)
+// buildDockerfile uses build command defined in the config to build provided dockerfile and potentially its dependants.
+// Prints the build report at the end of processing, images not processed due to the cancellation are reported as cancelled.
+func (e *Engine) buildDockerfile(ctx context.Context, dockerfile, scope string, shouldTriggerDependantBuilds bool) error {
+	defer e.printReport()
+	dockerfile = e.dockerfileOrFilterRoot(dockerfile)
+	err := e.executeDockerCommand(ctx, buildOperation, dockerfile, scope, nil, shouldTriggerDependantBuilds)
 	if err != nil {
-		storeError(fmt.Errorf("error processing %s: %s", dockerfile, err))
+		e.storeError(fmt.Errorf("error processing %s: %s", dockerfile, err))
+	}
+	return cancellationError(ctx, err)
+}
+
+// pushDockerImages uses push command defined in the config to build provided dockerfile and potentially its dependants.
+// Prints the build report at the end of processing. Tags of the images pushed before the cancellation are still pushed
+// within the termination grace period.
+func (e *Engine) pushDockerImages(ctx context.Context, dockerfile, scope string, shouldTriggerDependantBuilds bool) error {
+	defer e.printReport()
+	dockerfile = e.dockerfileOrFilterRoot(dockerfile)
+	err := e.executeDockerCommand(ctx, pushOperation, dockerfile, scope, e.newPostPushListener(), shouldTriggerDependantBuilds)
+	if err != nil {
+		e.storeError(fmt.Errorf("error processing %s: %s", dockerfile, err))
 	} else {
-		err = PushTags()
+		pushCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), terminationGracePeriod)
+		if ctx.Err() == nil {
+			pushCtx, cancel = context.WithCancel(ctx)
+		}
+		err = e.versionStore.PushTags(pushCtx)
+		cancel()
+	}
+	if len(e.commandResults) > 0 {
+		if lockErr := e.writeLockFile(); lockErr != nil {
+			e.storeError(fmt.Errorf("error writing lock file: %s", lockErr))
+		}
+	}
+	return cancellationError(ctx, err)
+}
+
+// cancellationError returns error reporting the cancellation when the context was cancelled, otherwise the provided error.
+func cancellationError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("processing of the images was cancelled: %s", ctx.Err())
 	}
 	return err
 }
 
-// PrintReport prints the report with processed images and its versions.
-func PrintReport() {
-	fmt.Printf(outputSeparator)
-	fmt.Printf("Processed %d image(s) in %v:\n", len(commandResults), time.Since(startTime))
-	for _, r := range commandResults {
-		fmt.Printf(color.GreenString("\t%s %s => %s\n", r.Name, r.CurrentVersion, r.NextVersion))
+// dockerfileOrFilterRoot returns provided dockerfile or the template of the image filter root when dockerfile is not provided.
+func (e *Engine) dockerfileOrFilterRoot(dockerfile string) string {
+	if dockerfile == "" {
+		return e.config.imageFilter.rootDockerfile(e.hierarchy)
 	}
-	if len(config.ReportFileName) > 0 {
-		commons.WriteToJSONFile(commandResults, config.ReportFileName)
+	return dockerfile
+}
+
+// printReport prints the report with processed images and its versions.
+func (e *Engine) printReport() {
+	fmt.Fprintf(e.stdout, outputSeparator)
+	fmt.Fprintf(e.stdout, "Processed %d image(s) in %v:\n", len(e.commandResults), time.Since(e.startTime))
+	for _, r := range e.commandResults {
+		switch r.Status {
+		case statusUpToDate:
+			fmt.Fprintf(e.stdout, color.YellowString("\t%s %s (%s)\n", r.Name, r.CurrentVersion, r.Status))
+		case statusSkipped, statusExcluded:
+			fmt.Fprintf(e.stdout, color.YellowString("\t%s %s (%s: %s)\n", r.Name, r.CurrentVersion, r.Status, r.Reason))
+		case statusCancelled, statusFailed:
+			fmt.Fprintf(e.stdout, color.RedString("\t%s %s (%s)%s\n", r.Name, r.CurrentVersion, r.Status, r.attemptsSummary()))
+		default:
+			fmt.Fprintf(e.stdout, color.GreenString("\t%s %s => %s%s%s\n", r.Name, r.CurrentVersion, r.NextVersion, r.platformsSummary(), r.attemptsSummary()))
+		}
 	}
-	errorCount := len(commandErrors)
+	e.writeReports(e.commandResults, time.Since(e.startTime))
+	errorCount := len(e.commandErrors)
 	if errorCount > 0 {
-		fmt.Printf(color.RedString("Following (%d) errors occurred during image processing:\n", errorCount))
-		for _, err := range commandErrors {
-			fmt.Printf(color.RedString("\t%s\n", err))
+		fmt.Fprintf(e.stdout, color.RedString("Following (%d) errors occurred during image processing:\n", errorCount))
+		for _, err := range e.commandErrors {
+			fmt.Fprintf(e.stdout, color.RedString("\t%s\n", e.config.mask(err.Error())))
 		}
 	}
 }
 
-// Setups interruption signal handler, that allows for printing the summary report even in cases when
-// processing was aborted.
-func setupInterruptionSignalHandler() {
-	// setup signal receiving channel
-	signalReceiverChannel := make(chan os.Signal, 3)
-
-	// bind channel to handle signals
-	signal.Notify(signalReceiverChannel, os.Interrupt, syscall.SIGTERM, syscall.SIGINT, syscall.SIGKILL)
-
-	// listen for channel notifications
-	go func() {
-		sig := <-signalReceiverChannel
-		commons.Debugf("Received signal: %s\n", sig.String())
-		PrintReport()
-		os.Exit(1)
-	}()
+// builderOperation is the build or push operation executed on the processed images.
+type builderOperation struct {
+	name   string
+	action BuilderAction
 }
 
-// ExecuteDockerCommand build/push docker file in the following steps:
+var (
+	buildOperation = builderOperation{name: buildOperationName, action: ImageBuilder.Build}
+	pushOperation  = builderOperation{name: pushOperationName, action: ImageBuilder.Push}
+)
+
+// executeDockerCommand build/push docker file in the following steps:
 // - obtain current image info (name, version, dependants)
 // - get next version based on git tags according to the change scope
 // - updates dynamic config properties based on gathered info
-// - templates docker command
-// - execute already filled template of the build/push command
+// - executes the build/push action with the image builder selected for the image
 // - depending on the shouldTriggerDependantBuilds flag executes child builds if there are any
-func ExecuteDockerCommand(command, dockerfile, scope string, postCmdListener PostCommandListener, shouldTriggerDependantBuilds bool) error {
-	fmt.Printf(outputSeparator)
-	imgName, err := dockerImgParser.ExtractImageName(dockerfile)
+// When the context is cancelled the image and its dependants are reported as cancelled.
+func (e *Engine) executeDockerCommand(ctx context.Context, operation builderOperation, dockerfile, scope string, postCmdListener PostCommandListener, shouldTriggerDependantBuilds bool) error {
+	fmt.Fprintf(e.stdout, outputSeparator)
+	imgName, err := e.dockerImgParser.ExtractImageName(dockerfile)
 	if err != nil {
 		return err
 	}
-	dockerImage := hierarchy.GetImageByName(imgName)
+	dockerImage := e.hierarchy.GetImageByName(imgName)
 	if dockerImage == nil {
 		return fmt.Errorf("unable to find image %s in the analyzed structure (is invocation directory correct?)", imgName)
 	}
 
 	dockerImage.CalculateNextVersion(scope)
-	fmt.Printf("Working with %s scope of: %s version: %s => %s\n", scope, imgName, dockerImage.GetLatestVersionString(), dockerImage.GetNextVersionString())
+	result := e.newCommandResult(operation.name, dockerImage)
+	if ctx.Err() != nil {
+		fmt.Fprintf(e.stdout, "Skipping %s as processing was cancelled\n", imgName)
+		e.storeCancelledResult(result)
+	} else if err = e.processLoggedImage(ctx, operation, dockerfile, scope, dockerImage, result, postCmdListener); err != nil {
+		result.Error = e.config.mask(err.Error())
+		if ctx.Err() == nil {
+			e.storeFailedResult(result)
+			if shouldTriggerDependantBuilds {
+				for _, dependant := range e.dependencies[result.Name] {
+					status, reason := e.dependantExclusion(dependant)
+					if status == "" {
+						status, reason = statusSkipped, fmt.Sprintf("parent %s failed", imgName)
+					}
+					e.storeNotProcessedResults(operation, dependant, status, reason)
+				}
+			}
+			return err
+		}
+		fmt.Fprintf(e.stdout, "Processing of %s was cancelled: %s\n", imgName, err)
+		e.storeCancelledResult(result)
+	}
+
+	hasDependantImages := e.dependencies[result.Name] != nil && len(e.dependencies[result.Name]) > 0
+	if shouldTriggerDependantBuilds && hasDependantImages {
+		for _, dependant := range e.dependencies[result.Name] {
+			if status, reason := e.dependantExclusion(dependant); status != "" {
+				fmt.Fprintf(e.stdout, "Skipping dependant build of %s: %s\n", dependant.Name, reason)
+				e.storeNotProcessedResults(operation, dependant, status, reason)
+				continue
+			}
+			fmt.Fprintf(e.stdout, "Triggering dependant build of %s\n", dependant.Name)
+			err = e.executeDockerCommand(ctx, operation, dependant.DockerfilePath, scope, postCmdListener, true)
+			if err != nil {
+				e.storeError(fmt.Errorf("error processing %s: %s", dependant.Name, err))
+			}
+		}
+	}
+
+	return nil
+}
+
+// dependantExclusion returns the status and the reason of the dependant that is not processed along with its parent
+// because of the autoBuildExcludes or the image filter, empty status when the dependant is processed.
+func (e *Engine) dependantExclusion(dependant *DockerImage) (string, string) {
+	if commons.Contains(e.config.AutoBuildExcludes, dependant.Name) {
+		return statusSkipped, "defined in the config autoBuildExcludes section"
+	}
+	if !e.config.imageFilter.selects(dependant.Name) {
+		return statusExcluded, "does not pass the image filter"
+	}
+	return "", ""
+}
+
+// processLoggedImage processes the image with the output of its commands written to the image log.
+func (e *Engine) processLoggedImage(ctx context.Context, operation builderOperation, dockerfile, scope string, dockerImage *DockerImage, result *CommandResult, postCmdListener PostCommandListener) error {
+	err := e.processing.startImage(operation.name, dockerImage)
+	if err != nil {
+		return err
+	}
+	err = e.processImage(ctx, operation, dockerfile, scope, dockerImage, result, postCmdListener)
+	e.processing.finishImage(ctx, result, err)
+	return err
+}
+
+// processImage executes the build/push action unless the image content did not change since its latest version.
+func (e *Engine) processImage(ctx context.Context, operation builderOperation, dockerfile, scope string, dockerImage *DockerImage, result *CommandResult, postCmdListener PostCommandListener) error {
+	imgName := dockerImage.Name
+	fmt.Fprintf(e.stdout, "Working with %s scope of: %s version: %s => %s\n", scope, imgName, dockerImage.GetLatestVersionString(), dockerImage.GetNextVersionString())
+
+	dockerImage.platforms = e.resolvePlatforms(dockerImage, result)
+	if len(dockerImage.platforms) == 0 && len(result.Platforms) > 0 {
+		return result.platformsError()
+	}
 
+	e.config.applyDirectoryOverrides(dockerImage.DockerfileDir)
 	// since now we know the image name and the next version so we can
 	// update config properties so that commands and dockerfile template could be properly filled
-	config.UpdateDynamicProperties(dockerImage)
-	if config.Verbose {
-		config.PrintProperties()
+	e.updateDynamicProperties(dockerImage)
+	if e.config.Verbose {
+		e.printProperties()
+	}
+
+	hash, err := e.contentHash(dockerImage)
+	if err != nil {
+		e.debugf("Unable to calculate content hash of %s, err: %s", imgName, err)
+	}
+	result.ContentHash = hash
+
+	if e.config.SkipUpToDate && e.isUpToDate(dockerImage, result.ContentHash) {
+		fmt.Fprintf(e.stdout, "Skipping %s as its content did not change since version %s\n", imgName, dockerImage.GetLatestVersionString())
+		e.storeUpToDateResult(dockerImage, result)
+		return nil
 	}
+	return e.executeBuilderAction(ctx, operation, dockerfile, dockerImage, result, postCmdListener)
+}
 
-	outputPath := fmt.Sprintf("%s/Dockerfile", dockerImage.DockerfileDir)
-	err = FillTemplate(dockerfile, outputPath)
+// executeBuilderAction fills the image Dockerfile and executes the build/push action with the image builder.
+// Stores the result and invokes the post command listener if there is any.
+func (e *Engine) executeBuilderAction(ctx context.Context, operation builderOperation, dockerfile string, dockerImage *DockerImage, result *CommandResult, postCmdListener PostCommandListener) error {
+	outputPath := path.Join(dockerImage.DockerfileDir, dockerfileName)
+	err := e.fillBuildDockerfile(dockerfile, outputPath)
+	if err != nil {
+		return err
+	}
+	err = e.appendLabelLines(outputPath, dockerImage)
 	if err != nil {
 		return err
 	}
 
-	err = executeCommand(command)
+	builder, err := e.getImageBuilder(dockerImage.Name)
 	if err != nil {
 		return err
 	}
 
-	result := storeResult(dockerImage)
+	platforms := result.Platforms
+	result.Attempts, err = e.withRetries(ctx, e.config.imageRetrySettings(operation.name, dockerImage.Name), fmt.Sprintf("%s of %s", operation.name, dockerImage.Name), func(ctx context.Context) error {
+		// platforms processed in the failed attempt are processed again
+		result.Platforms = copyPlatformResults(platforms)
+		return operation.action(builder, ctx, dockerImage, result)
+	})
+	if err != nil {
+		return err
+	}
+	result.completePlatforms(dockerImage.platforms)
+	e.storeResult(result)
+	e.storePublishedPlatforms(dockerImage, result)
+	for _, platformErr := range result.failedPlatforms() {
+		e.storeError(platformErr)
+	}
 
 	// invoke post build listener if there is any
 	if postCmdListener != nil {
-		postCmdListener.OnPostCommand(result)
+		postCmdListener.OnPostCommand(ctx, result)
 	}
+	return nil
+}
 
-	hasDependantImages := dependencies[result.Name] != nil && len(dependencies[result.Name]) > 0
-	if shouldTriggerDependantBuilds && hasDependantImages {
-		for _, dependant := range dependencies[result.Name] {
-			if commons.Contains(config.AutoBuildExcludes, dependant.Name) {
-				fmt.Printf("Skipping dependant build of %s as it is defined in the config autoBuildExcludes section\n", dependant.Name)
-				continue
-			}
-			fmt.Printf("Triggering dependant build of %s\n", dependant.Name)
-			err = ExecuteDockerCommand(command, dependant.DockerfilePath, scope, postCmdListener, true)
-			if err != nil {
-				storeError(fmt.Errorf("error processing %s: %s", dependant.Name, err))
-			}
-		}
+// storeUpToDateResult stores the result of the image that was skipped because its content did not change.
+// Image keeps its latest version, so the dependants are still referring to it.
+func (e *Engine) storeUpToDateResult(dockerImage *DockerImage, result *CommandResult) {
+	result.Status = statusUpToDate
+	result.NextVersion = result.CurrentVersion
+	if lockedImage, isLocked := e.lock.Images[dockerImage.Name]; isLocked {
+		result.Digest = lockedImage.Digest
 	}
+	e.setDynamicImageVersionProperty(dockerImage.Name, result.CurrentVersion)
+	result.completePlatforms(dockerImage.platforms)
+	e.storeResult(result)
+	e.storePublishedPlatforms(dockerImage, result)
+}
 
-	return nil
+// attemptsSummary returns number of attempts made when the command was retried, used in the report.
+func (r *CommandResult) attemptsSummary() string {
+	if r.Attempts <= 1 {
+		return ""
+	}
+	return fmt.Sprintf(" (%d attempts)", r.Attempts)
 }
 
-// Executes command and prints to the stdout its output.
-func executeCommand(command string) error {
-	t, err := template.New("dockerCmd").Parse(command)
-	if err != nil {
-		return err
+// storeFailedResult stores the result of the image whose processing failed, image keeps its latest version.
+func (e *Engine) storeFailedResult(result *CommandResult) {
+	result.Status = statusFailed
+	result.NextVersion = result.CurrentVersion
+	e.storeResult(result)
+}
+
+// storeNotProcessedResults stores the result of the dependant image that is not processed with the status and the reason.
+// Descendants of the image are stored as excluded when they do not pass the image filter and as skipped otherwise.
+func (e *Engine) storeNotProcessedResults(operation builderOperation, dockerImage *DockerImage, status, reason string) {
+	result := e.newCommandResult(operation.name, dockerImage)
+	result.Status = status
+	result.NextVersion = result.CurrentVersion
+	result.Reason = reason
+	e.storeResult(result)
+	for _, dependant := range e.dependencies[dockerImage.Name] {
+		dependantStatus := statusSkipped
+		if !e.config.imageFilter.selects(dependant.Name) {
+			dependantStatus = statusExcluded
+		}
+		e.storeNotProcessedResults(operation, dependant, dependantStatus, fmt.Sprintf("parent %s was not processed", dockerImage.Name))
 	}
+}
+
+// storeCancelledResult stores the result of the image whose processing was cancelled, image keeps its latest version.
+func (e *Engine) storeCancelledResult(result *CommandResult) {
+	result.Status = statusCancelled
+	result.NextVersion = result.CurrentVersion
+	e.storeResult(result)
+}
 
-	var cmdBuf bytes.Buffer
-	err = t.Execute(&cmdBuf, config.Properties)
+// Executes command and prints to the stdout its output. Command name is used to report templating errors.
+// Extra arguments are appended to the templated command as they are.
+func (e *Engine) executeCommand(ctx context.Context, commandName, command string, extraArgs ...string) error {
+	dockerCmdString, err := e.fillTemplateString(commandName, command)
 	if err != nil {
 		return err
 	}
+	return e.executeArgs(ctx, append(strings.Split(dockerCmdString, " "), extraArgs...))
+}
+
+// Executes command provided as a slice of program name and its arguments and prints its output to the stdout
+// or to the log of the processed image.
+func (e *Engine) executeArgs(ctx context.Context, dockerCmdWithArgs []string) error {
+	dockerCmd := e.newCommand(ctx, dockerCmdWithArgs[0], dockerCmdWithArgs[1:]...)
+	attachStdin(dockerCmd)
+	return e.runLoggedCommand(dockerCmd)
+}
 
-	dockerCmdString := cmdBuf.String()
-	fmt.Printf("Executing: %s\n", dockerCmdString)
-	dockerCmdWithArgs := strings.Split(dockerCmdString, " ")
-	dockerCmd := exec.Command(dockerCmdWithArgs[0], dockerCmdWithArgs[1:]...)
-	dockerCmd.Stdin = os.Stdin
-	dockerCmd.Stdout = os.Stdout
-	dockerCmd.Stderr = os.Stderr
+// runLoggedCommand runs the command with its output written to the stdout or to the log of the processed image
+// and emits the command executed event.
+func (e *Engine) runLoggedCommand(cmd *command) error {
+	command := strings.Join(cmd.Args, " ")
+	fmt.Fprintf(e.commandStdout, "Executing: %s\n", e.config.mask(command))
+	cmd.Stdout = e.commandStdout
+	cmd.Stderr = e.commandStderr
+
+	start := time.Now()
+	err := cmd.Run()
+	e.processing.commandExecuted(command, time.Since(start), err)
+	return err
+}
+
+// newCommand creates command whose process tree receives SIGTERM when the context is cancelled and is killed
+// when the context deadline is exceeded. Process tree not exiting within the termination grace period is killed.
+func (e *Engine) newCommand(ctx context.Context, name string, args ...string) *command {
+	cmd := &command{Cmd: exec.CommandContext(ctx, name, args...)}
+	startInProcessGroup(cmd.Cmd)
+	cmd.Cancel = func() error {
+		if ctx.Err() == context.DeadlineExceeded {
+			return killProcessTree(cmd.Cmd)
+		}
+		// WaitDelay kills only the command itself, children left behind are killed along with it
+		cmd.killTimer = time.AfterFunc(terminationGracePeriod, func() {
+			if err := killProcessTree(cmd.Cmd); err != nil {
+				e.debugf("Unable to kill process tree of %s: %s", cmd.Path, err)
+			}
+		})
+		return terminateProcessTree(cmd.Cmd)
+	}
+	cmd.WaitDelay = terminationGracePeriod
+	return cmd
+}
 
-	return dockerCmd.Run()
+// isTerminal checks whenever the file is a terminal (character device).
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
 }
 
-// Stores the result of successful command processing. Receives image name and its current and next versions.
-func storeResult(dockerImage *DockerImage) *CommandResult {
-	result := &CommandResult{
+// Creates the result of command processing. Receives the operation and the image with its current and next versions.
+func (e *Engine) newCommandResult(operation string, dockerImage *DockerImage) *CommandResult {
+	return &CommandResult{
+		Operation:      operation,
 		Name:           dockerImage.Name,
 		DockerfileDir:  dockerImage.DockerfileDir,
 		CurrentVersion: dockerImage.GetLatestVersionString(),
-		NextVersion:    dockerImage.GetNextVersionString()}
-
-	commandResults = append(commandResults, result)
+		NextVersion:    dockerImage.GetNextVersionString(),
+		Status:         statusSuccess,
+		Profile:        e.config.profile}
+}
 
-	return result
+// Stores the result of successful command processing.
+func (e *Engine) storeResult(result *CommandResult) {
+	e.commandResults = append(e.commandResults, result)
 }
 
 // Stores the error of command processing.
-func storeError(err error) {
-	commandErrors = append(commandErrors, err)
+func (e *Engine) storeError(err error) {
+	e.commandErrors = append(e.commandErrors, err)
 }
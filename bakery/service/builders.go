@@ -0,0 +1,354 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+)
+
+const (
+	commandBuilderName = "command"
+	buildxBuilderName  = "docker-buildx"
+	buildahBuilderName = "buildah"
+	podmanBuilderName  = "podman"
+	kanikoBuilderName  = "kaniko"
+	kanikoExecutor     = "/kaniko/executor"
+)
+
+// getImageBuilder returns builder for the image. Builder defined for the image in the config images section
+// takes precedence over the globally configured one. Builder provided in the engine options takes precedence over both.
+func (e *Engine) getImageBuilder(imageName string) (ImageBuilder, error) {
+	if e.builderOverride != nil {
+		return e.builderOverride, nil
+	}
+	builderName := e.config.Builder
+	if imgCfg, ok := e.config.Images[imageName]; ok && imgCfg != nil && imgCfg.Builder != "" {
+		builderName = imgCfg.Builder
+	}
+	if builderName == "" {
+		builderName = commandBuilderName
+	}
+
+	if builder, ok := e.builders[builderName]; ok {
+		return builder, nil
+	}
+	builder, err := e.newImageBuilder(builderName)
+	if err != nil {
+		return nil, err
+	}
+	e.debugf("Initialized %s builder", builderName)
+	e.builders[builderName] = builder
+	return builder, nil
+}
+
+// newImageBuilder initializes builder with the given name.
+func (e *Engine) newImageBuilder(builderName string) (ImageBuilder, error) {
+	switch builderName {
+	case commandBuilderName:
+		return &commandBuilder{engine: e}, nil
+	case dockerAPIBuilderName:
+		client, err := e.newDockerAPIClient(e.config.DockerHost)
+		if err != nil {
+			return nil, err
+		}
+		return &dockerAPIBuilder{engine: e, client: client}, nil
+	case buildxBuilderName:
+		return &toolBuilder{engine: e, buildArgs: e.buildxBuildArgs, pushArgs: e.buildxPushArgs, multiPlatform: true, pushDigest: e.dockerRepoDigest}, nil
+	case buildahBuilderName:
+		return &toolBuilder{engine: e, buildArgs: e.buildahBuildArgs, pushArgs: buildahPushArgs, multiPlatform: true}, nil
+	case podmanBuilderName:
+		return &toolBuilder{engine: e, buildArgs: e.podmanBuildArgs, pushArgs: podmanPushArgs, multiPlatform: true}, nil
+	case kanikoBuilderName:
+		return &toolBuilder{engine: e, buildArgs: e.kanikoBuildArgs, pushArgs: e.kanikoPushArgs}, nil
+	default:
+		return nil, fmt.Errorf("unknown builder %s, expecting one of: %s", builderName,
+			strings.Join([]string{commandBuilderName, dockerAPIBuilderName, buildxBuilderName, buildahBuilderName, podmanBuilderName, kanikoBuilderName}, ", "))
+	}
+}
+
+// commandBuilder executes build and push command templates defined in the config commands section.
+// For multi-platform images the command is executed for every platform with the IMAGE_PLATFORM property set.
+// Image id and digest are read from the files provided in IMAGE_IIDFILE and IMAGE_METADATA_FILE properties
+// if the command makes use of them. Label arguments are appended to the build command when labels are added as arguments.
+type commandBuilder struct {
+	engine *Engine
+}
+
+func (b *commandBuilder) Build(ctx context.Context, dockerImage *DockerImage, result *CommandResult) error {
+	return b.execute(ctx, "defaultBuildCommand", b.engine.config.Commands.DefaultBuildCommand, dockerImage, result, b.engine.labelArgs(dockerImage)...)
+}
+
+func (b *commandBuilder) Push(ctx context.Context, dockerImage *DockerImage, result *CommandResult) error {
+	return b.execute(ctx, "defaultPushCommand", b.engine.config.Commands.DefaultPushCommand, dockerImage, result)
+}
+
+func (b *commandBuilder) execute(ctx context.Context, commandName, command string, dockerImage *DockerImage, result *CommandResult, extraArgs ...string) error {
+	files, err := newOutputFiles(b.engine.logger)
+	if err != nil {
+		return err
+	}
+	defer files.remove()
+	b.engine.config.setOutputFiles(files)
+	defer files.record(result)
+
+	platforms := dockerImage.GetPlatforms()
+	if len(platforms) <= 1 {
+		return b.engine.executeCommand(ctx, commandName, command, extraArgs...)
+	}
+
+	defer b.engine.config.setImagePlatform("")
+	for _, platform := range platforms {
+		b.engine.config.setImagePlatform(platform)
+		result.addPlatformResult(platform, b.engine.executeCommand(ctx, commandName, command, extraArgs...))
+	}
+	return result.platformsError()
+}
+
+// dockerAPIBuilder builds, tags and pushes images with the Docker Engine API.
+type dockerAPIBuilder struct {
+	engine *Engine
+	client *dockerAPIClient
+}
+
+func (b *dockerAPIBuilder) Build(ctx context.Context, dockerImage *DockerImage, result *CommandResult) error {
+	platforms := dockerImage.GetPlatforms()
+	if len(platforms) > 1 {
+		return fmt.Errorf("%s builder does not support multi-platform builds, use %s or %s builder instead", dockerAPIBuilderName, buildxBuilderName, podmanBuilderName)
+	}
+	tags, err := b.engine.imageTags()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(b.engine.stdout, "Building %s via docker API\n", tags[0])
+	imageID, err := b.client.build(ctx, dockerImage.DockerfileDir, dockerfileName, strings.Join(platforms, ","), tags[:1], b.engine.labelsJSON(dockerImage))
+	if err != nil {
+		return err
+	}
+	for _, tag := range tags[1:] {
+		fmt.Fprintf(b.engine.stdout, "Tagging %s as %s\n", imageID, tag)
+		if err = b.client.tag(ctx, imageID, tag); err != nil {
+			return err
+		}
+	}
+	result.ImageID = imageID
+	return nil
+}
+
+func (b *dockerAPIBuilder) Push(ctx context.Context, dockerImage *DockerImage, result *CommandResult) error {
+	tags, err := b.engine.imageTags()
+	if err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		fmt.Fprintf(b.engine.stdout, "Pushing %s via docker API\n", tag)
+		digest, err := b.client.push(ctx, tag)
+		if err != nil {
+			return err
+		}
+		result.Digest = digest
+	}
+	return nil
+}
+
+// invocation is a single execution of the external tool. When the invocation processes specific platforms
+// its failure is recorded per platform instead of aborting processing of the image.
+// Failure of the optional invocation is ignored (e.g. removal of the manifest list that does not exist yet).
+type invocation struct {
+	args      []string
+	platforms []string
+	optional  bool
+}
+
+// argsFn returns invocations of the external tool needed to process the image with given tags.
+// Provided output files should be used to make the tool write the image id and digest.
+type argsFn func(dockerImage *DockerImage, tags []string, files *outputFiles) []invocation
+
+// toolBuilder builds and pushes images by invoking external tools (buildah, podman, kaniko, docker buildx)
+// with the arguments prepared by the argument functions.
+type toolBuilder struct {
+	engine        *Engine
+	buildArgs     argsFn
+	pushArgs      argsFn
+	multiPlatform bool
+	// optional function used to query the digest of the pushed image when the tool is unable to write it to the file
+	pushDigest func(ctx context.Context, tag string) (string, error)
+}
+
+func (b *toolBuilder) Build(ctx context.Context, dockerImage *DockerImage, result *CommandResult) error {
+	return b.execute(ctx, b.buildArgs, dockerImage, result)
+}
+
+func (b *toolBuilder) Push(ctx context.Context, dockerImage *DockerImage, result *CommandResult) error {
+	err := b.execute(ctx, b.pushArgs, dockerImage, result)
+	if err == nil && result.Digest == "" && b.pushDigest != nil && len(dockerImage.GetPlatforms()) <= 1 {
+		tags, _ := b.engine.imageTags()
+		digest, digestErr := b.pushDigest(ctx, tags[0])
+		if digestErr != nil {
+			fmt.Fprintf(b.engine.stdout, "Unable to determine digest of %s: %s\n", tags[0], digestErr)
+		}
+		result.Digest = digest
+	}
+	return err
+}
+
+func (b *toolBuilder) execute(ctx context.Context, args argsFn, dockerImage *DockerImage, result *CommandResult) error {
+	if len(dockerImage.GetPlatforms()) > 1 && !b.multiPlatform {
+		return fmt.Errorf("builder does not support multi-platform builds of %s", strings.Join(dockerImage.GetPlatforms(), ","))
+	}
+	tags, err := b.engine.imageTags()
+	if err != nil {
+		return err
+	}
+	files, err := newOutputFiles(b.engine.logger)
+	if err != nil {
+		return err
+	}
+	defer files.remove()
+
+	for _, inv := range args(dockerImage, tags, files) {
+		err = b.engine.executeArgs(ctx, inv.args)
+		if inv.optional {
+			continue
+		}
+		if len(inv.platforms) == 0 && err != nil {
+			return err
+		}
+		for _, platform := range inv.platforms {
+			result.addPlatformResult(platform, err)
+		}
+	}
+	files.record(result)
+	return result.platformsError()
+}
+
+func dockerfilePath(dockerImage *DockerImage) string {
+	return path.Join(dockerImage.DockerfileDir, dockerfileName)
+}
+
+// appendRepeated appends the flag followed by the value for every value provided, e.g. --tag a --tag b
+func appendRepeated(args []string, flag string, values []string) []string {
+	for _, v := range values {
+		args = append(args, flag, v)
+	}
+	return args
+}
+
+// buildx builds all platforms at once. Multi-platform images can not be loaded to the local docker images,
+// so they stay in the build cache and are pushed by repeating the build with the --push flag.
+func (e *Engine) buildxBuildArgs(dockerImage *DockerImage, tags []string, files *outputFiles) []invocation {
+	platforms := dockerImage.GetPlatforms()
+	args := e.buildxArgs(dockerImage, tags, files)
+	if len(platforms) <= 1 {
+		args = append(args, "--load")
+	}
+	args = append(args, dockerImage.DockerfileDir)
+	return []invocation{{args: args, platforms: platforms}}
+}
+
+func (e *Engine) buildxPushArgs(dockerImage *DockerImage, tags []string, files *outputFiles) []invocation {
+	platforms := dockerImage.GetPlatforms()
+	if len(platforms) <= 1 {
+		return pushEachTag([]string{"docker", "push"}, tags)
+	}
+	args := append(e.buildxArgs(dockerImage, tags, files), "--push", dockerImage.DockerfileDir)
+	return []invocation{{args: args, platforms: platforms}}
+}
+
+func (e *Engine) buildxArgs(dockerImage *DockerImage, tags []string, files *outputFiles) []string {
+	args := []string{"docker", "buildx", "build", "--file", dockerfilePath(dockerImage), "--metadata-file", files.MetadataFile}
+	args = append(args, e.labelArgs(dockerImage)...)
+	args = appendPlatforms(args, dockerImage.GetPlatforms())
+	return appendRepeated(args, "--tag", tags)
+}
+
+func (e *Engine) buildahBuildArgs(dockerImage *DockerImage, tags []string, files *outputFiles) []invocation {
+	return e.manifestBuildArgs("buildah", dockerImage, tags, files)
+}
+
+func buildahPushArgs(dockerImage *DockerImage, tags []string, files *outputFiles) []invocation {
+	return manifestPushArgs("buildah", dockerImage, tags, files)
+}
+
+func (e *Engine) podmanBuildArgs(dockerImage *DockerImage, tags []string, files *outputFiles) []invocation {
+	return e.manifestBuildArgs("podman", dockerImage, tags, files)
+}
+
+func podmanPushArgs(dockerImage *DockerImage, tags []string, files *outputFiles) []invocation {
+	return manifestPushArgs("podman", dockerImage, tags, files)
+}
+
+// manifestBuildArgs builds the image with podman compatible tool. Multi-platform images are built platform by platform
+// and every platform image is added to the manifest list named after the first tag. The manifest list is recreated
+// by every attempt, as images of the previous attempts would be added to it again otherwise.
+func (e *Engine) manifestBuildArgs(tool string, dockerImage *DockerImage, tags []string, files *outputFiles) []invocation {
+	platforms := dockerImage.GetPlatforms()
+	if len(platforms) <= 1 {
+		args := []string{tool, "build", "--file", dockerfilePath(dockerImage), "--iidfile", files.ImageIDFile}
+		args = append(args, e.labelArgs(dockerImage)...)
+		args = appendPlatforms(args, platforms)
+		args = appendRepeated(args, "--tag", tags)
+		return []invocation{{args: append(args, dockerImage.DockerfileDir), platforms: platforms}}
+	}
+
+	invocations := make([]invocation, 0, len(platforms)+2)
+	invocations = append(invocations,
+		invocation{args: []string{tool, "manifest", "rm", tags[0]}, optional: true},
+		invocation{args: []string{tool, "manifest", "create", tags[0]}})
+	for _, platform := range platforms {
+		args := []string{tool, "build", "--file", dockerfilePath(dockerImage), "--platform", platform, "--manifest", tags[0]}
+		args = append(append(args, e.labelArgs(dockerImage)...), dockerImage.DockerfileDir)
+		invocations = append(invocations, invocation{args: args, platforms: []string{platform}})
+	}
+	return invocations
+}
+
+// manifestPushArgs pushes all tags of the image. Manifest list of multi-platform image is pushed with all its images under every tag.
+func manifestPushArgs(tool string, dockerImage *DockerImage, tags []string, files *outputFiles) []invocation {
+	if len(dockerImage.GetPlatforms()) <= 1 {
+		return pushEachTag([]string{tool, "push", "--digestfile", files.DigestFile}, tags)
+	}
+	invocations := make([]invocation, 0, len(tags))
+	for _, tag := range tags {
+		args := []string{tool, "manifest", "push", "--all", "--digestfile", files.DigestFile, tags[0], fmt.Sprintf("docker://%s", tag)}
+		invocations = append(invocations, invocation{args: args})
+	}
+	return invocations
+}
+
+// kaniko has no local image storage, so the build only verifies that the image can be built.
+func (e *Engine) kanikoBuildArgs(dockerImage *DockerImage, tags []string, files *outputFiles) []invocation {
+	args := append(e.kanikoArgs(dockerImage, tags), "--no-push")
+	return []invocation{{args: args, platforms: dockerImage.GetPlatforms()}}
+}
+
+// kaniko is unable to push previously built image, so the push builds the image again and pushes it to all destinations.
+func (e *Engine) kanikoPushArgs(dockerImage *DockerImage, tags []string, files *outputFiles) []invocation {
+	args := append(e.kanikoArgs(dockerImage, tags), "--digest-file", files.DigestFile)
+	return []invocation{{args: args, platforms: dockerImage.GetPlatforms()}}
+}
+
+func (e *Engine) kanikoArgs(dockerImage *DockerImage, tags []string) []string {
+	args := []string{kanikoExecutor, "--context", dockerImage.DockerfileDir, "--dockerfile", dockerfilePath(dockerImage)}
+	args = append(args, e.labelArgs(dockerImage)...)
+	for _, platform := range dockerImage.GetPlatforms() {
+		args = append(args, "--custom-platform", platform)
+	}
+	return appendRepeated(args, "--destination", tags)
+}
+
+func appendPlatforms(args []string, platforms []string) []string {
+	if len(platforms) == 0 {
+		return args
+	}
+	return append(args, "--platform", strings.Join(platforms, ","))
+}
+
+func pushEachTag(pushCmd []string, tags []string) []invocation {
+	invocations := make([]invocation, 0, len(tags))
+	for _, tag := range tags {
+		args := append(append([]string{}, pushCmd...), tag)
+		invocations = append(invocations, invocation{args: args})
+	}
+	return invocations
+}
@@ -0,0 +1,157 @@
+package service
+
+import (
+	"context"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var builderTestImage = &DockerImage{
+	Name:          "app",
+	DockerfileDir: "/images/app",
+}
+
+var builderTestTags = []string{"registry.local/app:1.0.0", "app:1.0.0"}
+
+var builderTestFiles = &outputFiles{ImageIDFile: "/out/iid", DigestFile: "/out/digest", MetadataFile: "/out/metadata.json"}
+
+func TestBuilderArgs(t *testing.T) {
+	engine := givenTestEngine(&Config{Properties: map[string]string{}})
+	testCases := []struct {
+		name     string
+		args     argsFn
+		expected [][]string
+	}{
+		{"buildx build", engine.buildxBuildArgs, [][]string{
+			{"docker", "buildx", "build", "--file", "/images/app/Dockerfile", "--metadata-file", "/out/metadata.json", "--tag", "registry.local/app:1.0.0", "--tag", "app:1.0.0", "--load", "/images/app"}}},
+		{"buildx push", engine.buildxPushArgs, [][]string{
+			{"docker", "push", "registry.local/app:1.0.0"},
+			{"docker", "push", "app:1.0.0"}}},
+		{"buildah build", engine.buildahBuildArgs, [][]string{
+			{"buildah", "build", "--file", "/images/app/Dockerfile", "--iidfile", "/out/iid", "--tag", "registry.local/app:1.0.0", "--tag", "app:1.0.0", "/images/app"}}},
+		{"buildah push", buildahPushArgs, [][]string{
+			{"buildah", "push", "--digestfile", "/out/digest", "registry.local/app:1.0.0"},
+			{"buildah", "push", "--digestfile", "/out/digest", "app:1.0.0"}}},
+		{"podman build", engine.podmanBuildArgs, [][]string{
+			{"podman", "build", "--file", "/images/app/Dockerfile", "--iidfile", "/out/iid", "--tag", "registry.local/app:1.0.0", "--tag", "app:1.0.0", "/images/app"}}},
+		{"podman push", podmanPushArgs, [][]string{
+			{"podman", "push", "--digestfile", "/out/digest", "registry.local/app:1.0.0"},
+			{"podman", "push", "--digestfile", "/out/digest", "app:1.0.0"}}},
+		{"kaniko build", engine.kanikoBuildArgs, [][]string{
+			{"/kaniko/executor", "--context", "/images/app", "--dockerfile", "/images/app/Dockerfile", "--destination", "registry.local/app:1.0.0", "--destination", "app:1.0.0", "--no-push"}}},
+		{"kaniko push", engine.kanikoPushArgs, [][]string{
+			{"/kaniko/executor", "--context", "/images/app", "--dockerfile", "/images/app/Dockerfile", "--destination", "registry.local/app:1.0.0", "--destination", "app:1.0.0", "--digest-file", "/out/digest"}}},
+	}
+
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, invocationArgs(tc.args(builderTestImage, builderTestTags, builderTestFiles)), tc.name)
+	}
+}
+
+func TestMultiPlatformBuilderArgs(t *testing.T) {
+	engine := givenTestEngine(&Config{Properties: map[string]string{}})
+	multiPlatformImage := &DockerImage{
+		Name:          "app",
+		DockerfileDir: "/images/app",
+		platforms:     []string{"linux/amd64", "linux/arm64"},
+	}
+
+	testCases := []struct {
+		name     string
+		args     argsFn
+		expected []invocation
+	}{
+		{"buildx build", engine.buildxBuildArgs, []invocation{
+			{args: []string{"docker", "buildx", "build", "--file", "/images/app/Dockerfile", "--metadata-file", "/out/metadata.json", "--platform", "linux/amd64,linux/arm64", "--tag", "registry.local/app:1.0.0", "--tag", "app:1.0.0", "/images/app"},
+				platforms: []string{"linux/amd64", "linux/arm64"}}}},
+		{"buildx push", engine.buildxPushArgs, []invocation{
+			{args: []string{"docker", "buildx", "build", "--file", "/images/app/Dockerfile", "--metadata-file", "/out/metadata.json", "--platform", "linux/amd64,linux/arm64", "--tag", "registry.local/app:1.0.0", "--tag", "app:1.0.0", "--push", "/images/app"},
+				platforms: []string{"linux/amd64", "linux/arm64"}}}},
+		{"podman build", engine.podmanBuildArgs, []invocation{
+			{args: []string{"podman", "manifest", "rm", "registry.local/app:1.0.0"}, optional: true},
+			{args: []string{"podman", "manifest", "create", "registry.local/app:1.0.0"}},
+			{args: []string{"podman", "build", "--file", "/images/app/Dockerfile", "--platform", "linux/amd64", "--manifest", "registry.local/app:1.0.0", "/images/app"},
+				platforms: []string{"linux/amd64"}},
+			{args: []string{"podman", "build", "--file", "/images/app/Dockerfile", "--platform", "linux/arm64", "--manifest", "registry.local/app:1.0.0", "/images/app"},
+				platforms: []string{"linux/arm64"}}}},
+		{"podman push", podmanPushArgs, []invocation{
+			{args: []string{"podman", "manifest", "push", "--all", "--digestfile", "/out/digest", "registry.local/app:1.0.0", "docker://registry.local/app:1.0.0"}},
+			{args: []string{"podman", "manifest", "push", "--all", "--digestfile", "/out/digest", "registry.local/app:1.0.0", "docker://app:1.0.0"}}}},
+	}
+
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, tc.args(multiPlatformImage, builderTestTags, builderTestFiles), tc.name)
+	}
+}
+
+func TestManifestListIsRecreatedByEveryAttempt(t *testing.T) {
+	engine := givenTestEngine(&Config{Properties: map[string]string{}})
+	multiPlatformImage := &DockerImage{Name: "app", DockerfileDir: "/images/app", platforms: []string{"linux/amd64", "linux/arm64"}}
+	expected := [][]string{
+		{"buildah", "manifest", "rm", "registry.local/app:1.0.0"},
+		{"buildah", "manifest", "create", "registry.local/app:1.0.0"},
+		{"buildah", "build", "--file", "/images/app/Dockerfile", "--platform", "linux/amd64", "--manifest", "registry.local/app:1.0.0", "/images/app"},
+		{"buildah", "build", "--file", "/images/app/Dockerfile", "--platform", "linux/arm64", "--manifest", "registry.local/app:1.0.0", "/images/app"},
+	}
+
+	firstAttempt := engine.buildahBuildArgs(multiPlatformImage, builderTestTags, builderTestFiles)
+	secondAttempt := engine.buildahBuildArgs(multiPlatformImage, builderTestTags, builderTestFiles)
+
+	assert.Equal(t, expected, invocationArgs(firstAttempt))
+	assert.Equal(t, expected, invocationArgs(secondAttempt))
+	assert.True(t, secondAttempt[0].optional)
+}
+
+func TestFailureOfOptionalInvocationIsIgnored(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+	engine := givenTestEngine(&Config{Properties: map[string]string{}})
+	builder := &toolBuilder{engine: engine, buildArgs: func(dockerImage *DockerImage, tags []string, files *outputFiles) []invocation {
+		return []invocation{
+			{args: []string{"sh", "-c", "exit 1"}, optional: true},
+			{args: []string{"sh", "-c", "exit 0"}},
+		}
+	}}
+
+	err := builder.Build(context.Background(), builderTestImage, &CommandResult{})
+
+	assert.NoError(t, err)
+}
+
+func invocationArgs(invocations []invocation) [][]string {
+	args := make([][]string, 0, len(invocations))
+	for _, inv := range invocations {
+		args = append(args, inv.args)
+	}
+	return args
+}
+
+func TestImageBuilderSelectedPerImage(t *testing.T) {
+	// given
+	engine := givenTestEngine(&Config{
+		Builder: podmanBuilderName,
+		Images:  map[string]*ImageConfig{"app": {Builder: kanikoBuilderName}},
+	})
+
+	// when
+	appBuilder, appErr := engine.getImageBuilder("app")
+	otherBuilder, otherErr := engine.getImageBuilder("other")
+
+	// then
+	assert.NoError(t, appErr)
+	assert.NoError(t, otherErr)
+	assert.Equal(t, engine.kanikoPushArgs(builderTestImage, builderTestTags, builderTestFiles), appBuilder.(*toolBuilder).pushArgs(builderTestImage, builderTestTags, builderTestFiles))
+	assert.False(t, appBuilder.(*toolBuilder).multiPlatform)
+	assert.Equal(t, podmanPushArgs(builderTestImage, builderTestTags, builderTestFiles), otherBuilder.(*toolBuilder).pushArgs(builderTestImage, builderTestTags, builderTestFiles))
+}
+
+func TestUnknownImageBuilder(t *testing.T) {
+	engine := givenTestEngine(&Config{Builder: "docker-compose"})
+
+	_, err := engine.getImageBuilder("app")
+
+	assert.Error(t, err)
+}
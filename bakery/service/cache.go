@@ -0,0 +1,271 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Masterminds/semver"
+)
+
+const (
+	// cacheFormatVersion is increased whenever the format of the cached entries changes, so stale caches are not used
+	cacheFormatVersion    = 1
+	imagesCacheFileName   = "images.json"
+	versionsCacheFileName = "versions.json"
+)
+
+// cacheDir returns directory where the cache of the root dir is stored, empty when the cache is disabled or
+// the directory can't be determined. Every root dir has its own subdirectory of the cache dir.
+func (e *Engine) cacheDir() string {
+	if !e.cacheEnabled {
+		return ""
+	}
+	baseDir := e.config.CacheDir
+	if baseDir == "" {
+		userCacheDir, err := os.UserCacheDir()
+		if err != nil {
+			e.debugf("Cache disabled, unable to determine user cache dir: %s", err)
+			return ""
+		}
+		baseDir = filepath.Join(userCacheDir, "docker-bakery")
+	} else if !filepath.IsAbs(baseDir) {
+		baseDir = filepath.Join(e.config.RootDir, baseDir)
+	}
+	rootDir, err := filepath.Abs(e.config.RootDir)
+	if err != nil {
+		e.debugf("Cache disabled, unable to resolve root dir: %s", err)
+		return ""
+	}
+	sum := sha256.Sum256([]byte(rootDir))
+	return filepath.Join(baseDir, hex.EncodeToString(sum[:])[:16])
+}
+
+// versionsCacheTTL returns for how long the remote versions are cached, zero disables caching of the versions.
+// Versions are not cached unless the TTL is configured, as the stale versions make push create already existing tags.
+func (cfg *Config) versionsCacheTTL() (time.Duration, error) {
+	if cfg.VersionsCacheTTL == "" {
+		return 0, nil
+	}
+	ttl, err := time.ParseDuration(cfg.VersionsCacheTTL)
+	if err != nil {
+		return 0, fmt.Errorf("invalid versionsCacheTTL %s: %s", cfg.VersionsCacheTTL, err)
+	}
+	return ttl, nil
+}
+
+// readCacheFile decodes the cache file, returns false when the file does not exist, can't be decoded
+// or was written in a different format version.
+func (e *Engine) readCacheFile(fileName string, content interface{}) bool {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			e.debugf("Unable to read cache %s: %s", fileName, err)
+		}
+		return false
+	}
+	var header struct{ Version int }
+	if err = json.Unmarshal(data, &header); err != nil || header.Version != cacheFormatVersion {
+		e.debugf("Ignoring cache %s written in different format", fileName)
+		return false
+	}
+	if err = json.Unmarshal(data, content); err != nil {
+		e.debugf("Unable to decode cache %s: %s", fileName, err)
+		return false
+	}
+	return true
+}
+
+// writeCacheFile replaces the cache file atomically, so concurrently running commands never read partially written cache.
+// Cache is an optimization only, so errors are not reported.
+func (e *Engine) writeCacheFile(fileName string, content interface{}) {
+	data, err := json.Marshal(content)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(fileName), 0755)
+	}
+	var tmpFile *os.File
+	if err == nil {
+		tmpFile, err = ioutil.TempFile(filepath.Dir(fileName), filepath.Base(fileName)+".*.tmp")
+	}
+	if err == nil {
+		_, err = tmpFile.Write(data)
+		if closeErr := tmpFile.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Rename(tmpFile.Name(), fileName)
+		}
+		if err != nil {
+			os.Remove(tmpFile.Name())
+		}
+	}
+	if err != nil {
+		e.debugf("Unable to write cache %s: %s", fileName, err)
+	}
+}
+
+// versionsCache holds latest versions of the images obtained from the git remote tags.
+type versionsCache struct {
+	Version  int
+	Created  time.Time
+	Versions map[string]string
+}
+
+// readCachedVersions returns latest versions cached less than TTL ago, nil when there are none.
+func (e *Engine) readCachedVersions() map[string]*semver.Version {
+	ttl, err := e.config.versionsCacheTTL()
+	cacheDir := e.cacheDir()
+	if err != nil || ttl <= 0 || cacheDir == "" {
+		return nil
+	}
+	fileName := filepath.Join(cacheDir, versionsCacheFileName)
+	cache := &versionsCache{}
+	if !e.readCacheFile(fileName, cache) || time.Since(cache.Created) > ttl || cache.Created.After(time.Now()) {
+		return nil
+	}
+
+	versions := make(map[string]*semver.Version, len(cache.Versions))
+	for imgName, version := range cache.Versions {
+		ver, err := semver.NewVersion(version)
+		if err != nil {
+			e.debugf("Ignoring cache %s with invalid version %s of %s", fileName, version, imgName)
+			return nil
+		}
+		versions[imgName] = ver
+	}
+	return versions
+}
+
+// writeCachedVersions stores the latest versions in the cache.
+func (e *Engine) writeCachedVersions(versions map[string]*semver.Version) {
+	ttl, err := e.config.versionsCacheTTL()
+	cacheDir := e.cacheDir()
+	if err != nil || ttl <= 0 || cacheDir == "" {
+		return
+	}
+	cache := &versionsCache{Version: cacheFormatVersion, Created: time.Now(), Versions: make(map[string]string, len(versions))}
+	for imgName, ver := range versions {
+		cache.Versions[imgName] = ver.Original()
+	}
+	e.writeCacheFile(filepath.Join(cacheDir, versionsCacheFileName), cache)
+}
+
+// invalidateCachedVersions removes the cached versions, called when the tags are pushed to the remote.
+func (e *Engine) invalidateCachedVersions() {
+	cacheDir := e.cacheDir()
+	if cacheDir == "" {
+		return
+	}
+	err := os.Remove(filepath.Join(cacheDir, versionsCacheFileName))
+	if err != nil && !os.IsNotExist(err) {
+		e.debugf("Unable to invalidate cached versions: %s", err)
+	}
+}
+
+// cachedImage is the parsed template stored in the cache along with the size and modification time of the template.
+type cachedImage struct {
+	ModTime          int64
+	Size             int64
+	Name             string
+	DockerfileDir    string
+	DependsOnLong    string
+	DependsOnShort   string
+	DependsOnVersion string
+}
+
+// imagesCache holds parsed templates, key is the absolute path of the template.
+type imagesCache struct {
+	Version int
+	Images  map[string]*cachedImage
+}
+
+// cachingImageParser returns images parsed from the templates that did not change since they were cached.
+// Safe for concurrent use.
+type cachingImageParser struct {
+	DockerImageParser
+	engine   *Engine
+	fileName string
+	lock     sync.Mutex
+	cached   map[string]*cachedImage
+	// parsed templates used in the current run, only they are stored in the cache
+	used map[string]*cachedImage
+}
+
+// newCachingImageParser reads the cache of the parsed templates, returns nil when the cache is disabled.
+func (e *Engine) newCachingImageParser(parser DockerImageParser) *cachingImageParser {
+	cacheDir := e.cacheDir()
+	if cacheDir == "" {
+		return nil
+	}
+	p := &cachingImageParser{
+		DockerImageParser: parser,
+		engine:            e,
+		fileName:          filepath.Join(cacheDir, imagesCacheFileName),
+		used:              make(map[string]*cachedImage),
+	}
+	cache := &imagesCache{}
+	if e.readCacheFile(p.fileName, cache) && cache.Images != nil {
+		p.cached = cache.Images
+	} else {
+		p.cached = make(map[string]*cachedImage)
+	}
+	return p
+}
+
+func (p *cachingImageParser) ParseDockerfile(dockerfilePath string) (*DockerImage, error) {
+	info, statErr := os.Stat(dockerfilePath)
+	absPath, absErr := filepath.Abs(dockerfilePath)
+	if statErr != nil || absErr != nil {
+		return p.DockerImageParser.ParseDockerfile(dockerfilePath)
+	}
+
+	p.lock.Lock()
+	entry, isCached := p.cached[absPath]
+	p.lock.Unlock()
+	if isCached && entry.ModTime == info.ModTime().UnixNano() && entry.Size == info.Size() {
+		p.markUsed(absPath, entry)
+		return &DockerImage{
+			Name:             entry.Name,
+			DockerfileDir:    entry.DockerfileDir,
+			DockerfilePath:   dockerfilePath,
+			DependsOnLong:    entry.DependsOnLong,
+			DependsOnShort:   entry.DependsOnShort,
+			DependsOnVersion: entry.DependsOnVersion}, nil
+	}
+
+	dockerImg, err := p.DockerImageParser.ParseDockerfile(dockerfilePath)
+	if err != nil || dockerImg == nil {
+		return dockerImg, err
+	}
+	p.markUsed(absPath, &cachedImage{
+		ModTime:          info.ModTime().UnixNano(),
+		Size:             info.Size(),
+		Name:             dockerImg.Name,
+		DockerfileDir:    dockerImg.DockerfileDir,
+		DependsOnLong:    dockerImg.DependsOnLong,
+		DependsOnShort:   dockerImg.DependsOnShort,
+		DependsOnVersion: dockerImg.DependsOnVersion})
+	return dockerImg, nil
+}
+
+func (p *cachingImageParser) markUsed(absPath string, entry *cachedImage) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.used[absPath] = entry
+}
+
+// save stores templates parsed in the current run in the cache, removed templates are dropped from the cache.
+func (p *cachingImageParser) save() {
+	if p == nil {
+		return
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.engine.writeCacheFile(p.fileName, &imagesCache{Version: cacheFormatVersion, Images: p.used})
+}
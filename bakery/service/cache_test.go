@@ -0,0 +1,123 @@
+package service
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Masterminds/semver"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingParser counts templates actually parsed by the wrapped parser
+type countingParser struct {
+	DockerImageParser
+	parsed int32
+}
+
+func (p *countingParser) ParseDockerfile(dockerfilePath string) (*DockerImage, error) {
+	atomic.AddInt32(&p.parsed, 1)
+	return p.DockerImageParser.ParseDockerfile(dockerfilePath)
+}
+
+func givenCachingEngine(t *testing.T) (*Engine, string) {
+	dir := t.TempDir()
+	engine := givenTestEngine(&Config{RootDir: dir, CacheDir: t.TempDir()})
+	engine.cacheEnabled = true
+	return engine, dir
+}
+
+func TestCachingImageParserReusesNotChangedTemplates(t *testing.T) {
+	engine, dir := givenCachingEngine(t)
+	template := filepath.Join(dir, "app", dockerFileTemplateName)
+	writeTestFile(t, template, "FROM base:{{.BASE_VERSION}}\n")
+
+	// first run parses the template
+	parser := &countingParser{DockerImageParser: NewDockerImageParser()}
+	cachingParser := engine.newCachingImageParser(parser)
+	parsed, err := cachingParser.ParseDockerfile(template)
+	assert.NoError(t, err)
+	cachingParser.save()
+
+	// second run takes it from the cache
+	cachingParser = engine.newCachingImageParser(parser)
+	cached, err := cachingParser.ParseDockerfile(template)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), parser.parsed)
+	assert.Equal(t, parsed, cached)
+
+	// changed template is parsed again
+	writeTestFile(t, template, "FROM alpine:3\n")
+	changed, err := cachingParser.ParseDockerfile(template)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), parser.parsed)
+	assert.Equal(t, "alpine", changed.DependsOnShort)
+}
+
+func TestCachingImageParserDisabled(t *testing.T) {
+	engine := givenTestEngine(&Config{RootDir: t.TempDir(), CacheDir: t.TempDir()})
+
+	assert.Nil(t, engine.newCachingImageParser(NewDockerImageParser()))
+	// nil parser can be saved safely
+	engine.newCachingImageParser(NewDockerImageParser()).save()
+}
+
+func TestCachedVersions(t *testing.T) {
+	engine, _ := givenCachingEngine(t)
+	versions := map[string]*semver.Version{"base": semver.MustParse("1.2.0"), "app": semver.MustParse("0.1.0")}
+
+	// versions are not cached by default
+	engine.writeCachedVersions(versions)
+	assert.Nil(t, engine.readCachedVersions())
+
+	engine.config.VersionsCacheTTL = "1m"
+	assert.Nil(t, engine.readCachedVersions())
+	engine.writeCachedVersions(versions)
+	assert.Equal(t, versions, engine.readCachedVersions())
+
+	engine.invalidateCachedVersions()
+	assert.Nil(t, engine.readCachedVersions())
+
+	// expired versions are not used
+	engine.writeCacheFile(filepath.Join(engine.cacheDir(), versionsCacheFileName),
+		&versionsCache{Version: cacheFormatVersion, Created: time.Now().Add(-2 * time.Minute), Versions: map[string]string{"base": "1.2.0"}})
+	assert.Nil(t, engine.readCachedVersions())
+	engine.config.VersionsCacheTTL = "1h"
+	assert.Len(t, engine.readCachedVersions(), 1)
+
+	// caching of the versions can be disabled
+	engine.config.VersionsCacheTTL = "0"
+	assert.Nil(t, engine.readCachedVersions())
+}
+
+func TestCacheDirIsSeparatedPerRootDir(t *testing.T) {
+	engine, _ := givenCachingEngine(t)
+	first := engine.cacheDir()
+	engine.config.RootDir = t.TempDir()
+
+	assert.NotEqual(t, first, engine.cacheDir())
+	assert.Equal(t, filepath.Dir(first), filepath.Dir(engine.cacheDir()))
+}
+
+func TestParseDockerfilesKeepsOrder(t *testing.T) {
+	dir := t.TempDir()
+	templates := make([]string, 0)
+	for i := 0; i < 20; i++ {
+		template := filepath.Join(dir, fmt.Sprintf("image-%02d", i), dockerFileTemplateName)
+		writeTestFile(t, template, "FROM alpine:3\n")
+		templates = append(templates, template)
+	}
+
+	images, err := parseDockerfiles(NewDockerImageParser(), templates)
+	assert.NoError(t, err)
+	for i, image := range images {
+		assert.Equal(t, fmt.Sprintf("image-%02d", i), image.Name)
+	}
+
+	writeTestFile(t, templates[3], "RUN echo\n")
+	writeTestFile(t, templates[7], "RUN echo\n")
+	_, err = parseDockerfiles(NewDockerImageParser(), templates)
+	assert.EqualError(t, err, fmt.Sprintf("unable to extract dependency from %s file. Check if first line starts with `FROM `", templates[3]))
+}
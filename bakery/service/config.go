@@ -1,9 +1,7 @@
 package service
 
 import (
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"strings"
 
 	"time"
@@ -23,45 +21,111 @@ const (
 	imageHierarchyPropName = "BAKERY_IMAGE_HIERARCHY"
 	signatureValuePropName = "BAKERY_SIGNATURE_VALUE"
 	signatureEnvsPropName  = "BAKERY_SIGNATURE_ENVS"
+	profilePropName        = "BAKERY_PROFILE"
+	buildTimestampPropName = "BAKERY_BUILD_TIMESTAMP"
+	gitRevisionPropName    = "BAKERY_GIT_REVISION"
+	gitRepositoryPropName  = "BAKERY_GIT_REPOSITORY"
+	parentImagePropName    = "BAKERY_PARENT_IMAGE"
+	parentDigestPropName   = "BAKERY_PARENT_DIGEST"
 	imageVersionPropName   = "IMAGE_VERSION"
 	imageNamePropName      = "IMAGE_NAME"
+	imagePlatformsPropName = "IMAGE_PLATFORMS"
+	imagePlatformPropName  = "IMAGE_PLATFORM"
+	imageIDFilePropName    = "IMAGE_IIDFILE"
+	digestFilePropName     = "IMAGE_DIGEST_FILE"
+	metadataFilePropName   = "IMAGE_METADATA_FILE"
 	dockerfileDirPropName  = "DOCKERFILE_DIR"
+	defaultImageTag        = "{{.IMAGE_NAME}}:{{.IMAGE_VERSION}}"
+	// configs declaring this version or newer use strict templating by default
+	strictTemplatesConfigVersion = 2
 )
 
+// dynamicPropertyNames are names of the properties set by docker-bakery during runtime, apart from the *_VERSION properties of the images
+var dynamicPropertyNames = []string{
+	builderNamePropName,
+	builderEmailPropName,
+	builderHostPropName,
+	buildDatePropName,
+	imageHierarchyPropName,
+	signatureValuePropName,
+	signatureEnvsPropName,
+	profilePropName,
+	buildTimestampPropName,
+	gitRevisionPropName,
+	gitRepositoryPropName,
+	parentImagePropName,
+	parentDigestPropName,
+	imageVersionPropName,
+	imageNamePropName,
+	imagePlatformsPropName,
+	imagePlatformPropName,
+	imageIDFilePropName,
+	digestFilePropName,
+	metadataFilePropName,
+	dockerfileDirPropName,
+}
+
 // ReadConfig reads configuration file from provided path and returns it as an object.
+// Config can be written in JSON, YAML (.yaml, .yml) or TOML (.toml), the format is detected from the file extension.
+// Configs referenced in the `extends` and `include` sections are deep merged with the config.
 func ReadConfig(configFile string) (*Config, error) {
-	content, err := ioutil.ReadFile(configFile)
+	loader := newConfigLoader()
+	document, err := loader.load(configFile)
 	if err != nil {
 		return nil, err
 	}
 
 	var cfg Config
-	err = json.Unmarshal(content, &cfg)
-	if err != nil {
-		return nil, err
+	if err = decodeDocument(document, &cfg); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %s", configFile, err)
+	}
+	if err = cfg.prepare(); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %s", configFile, err)
 	}
+	cfg.configFiles = loader.files
+	cfg.propertySources = loader.propertySources
 	return &cfg, nil
 }
 
-// UpdateDynamicProperties updates config object state with the corresponding values of all dynamic properties.
+// prepare interpolates and validates the decoded or programmatically created config.
+func (cfg *Config) prepare() error {
+	if cfg.Properties == nil {
+		cfg.Properties = make(map[string]string)
+	}
+	if err := cfg.interpolate(); err != nil {
+		return err
+	}
+	if err := validateLabelsMode(cfg.Labels.Mode); err != nil {
+		return err
+	}
+	if _, err := cfg.versionsCacheTTL(); err != nil {
+		return err
+	}
+	return cfg.validateRetryPolicies()
+}
+
+// updateDynamicProperties updates config object state with the corresponding values of all dynamic properties.
 // Called in every cycle of executing docker command.
-func (cfg *Config) UpdateDynamicProperties(dockerImg *DockerImage) {
+func (e *Engine) updateDynamicProperties(dockerImg *DockerImage) {
 	nextVersion := dockerImg.GetNextVersionString()
-	buildDate := time.Now().Format("2006-01-02 15:04:05")
-	cfg.setBuildDate(buildDate)
-	cfg.setImageName(dockerImg.Name)
-	cfg.setDockerfileDir(dockerImg.DockerfileDir)
-	cfg.setConstantImageVersion(nextVersion)
-	cfg.setDynamicImageVersionProperty(dockerImg.Name, nextVersion)
-	cfg.setImageHierarchy(dockerImg)
-	cfg.buildSignature()
+	now := time.Now()
+	e.config.setBuildDate(now.Format("2006-01-02 15:04:05"))
+	e.config.setBuildTimestamp(now.Format(time.RFC3339))
+	e.config.setImageName(dockerImg.Name)
+	e.config.setImagePlatforms(dockerImg.GetPlatforms())
+	e.config.setDockerfileDir(dockerImg.DockerfileDir)
+	e.config.setConstantImageVersion(nextVersion)
+	e.setDynamicImageVersionProperty(dockerImg.Name, nextVersion)
+	e.setImageHierarchy(dockerImg)
+	e.setParentImage(dockerImg)
+	e.config.buildSignature()
 }
 
-// UpdateVersionProperties updates config with versions of the images.
+// updateVersionProperties updates config with versions of the images.
 // Called once after latest versions of images are known (after analysing entire structure)
-func (cfg *Config) UpdateVersionProperties(versions map[string]*semver.Version) {
+func (e *Engine) updateVersionProperties(versions map[string]*semver.Version) {
 	for image, version := range versions {
-		cfg.setDynamicImageVersionProperty(image, version.String())
+		e.setDynamicImageVersionProperty(image, version.String())
 	}
 }
 
@@ -72,10 +136,10 @@ func (cfg *Config) UpdateVersionProperties(versions map[string]*semver.Version)
 // - has the `_VERSION` suffix
 // The result of invocation setDynamicImageVersionProperty('redis', '1.0.0')
 // would be property REDIS_VERSION = '1.0.0'
-func (cfg *Config) setDynamicImageVersionProperty(imgName, version string) {
+func (e *Engine) setDynamicImageVersionProperty(imgName, version string) {
 	propertyName := dynamicImageVersionName(imgName)
-	commons.Debugf("Setting property %s to %s", propertyName, version)
-	cfg.Properties[propertyName] = version
+	e.debugf("Setting property %s to %s", propertyName, version)
+	e.config.Properties[propertyName] = version
 }
 
 func dynamicImageVersionName(imgName string) string {
@@ -100,6 +164,26 @@ func (cfg *Config) setImageName(name string) {
 	cfg.Properties[imageNamePropName] = name
 }
 
+func (cfg *Config) setImagePlatforms(platforms []string) {
+	cfg.Properties[imagePlatformsPropName] = strings.Join(platforms, ",")
+	cfg.setImagePlatform("")
+	if len(platforms) == 1 {
+		cfg.setImagePlatform(platforms[0])
+	}
+}
+
+// setImagePlatform sets the IMAGE_PLATFORM property to the platform currently built by the command builder.
+func (cfg *Config) setImagePlatform(platform string) {
+	cfg.Properties[imagePlatformPropName] = platform
+}
+
+// setOutputFiles sets properties with paths of the files where build/push commands can write image id and digest.
+func (cfg *Config) setOutputFiles(files *outputFiles) {
+	cfg.Properties[imageIDFilePropName] = files.ImageIDFile
+	cfg.Properties[digestFilePropName] = files.DigestFile
+	cfg.Properties[metadataFilePropName] = files.MetadataFile
+}
+
 func (cfg *Config) setDockerfileDir(dir string) {
 	cfg.Properties[dockerfileDirPropName] = dir
 }
@@ -108,6 +192,19 @@ func (cfg *Config) setBuildDate(buildDate string) {
 	cfg.Properties[buildDatePropName] = buildDate
 }
 
+// setBuildTimestamp sets the BAKERY_BUILD_TIMESTAMP property to the build date in RFC 3339 format.
+func (cfg *Config) setBuildTimestamp(timestamp string) {
+	cfg.Properties[buildTimestampPropName] = timestamp
+}
+
+func (cfg *Config) setGitRevision(revision string) {
+	cfg.Properties[gitRevisionPropName] = revision
+}
+
+func (cfg *Config) setGitRepository(repository string) {
+	cfg.Properties[gitRepositoryPropName] = repository
+}
+
 func (cfg *Config) setBuilderName(name string) {
 	cfg.Properties[builderNamePropName] = name
 }
@@ -120,19 +217,25 @@ func (cfg *Config) setBuilderHost(host string) {
 	cfg.Properties[builderHostPropName] = host
 }
 
+// setProfile sets the BAKERY_PROFILE property to the name of the selected profile.
+func (cfg *Config) setProfile(profileName string) {
+	cfg.profile = profileName
+	cfg.Properties[profilePropName] = profileName
+}
+
 // setImageHierarchy updates the config properties with a special BAKERY_IMAGE_HIERARCHY property.
 // Embedding this property in the chain of docker images allows for tracking entire hierarchy of the image including its
 // parents and versions
-func (cfg *Config) setImageHierarchy(dockerImage *DockerImage) {
+func (e *Engine) setImageHierarchy(dockerImage *DockerImage) {
 	parentVersionSuffix := ""
-	parentVersion := resolveVersion(dockerImage.DependsOnVersion, cfg)
+	parentVersion := e.resolveVersion(dockerImage.DependsOnVersion, e.config)
 	parentImageName := dockerImage.DependsOnShort
 	if len(parentVersion) > 0 {
 		parentVersionSuffix = fmt.Sprintf(":%s", parentVersion)
 	}
-	commons.Debugf("Resolved parent version to: %s for: %s image", parentVersion, dockerImage.Name)
+	e.debugf("Resolved parent version to: %s for: %s image", parentVersion, dockerImage.Name)
 
-	cfg.Properties[imageHierarchyPropName] = fmt.Sprintf("${BAKERY_IMAGE_HIERARCHY:-\"%s%s\"}->%s:%s",
+	e.config.Properties[imageHierarchyPropName] = fmt.Sprintf("${BAKERY_IMAGE_HIERARCHY:-\"%s%s\"}->%s:%s",
 		parentImageName,
 		parentVersionSuffix,
 		dockerImage.Name,
@@ -141,7 +244,7 @@ func (cfg *Config) setImageHierarchy(dockerImage *DockerImage) {
 
 // resolveVersion tries to resolve version string using dynamic properties from config
 // or falls back to provided version if it was not templated.
-func resolveVersion(versionToResolve string, cfg *Config) string {
+func (e *Engine) resolveVersion(versionToResolve string, cfg *Config) string {
 	if len(versionToResolve) == 0 {
 		return versionToResolve
 	}
@@ -149,7 +252,7 @@ func resolveVersion(versionToResolve string, cfg *Config) string {
 	t := template.New("version-substitution-template")
 	t, err := t.Parse(versionToResolve)
 	if err != nil {
-		commons.Debugf("Unable to parse version as a template, falling back to non templated version [%s], err: %s", versionToResolve, err)
+		e.debugf("Unable to parse version as a template, falling back to non templated version [%s], err: %s", versionToResolve, err)
 		return versionToResolve
 	}
 
@@ -162,6 +265,9 @@ func resolveVersion(versionToResolve string, cfg *Config) string {
 // and updates the config with new variables available for usage in templates.
 func (cfg *Config) buildSignature() {
 	signatureProperties := []string{builderNamePropName, builderEmailPropName, builderHostPropName, buildDatePropName, imageHierarchyPropName}
+	if cfg.profile != "" {
+		signatureProperties = append(signatureProperties, profilePropName)
+	}
 	propsCount := len(signatureProperties)
 
 	var signatureValueBuf bytes.Buffer
@@ -180,11 +286,55 @@ func (cfg *Config) buildSignature() {
 	cfg.Properties[signatureEnvsPropName] = signatureEnvsBuf.String()
 }
 
-// PrintProperties prints all properties available in the config (along with the dynamic ones).
-func (cfg *Config) PrintProperties() {
-	fmt.Println("Config properties:")
-	sortedKeys := commons.SortMapKeys(cfg.Properties)
+// fillTemplateString fills provided text template with config properties and returns the result.
+func (e *Engine) fillTemplateString(name, text string) (string, error) {
+	t, err := commons.ParseTemplate(name, text, e.templateOptions(e.hierarchy.GetImageByName(e.config.Properties[imageNamePropName])))
+	if err != nil {
+		return "", err
+	}
+
+	content, err := commons.ExecuteTemplate(t, name, e.config.Properties)
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+// templateOptions returns options of the Dockerfile and command templates of the provided image (nil when the
+// template does not belong to any image). Strict templating is enabled explicitly with `strictTemplates`
+// or by default in configs declaring version 2 or newer.
+func (e *Engine) templateOptions(dockerImage *DockerImage) commons.TemplateOptions {
+	strict := e.config.Version >= strictTemplatesConfigVersion
+	if e.config.StrictTemplates != nil {
+		strict = *e.config.StrictTemplates
+	}
+	return commons.TemplateOptions{Strict: strict, Funcs: e.templateFuncs(dockerImage), Partials: e.config.partials}
+}
+
+// imageTags returns image references (filled with config properties) used by the built-in builders
+// to tag and push the currently processed image. Defaults to IMAGE_NAME:IMAGE_VERSION.
+func (e *Engine) imageTags() ([]string, error) {
+	tagTemplates := e.config.Commands.ImageTags
+	if len(tagTemplates) == 0 {
+		tagTemplates = []string{defaultImageTag}
+	}
+
+	tags := make([]string, 0, len(tagTemplates))
+	for _, tagTemplate := range tagTemplates {
+		tag, err := e.fillTemplateString("imageTag", tagTemplate)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+// printProperties prints all properties available in the config (along with the dynamic ones). Secret values are masked.
+func (e *Engine) printProperties() {
+	fmt.Fprintln(e.stdout, "Config properties:")
+	sortedKeys := commons.SortMapKeys(e.config.Properties)
 	for _, key := range sortedKeys {
-		fmt.Printf("\t%s=%s\n", key, config.Properties[key])
+		fmt.Fprintf(e.stdout, "\t%s=%s\n", key, e.config.mask(e.config.Properties[key]))
 	}
 }
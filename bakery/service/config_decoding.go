@@ -0,0 +1,140 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	jsonConfigFormat = "json"
+	yamlConfigFormat = "yaml"
+	tomlConfigFormat = "toml"
+)
+
+// configFormat detects format of the config file from its extension. JSON is assumed for unknown extensions.
+func configFormat(configFile string) string {
+	switch strings.ToLower(filepath.Ext(configFile)) {
+	case ".yaml", ".yml":
+		return yamlConfigFormat
+	case ".toml":
+		return tomlConfigFormat
+	default:
+		return jsonConfigFormat
+	}
+}
+
+// configDocument converts config content in the provided format into generic document.
+// YAML and TOML documents end up in the same shape as JSON so that all formats share the same keys and decoding rules.
+func configDocument(content []byte, format string) (map[string]interface{}, error) {
+	document := make(map[string]interface{})
+	var err error
+	switch format {
+	case yamlConfigFormat:
+		err = yaml.Unmarshal(content, &document)
+	case tomlConfigFormat:
+		err = toml.Unmarshal(content, &document)
+	default:
+		err = json.Unmarshal(content, &document)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s config: %s", format, err)
+	}
+	if document == nil {
+		document = make(map[string]interface{})
+	}
+	return document, nil
+}
+
+// decodeDocument strictly decodes generic document into the provided structure.
+// Decoding is strict, unknown keys (for example misspelled `autoBuildExclude`) are reported as errors.
+// Keys have to match the config keys exactly, including the case.
+func decodeDocument(document map[string]interface{}, v interface{}) error {
+	if err := checkDocument(document, reflect.TypeOf(v), ""); err != nil {
+		return err
+	}
+	content, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
+
+// checkDocument reports the first key of the document that does not match exactly any config key of the type
+// and the first value that is not a string where the string is expected.
+// encoding/json matches the keys case-insensitively, so without this check RootDir or autobuildexcludes would be silently accepted.
+// YAML and TOML values are typed, so unquoted property like `retries: 3` would be reported as cryptic json error otherwise.
+func checkDocument(value interface{}, t reflect.Type, path string) error {
+	switch t.Kind() {
+	case reflect.String:
+		if _, isString := value.(string); !isString && value != nil {
+			return fmt.Errorf("%q must be a string, got %v (quote the value)", strings.TrimSuffix(path, "."), value)
+		}
+	case reflect.Ptr:
+		return checkDocument(value, t.Elem(), path)
+	case reflect.Slice:
+		if items, ok := value.([]interface{}); ok {
+			for i, item := range items {
+				if err := checkDocument(item, t.Elem(), fmt.Sprintf("%s[%d].", strings.TrimSuffix(path, "."), i)); err != nil {
+					return err
+				}
+			}
+		}
+	case reflect.Map:
+		if entries, ok := value.(map[string]interface{}); ok {
+			for _, key := range sortedDocumentKeys(entries) {
+				if err := checkDocument(entries[key], t.Elem(), path+key+"."); err != nil {
+					return err
+				}
+			}
+		}
+	case reflect.Struct:
+		entries, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		fields := make(map[string]reflect.Type)
+		for i := 0; i < t.NumField(); i++ {
+			if name, isConfigKey := configKey(t.Field(i)); isConfigKey {
+				fields[name] = t.Field(i).Type
+			}
+		}
+		for _, key := range sortedDocumentKeys(entries) {
+			fieldType, known := fields[key]
+			if !known {
+				return unknownKeyError(path+key, key, fields)
+			}
+			if err := checkDocument(entries[key], fieldType, path+key+"."); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func unknownKeyError(keyPath, key string, fields map[string]reflect.Type) error {
+	for name := range fields {
+		if strings.EqualFold(name, key) {
+			return fmt.Errorf("unknown field %q, did you mean %q", keyPath, name)
+		}
+	}
+	return fmt.Errorf("unknown field %q", keyPath)
+}
+
+func sortedDocumentKeys(entries map[string]interface{}) []string {
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,149 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var expectedDecodedConfig = &Config{
+	Properties:        map[string]string{"DEFAULT_PULL_REGISTRY": "registry.local"},
+	Commands:          Commands{DefaultBuildCommand: "docker build .", ImageTags: []string{"{{.IMAGE_NAME}}:latest"}},
+	AutoBuildExcludes: []string{"dog"},
+	Images:            map[string]*ImageConfig{"cat": {Builder: "kaniko"}},
+	PinDigests:        true,
+}
+
+func TestReadConfigInAllFormats(t *testing.T) {
+	testCases := map[string]string{
+		"config.json": `{
+			"properties": {"DEFAULT_PULL_REGISTRY": "registry.local"},
+			"commands": {"defaultBuildCommand": "docker build .", "imageTags": ["{{.IMAGE_NAME}}:latest"]},
+			"autoBuildExcludes": ["dog"],
+			"images": {"cat": {"builder": "kaniko"}},
+			"pinDigests": true
+		}`,
+		"config.yaml": `
+# registry used in FROM clauses
+properties:
+  DEFAULT_PULL_REGISTRY: registry.local
+commands:
+  defaultBuildCommand: docker build .
+  imageTags:
+    - "{{.IMAGE_NAME}}:latest"
+autoBuildExcludes: [dog]
+images:
+  cat:
+    builder: kaniko
+pinDigests: true
+`,
+		"config.toml": `
+autoBuildExcludes = ["dog"]
+pinDigests = true
+
+# registry used in FROM clauses
+[properties]
+DEFAULT_PULL_REGISTRY = "registry.local"
+
+[commands]
+defaultBuildCommand = "docker build ."
+imageTags = ["{{.IMAGE_NAME}}:latest"]
+
+[images.cat]
+builder = "kaniko"
+`,
+	}
+
+	for fileName, content := range testCases {
+		configFile := filepath.Join(t.TempDir(), fileName)
+		ioutil.WriteFile(configFile, []byte(content), 0644)
+
+		cfg, err := ReadConfig(configFile)
+
+		assert.NoError(t, err, fileName)
+		assert.Equal(t, []string{configFile}, cfg.configFiles, fileName)
+		// only values decoded from the file are compared
+		cfg.configFiles, cfg.propertySources = nil, nil
+		assert.Equal(t, expectedDecodedConfig, cfg, fileName)
+	}
+}
+
+func TestReadConfigRejectsUnknownKeys(t *testing.T) {
+	testCases := map[string]string{
+		"config.json": `{"autoBuildExclude": ["dog"]}`,
+		"config.yaml": "commands:\n  defaultBuildCmd: docker build .\n",
+		"config.toml": "[images.cat]\nbuilders = \"kaniko\"\n",
+	}
+
+	for fileName, content := range testCases {
+		_, err := ReadConfig(writeTestFile(t, filepath.Join(t.TempDir(), fileName), content))
+		assert.Error(t, err, fileName)
+		assert.Contains(t, err.Error(), "unknown field", fileName)
+	}
+}
+
+func TestReadConfigRejectsKeysInDifferentCase(t *testing.T) {
+	testCases := map[string]string{
+		`{"RootDir": "images"}`:                                  `unknown field "RootDir", did you mean "rootDir"`,
+		`{"autobuildexcludes": ["dog"]}`:                         `unknown field "autobuildexcludes", did you mean "autoBuildExcludes"`,
+		`{"images": {"cat": {"Builder": "kaniko"}}}`:             `unknown field "images.cat.Builder", did you mean "builder"`,
+		`{"profiles": {"dev": {"commands": {"imagetags": []}}}}`: `unknown field "profiles.dev.commands.imagetags", did you mean "imageTags"`,
+	}
+
+	for content, expectedErr := range testCases {
+		configFile := writeTestFile(t, filepath.Join(t.TempDir(), "config.json"), content)
+		_, err := ReadConfig(configFile)
+		assert.EqualError(t, err, fmt.Sprintf("invalid config %s: %s", configFile, expectedErr), content)
+	}
+}
+
+func TestReadConfigRejectsPropertiesThatAreNotStrings(t *testing.T) {
+	testCases := map[string]string{
+		"config.yaml": "properties:\n  RETRIES: 3\n",
+		"config.toml": "[profiles.dev.properties]\nENABLED = true\n",
+		"config.json": `{"autoBuildExcludes": ["dog", 7]}`,
+		"config.yml":  "properties:\n  VERSION: \"1.10\"\n  DEBUG: false\n",
+	}
+	expectedErrors := map[string]string{
+		"config.yaml": `"properties.RETRIES" must be a string, got 3 (quote the value)`,
+		"config.toml": `"profiles.dev.properties.ENABLED" must be a string, got true (quote the value)`,
+		"config.json": `"autoBuildExcludes[1]" must be a string, got 7 (quote the value)`,
+		"config.yml":  `"properties.DEBUG" must be a string, got false (quote the value)`,
+	}
+
+	for fileName, content := range testCases {
+		configFile := writeTestFile(t, filepath.Join(t.TempDir(), fileName), content)
+		_, err := ReadConfig(configFile)
+		assert.EqualError(t, err, fmt.Sprintf("invalid config %s: %s", configFile, expectedErrors[fileName]), fileName)
+	}
+}
+
+func TestPublishedConfigSchemaIsUpToDate(t *testing.T) {
+	published, err := ioutil.ReadFile(filepath.Join("..", "..", "config.schema.json"))
+	assert.NoError(t, err)
+
+	generated, err := json.MarshalIndent(ConfigSchema(), "", "\t")
+	assert.NoError(t, err)
+	assert.JSONEq(t, string(generated), string(published), "regenerate with: docker-bakery config-schema -f config.schema.json")
+}
+
+func TestTemplateOptions(t *testing.T) {
+	strict, lenient := true, false
+
+	assert.False(t, givenTestEngine(&Config{}).templateOptions(nil).Strict)
+	assert.True(t, givenTestEngine(&Config{Version: 2}).templateOptions(nil).Strict)
+	assert.True(t, givenTestEngine(&Config{StrictTemplates: &strict}).templateOptions(nil).Strict)
+	assert.False(t, givenTestEngine(&Config{Version: 2, StrictTemplates: &lenient}).templateOptions(nil).Strict)
+}
+
+func TestFillTemplateStringReportsUndefinedProperty(t *testing.T) {
+	engine := givenTestEngine(&Config{Version: 2, Properties: map[string]string{"IMAGE_NAME": "dog"}})
+
+	_, err := engine.fillTemplateString("defaultPushCommand", "docker push {{.REGISTRY}}/{{.IMAGE_NAME}}")
+
+	assert.EqualError(t, err, "defaultPushCommand:1: undefined property REGISTRY")
+}
@@ -0,0 +1,368 @@
+package service
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/smartrecruiters/docker-bakery/bakery/commons"
+)
+
+const (
+	extendsConfigKey           = "extends"
+	includeConfigKey           = "include"
+	autoBuildExcludesConfigKey = "autoBuildExcludes"
+	runtimePropertySource      = "-p flag"
+	dynamicPropertySource      = "dynamic"
+)
+
+// directoryOverrideFileNames are names of the files with properties and commands overridden
+// for the images located in the directory of the file and in its subdirectories.
+var directoryOverrideFileNames = []string{"bakery-override.json", "bakery-override.yaml", "bakery-override.yml", "bakery-override.toml"}
+
+// configLoader reads config file along with the configs it extends or includes.
+// Precedence of the values is: extended configs < config file < included configs.
+type configLoader struct {
+	// files being loaded, used to detect cycles
+	loading map[string]bool
+	// loaded config files in order of increasing precedence
+	files []string
+	// property name => config file that defined its value
+	propertySources map[string]string
+}
+
+func newConfigLoader() *configLoader {
+	return &configLoader{loading: make(map[string]bool), files: make([]string, 0), propertySources: make(map[string]string)}
+}
+
+// load returns the document of the config file merged with the documents of extended and included configs.
+// Relative paths of extended and included configs are resolved against the directory of the config file.
+func (l *configLoader) load(configFile string) (map[string]interface{}, error) {
+	if l.loading[configFile] {
+		return nil, fmt.Errorf("config %s is extended or included in a cycle", configFile)
+	}
+	l.loading[configFile] = true
+	defer delete(l.loading, configFile)
+
+	content, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return nil, err
+	}
+	document, err := configDocument(content, configFormat(configFile))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", configFile, err)
+	}
+	var cfg Config
+	if err = decodeDocument(document, &cfg); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %s", configFile, err)
+	}
+	delete(document, extendsConfigKey)
+	delete(document, includeConfigKey)
+
+	merged := make(map[string]interface{})
+	if cfg.Extends != "" {
+		base, err := l.load(resolveConfigPath(configFile, cfg.Extends))
+		if err != nil {
+			return nil, err
+		}
+		mergeConfigDocuments(merged, base)
+	}
+
+	mergeConfigDocuments(merged, document)
+	l.files = append(l.files, configFile)
+	for propertyName := range cfg.Properties {
+		l.propertySources[propertyName] = configFile
+	}
+
+	for _, include := range cfg.Include {
+		included, err := l.load(resolveConfigPath(configFile, include))
+		if err != nil {
+			return nil, err
+		}
+		mergeConfigDocuments(merged, included)
+	}
+	return merged, nil
+}
+
+func resolveConfigPath(configFile, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(filepath.Dir(configFile), path)
+}
+
+// mergeConfigDocuments deep merges src config document into dst. Objects are merged key by key,
+// `autoBuildExcludes` are joined and all other values (including lists) are replaced.
+func mergeConfigDocuments(dst, src map[string]interface{}) {
+	for key, value := range src {
+		if key == autoBuildExcludesConfigKey {
+			dst[key] = joinLists(dst[key], value)
+			continue
+		}
+		deepMerge(dst, key, value)
+	}
+}
+
+func deepMerge(dst map[string]interface{}, key string, value interface{}) {
+	srcMap, isSrcMap := value.(map[string]interface{})
+	dstMap, isDstMap := dst[key].(map[string]interface{})
+	if !isSrcMap || !isDstMap {
+		dst[key] = value
+		return
+	}
+	for k, v := range srcMap {
+		deepMerge(dstMap, k, v)
+	}
+}
+
+func joinLists(dst, src interface{}) interface{} {
+	dstList, isDstList := dst.([]interface{})
+	srcList, isSrcList := src.([]interface{})
+	if !isDstList || !isSrcList {
+		return src
+	}
+	joined := append(make([]interface{}, 0, len(dstList)+len(srcList)), dstList...)
+	for _, item := range srcList {
+		if !containsValue(joined, item) {
+			joined = append(joined, item)
+		}
+	}
+	return joined
+}
+
+func containsValue(list []interface{}, value interface{}) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// readDirectoryOverride reads directory override file, unknown keys are reported as errors.
+func readDirectoryOverride(fileName string) (*DirectoryOverride, error) {
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	document, err := configDocument(content, configFormat(fileName))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", fileName, err)
+	}
+	override := &DirectoryOverride{}
+	if err = decodeDocument(document, override); err != nil {
+		return nil, fmt.Errorf("invalid directory override %s: %s", fileName, err)
+	}
+	override.secrets, err = interpolateProperties(override.Properties)
+	if err != nil {
+		return nil, fmt.Errorf("invalid directory override %s: %s", fileName, err)
+	}
+	override.dir = filepath.Dir(fileName)
+	override.fileName = fileName
+	return override, nil
+}
+
+// isDirectoryOverrideFile checks whenever the file name is one of the directory override file names.
+func isDirectoryOverrideFile(name string) bool {
+	return commons.Contains(directoryOverrideFileNames, name)
+}
+
+// setDirectoryOverrides sets overrides found in the analyzed structure, shallower directories come first.
+func (cfg *Config) setDirectoryOverrides(overrides []*DirectoryOverride) {
+	sorted := append(make([]*DirectoryOverride, 0, len(overrides)), overrides...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return strings.Count(absolutePath(sorted[i].dir), string(filepath.Separator)) <
+			strings.Count(absolutePath(sorted[j].dir), string(filepath.Separator))
+	})
+	cfg.directoryOverrides = sorted
+	for _, override := range sorted {
+		cfg.addSecrets(override.secrets)
+	}
+}
+
+// directoryOverridesOf returns overrides applicable to the directory in order of increasing precedence.
+func (cfg *Config) directoryOverridesOf(dir string) []*DirectoryOverride {
+	dir = absolutePath(dir)
+	applicable := make([]*DirectoryOverride, 0)
+	for _, override := range cfg.directoryOverrides {
+		overrideDir := absolutePath(override.dir)
+		if dir == overrideDir || strings.HasPrefix(dir, overrideDir+string(filepath.Separator)) {
+			applicable = append(applicable, override)
+		}
+	}
+	return applicable
+}
+
+// applyDirectoryOverrides restores properties and commands overridden for the previously processed directory
+// and applies overrides of the provided directory. Properties provided with -p flags are never overridden.
+func (cfg *Config) applyDirectoryOverrides(dir string) {
+	cfg.restoreDirectoryOverrides()
+	for _, override := range cfg.directoryOverridesOf(dir) {
+		for propertyName, value := range override.Properties {
+			if _, isRuntimeProperty := cfg.runtimeProperties[propertyName]; isRuntimeProperty {
+				continue
+			}
+			cfg.overrideProperty(propertyName, value)
+		}
+		cfg.overrideCommands(override.Commands)
+	}
+}
+
+func (cfg *Config) overrideProperty(propertyName, value string) {
+	if cfg.overriddenProperties == nil {
+		cfg.overriddenProperties = make(map[string]*string)
+	}
+	if _, saved := cfg.overriddenProperties[propertyName]; !saved {
+		var original *string
+		if originalValue, exists := cfg.Properties[propertyName]; exists {
+			original = &originalValue
+		}
+		cfg.overriddenProperties[propertyName] = original
+	}
+	cfg.Properties[propertyName] = value
+}
+
+func (cfg *Config) overrideCommands(commands Commands) {
+	if cfg.overriddenCommands == nil {
+		original := cfg.Commands
+		cfg.overriddenCommands = &original
+	}
+	cfg.Commands.overrideWith(commands)
+}
+
+// overrideWith overrides commands with the ones that are defined in the provided commands.
+func (c *Commands) overrideWith(commands Commands) {
+	if commands.DefaultBuildCommand != "" {
+		c.DefaultBuildCommand = commands.DefaultBuildCommand
+	}
+	if commands.DefaultPushCommand != "" {
+		c.DefaultPushCommand = commands.DefaultPushCommand
+	}
+	if len(commands.ImageTags) > 0 {
+		c.ImageTags = commands.ImageTags
+	}
+}
+
+// applyProfile overlays properties and commands of the selected profile on the config.
+// Profile takes precedence over the config files but not over directory overrides and -p flags.
+func (cfg *Config) applyProfile(profileName string) error {
+	if profileName == "" {
+		cfg.setProfile("")
+		return nil
+	}
+	profile, exists := cfg.Profiles[profileName]
+	if !exists || profile == nil {
+		return fmt.Errorf("profile %s is not defined in the config (available profiles: %s)",
+			profileName, strings.Join(cfg.profileNames(), ", "))
+	}
+
+	secrets, err := interpolateProperties(profile.Properties)
+	if err != nil {
+		return fmt.Errorf("profile %s: %s", profileName, err)
+	}
+	cfg.addSecrets(secrets)
+
+	source := profileSource(profileName)
+	for propertyName, value := range profile.Properties {
+		cfg.Properties[propertyName] = value
+		if cfg.propertySources == nil {
+			cfg.propertySources = make(map[string]string)
+		}
+		cfg.propertySources[propertyName] = source
+	}
+	cfg.Commands.overrideWith(profile.Commands)
+	cfg.setProfile(profileName)
+	return nil
+}
+
+func (cfg *Config) profileNames() []string {
+	names := make([]string, 0, len(cfg.Profiles))
+	for name := range cfg.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func profileSource(profileName string) string {
+	return fmt.Sprintf("profile %s", profileName)
+}
+
+func (cfg *Config) restoreDirectoryOverrides() {
+	for propertyName, original := range cfg.overriddenProperties {
+		if original == nil {
+			delete(cfg.Properties, propertyName)
+		} else {
+			cfg.Properties[propertyName] = *original
+		}
+	}
+	cfg.overriddenProperties = nil
+	if cfg.overriddenCommands != nil {
+		cfg.Commands = *cfg.overriddenCommands
+		cfg.overriddenCommands = nil
+	}
+}
+
+// setRuntimeProperty sets property provided with -p flag, such property takes precedence over all config layers.
+func (cfg *Config) setRuntimeProperty(propertyName, value string) {
+	if cfg.runtimeProperties == nil {
+		cfg.runtimeProperties = make(map[string]string)
+	}
+	cfg.runtimeProperties[propertyName] = value
+	cfg.Properties[propertyName] = value
+}
+
+// propertySource returns the name of the layer that provided the current value of the property in the directory.
+func (cfg *Config) propertySource(propertyName, dir string) string {
+	if _, isRuntimeProperty := cfg.runtimeProperties[propertyName]; isRuntimeProperty {
+		return runtimePropertySource
+	}
+	source := cfg.propertySources[propertyName]
+	for _, override := range cfg.directoryOverridesOf(dir) {
+		if _, isOverridden := override.Properties[propertyName]; isOverridden {
+			source = override.fileName
+		}
+	}
+	if source == "" {
+		return dynamicPropertySource
+	}
+	return source
+}
+
+// printLayers prints config layers in order of increasing precedence and the effective properties and commands
+// for the provided directory along with the layer each property value comes from.
+func (e *Engine) printLayers(dir string) {
+	fmt.Fprintln(e.stdout, "Config layers (in order of increasing precedence):")
+	for _, configFile := range e.config.configFiles {
+		fmt.Fprintf(e.stdout, "\t%s\n", configFile)
+	}
+	if e.config.profile != "" {
+		fmt.Fprintf(e.stdout, "\t%s\n", profileSource(e.config.profile))
+	}
+	for _, override := range e.config.directoryOverridesOf(dir) {
+		fmt.Fprintf(e.stdout, "\t%s\n", override.fileName)
+	}
+	if len(e.config.runtimeProperties) > 0 {
+		fmt.Fprintf(e.stdout, "\t%s\n", runtimePropertySource)
+	}
+
+	fmt.Fprintln(e.stdout, "Effective properties:")
+	for _, key := range commons.SortMapKeys(e.config.Properties) {
+		fmt.Fprintf(e.stdout, "\t%s=%s (%s)\n", key, e.config.mask(e.config.Properties[key]), e.config.propertySource(key, dir))
+	}
+
+	fmt.Fprintln(e.stdout, "Effective commands:")
+	fmt.Fprintf(e.stdout, "\tdefaultBuildCommand=%s\n", e.config.mask(e.config.Commands.DefaultBuildCommand))
+	fmt.Fprintf(e.stdout, "\tdefaultPushCommand=%s\n", e.config.mask(e.config.Commands.DefaultPushCommand))
+	fmt.Fprintf(e.stdout, "\timageTags=%s\n", e.config.mask(strings.Join(e.config.Commands.ImageTags, ",")))
+	fmt.Fprintf(e.stdout, "Auto build excludes: %s\n", strings.Join(e.config.AutoBuildExcludes, ","))
+}
+
+func absolutePath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTestFile(t *testing.T, fileName, content string) string {
+	os.MkdirAll(filepath.Dir(fileName), 0755)
+	assert.NoError(t, ioutil.WriteFile(fileName, []byte(content), 0644))
+	return fileName
+}
+
+func TestReadConfigMergesExtendedAndIncludedConfigs(t *testing.T) {
+	// given
+	dir := t.TempDir()
+	base := writeTestFile(t, filepath.Join(dir, "base", "base.json"), `{
+		"properties": {"REGISTRY": "base.local", "JAVA_VERSION": "11", "OS": "ubuntu"},
+		"commands": {"defaultBuildCommand": "docker build .", "defaultPushCommand": "docker push base"},
+		"autoBuildExcludes": ["dog"],
+		"verbose": true
+	}`)
+	writeTestFile(t, filepath.Join(dir, "team", "java.yaml"), "properties:\n  JAVA_VERSION: \"17\"\nautoBuildExcludes: [cat]\n")
+	configFile := writeTestFile(t, filepath.Join(dir, "config.json"), `{
+		"extends": "base/base.json",
+		"include": ["team/java.yaml"],
+		"properties": {"REGISTRY": "team.local", "JAVA_VERSION": "14"},
+		"commands": {"defaultPushCommand": "docker push team"},
+		"autoBuildExcludes": ["dog", "mouse"]
+	}`)
+
+	// when
+	cfg, err := ReadConfig(configFile)
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"REGISTRY": "team.local", "JAVA_VERSION": "17", "OS": "ubuntu"}, cfg.Properties)
+	assert.Equal(t, Commands{DefaultBuildCommand: "docker build .", DefaultPushCommand: "docker push team"}, cfg.Commands)
+	assert.Equal(t, []string{"dog", "mouse", "cat"}, cfg.AutoBuildExcludes)
+	assert.True(t, cfg.Verbose)
+	assert.Empty(t, cfg.Extends)
+	assert.Empty(t, cfg.Include)
+	assert.Equal(t, []string{base, configFile, filepath.Join(dir, "team", "java.yaml")}, cfg.configFiles)
+	assert.Equal(t, base, cfg.propertySource("OS", dir))
+	assert.Equal(t, configFile, cfg.propertySource("REGISTRY", dir))
+	assert.Equal(t, filepath.Join(dir, "team", "java.yaml"), cfg.propertySource("JAVA_VERSION", dir))
+}
+
+func TestReadConfigDetectsCycles(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "a.json"), `{"extends": "b.json"}`)
+	writeTestFile(t, filepath.Join(dir, "b.json"), `{"include": ["a.json"]}`)
+
+	_, err := ReadConfig(filepath.Join(dir, "a.json"))
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "cycle")
+}
+
+func TestReadConfigRejectsUnknownKeysInExtendedConfig(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "base.json"), `{"autoBuildExclude": ["dog"]}`)
+	writeTestFile(t, filepath.Join(dir, "config.json"), `{"extends": "base.json"}`)
+
+	_, err := ReadConfig(filepath.Join(dir, "config.json"))
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "base.json")
+}
+
+func TestDirectoryOverridesAreAppliedAndRestored(t *testing.T) {
+	// given
+	dir := t.TempDir()
+	animals := writeTestFile(t, filepath.Join(dir, "animals", "bakery-override.yaml"), "properties:\n  REGISTRY: animals.local\n  OS: alpine\ncommands:\n  defaultPushCommand: docker push animals\n")
+	cats := writeTestFile(t, filepath.Join(dir, "animals", "cats", "bakery-override.json"), `{"properties": {"REGISTRY": "cats.local"}}`)
+	catsOverride, err := readDirectoryOverride(cats)
+	assert.NoError(t, err)
+	animalsOverride, err := readDirectoryOverride(animals)
+	assert.NoError(t, err)
+
+	cfg := &Config{Properties: map[string]string{"REGISTRY": "base.local", "JAVA_VERSION": "11"}, Commands: Commands{DefaultPushCommand: "docker push base"}}
+	cfg.setRuntimeProperty("JAVA_VERSION", "17")
+	cfg.setRuntimeProperty("OS", "ubuntu")
+	cfg.setDirectoryOverrides([]*DirectoryOverride{catsOverride, animalsOverride})
+
+	// when
+	cfg.applyDirectoryOverrides(filepath.Join(dir, "animals", "cats", "persian"))
+
+	// then
+	assert.Equal(t, map[string]string{"REGISTRY": "cats.local", "JAVA_VERSION": "17", "OS": "ubuntu"}, cfg.Properties)
+	assert.Equal(t, "docker push animals", cfg.Commands.DefaultPushCommand)
+	assert.Equal(t, cats, cfg.propertySource("REGISTRY", filepath.Join(dir, "animals", "cats", "persian")))
+	assert.Equal(t, runtimePropertySource, cfg.propertySource("OS", filepath.Join(dir, "animals", "cats", "persian")))
+
+	// when
+	cfg.applyDirectoryOverrides(filepath.Join(dir, "plants"))
+
+	// then
+	assert.Equal(t, map[string]string{"REGISTRY": "base.local", "JAVA_VERSION": "17", "OS": "ubuntu"}, cfg.Properties)
+	assert.Equal(t, "docker push base", cfg.Commands.DefaultPushCommand)
+}
+
+func TestDirectoryOverrideRejectsUnknownKeys(t *testing.T) {
+	fileName := writeTestFile(t, filepath.Join(t.TempDir(), "bakery-override.json"), `{"autoBuildExcludes": ["dog"]}`)
+
+	_, err := readDirectoryOverride(fileName)
+
+	assert.Error(t, err)
+}
+
+func TestApplyProfile(t *testing.T) {
+	// given
+	cfg := &Config{
+		Properties: map[string]string{"REGISTRY": "dev.local", "OS": "ubuntu"},
+		Commands:   Commands{DefaultBuildCommand: "docker build .", DefaultPushCommand: "docker push dev"},
+		Profiles: map[string]*Profile{
+			"prod": {Properties: map[string]string{"REGISTRY": "prod.local"}, Commands: Commands{DefaultPushCommand: "docker push prod"}},
+		},
+	}
+
+	// when
+	err := cfg.applyProfile("prod")
+	cfg.buildSignature()
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, "prod.local", cfg.Properties["REGISTRY"])
+	assert.Equal(t, "prod", cfg.Properties[profilePropName])
+	assert.Equal(t, Commands{DefaultBuildCommand: "docker build .", DefaultPushCommand: "docker push prod"}, cfg.Commands)
+	assert.Equal(t, "profile prod", cfg.propertySource("REGISTRY", ""))
+	assert.Contains(t, cfg.Properties[signatureValuePropName], ";prod")
+	assert.Contains(t, cfg.Properties[signatureEnvsPropName], `BAKERY_PROFILE="prod"`)
+}
+
+func TestApplyUnknownProfile(t *testing.T) {
+	cfg := &Config{Properties: map[string]string{}, Profiles: map[string]*Profile{"prod": {}, "dev": {}}}
+
+	err := cfg.applyProfile("staging")
+
+	assert.EqualError(t, err, "profile staging is not defined in the config (available profiles: dev, prod)")
+}
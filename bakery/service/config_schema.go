@@ -0,0 +1,92 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/smartrecruiters/docker-bakery/bakery/commons"
+)
+
+const jsonSchemaVersion = "http://json-schema.org/draft-07/schema#"
+
+// ConfigSchema returns JSON Schema describing the config file. Schema is generated from the Config structure,
+// keys are taken from the json tags and descriptions from the description tags.
+func ConfigSchema() map[string]interface{} {
+	schema := typeSchema(reflect.TypeOf(Config{}))
+	schema["$schema"] = jsonSchemaVersion
+	schema["title"] = "docker-bakery config"
+	return schema
+}
+
+// WriteConfigSchema writes config JSON Schema to the provided file or to the standard output when file name is empty.
+func WriteConfigSchema(fileName string) error {
+	if fileName != "" {
+		return commons.WriteToJSONFile(ConfigSchema(), fileName)
+	}
+	data, err := json.MarshalIndent(ConfigSchema(), "", "\t")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stdout, string(data))
+	return nil
+}
+
+func typeSchema(t reflect.Type) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return typeSchema(t.Elem())
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		return structSchema(t)
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+// structSchema describes exported fields of the structure. Unknown keys are not allowed as config decoding is strict.
+func structSchema(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, ok := configKey(field)
+		if !ok {
+			continue
+		}
+		fieldSchema := typeSchema(field.Type)
+		if description := field.Tag.Get("description"); description != "" {
+			fieldSchema["description"] = description
+		}
+		properties[name] = fieldSchema
+	}
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
+
+// configKey returns the config key of the structure field taken from its json tag, false for the fields not present in the config.
+func configKey(field reflect.StructField) (string, bool) {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if field.PkgPath != "" || name == "-" {
+		return "", false
+	}
+	if name == "" {
+		name = field.Name
+	}
+	return name, true
+}
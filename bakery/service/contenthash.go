@@ -0,0 +1,182 @@
+package service
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/smartrecruiters/docker-bakery/bakery/commons"
+)
+
+const (
+	dockerignoreFileName  = ".dockerignore"
+	statusUpToDate        = "up-to-date"
+	volatilePropertyValue = "<volatile>"
+)
+
+// volatileProperties change with every build even if the content of the image does not change,
+// therefore they are not taken into account when calculating the content hash.
+var volatileProperties = []string{
+	imageVersionPropName,
+	buildDatePropName,
+	buildTimestampPropName,
+	gitRevisionPropName,
+	builderNamePropName,
+	builderEmailPropName,
+	builderHostPropName,
+	imageHierarchyPropName,
+	signatureValuePropName,
+	signatureEnvsPropName,
+}
+
+// contentHash calculates hash of everything that affects the image content:
+// - Dockerfile rendered from the template (with volatile properties like build date or the image version neutralized)
+// - partials used by the template
+// - files of the build context, respecting the .dockerignore rules
+// - content hash of the parent image (its reference for the external parents)
+func (e *Engine) contentHash(dockerImage *DockerImage) (string, error) {
+	h := sha256.New()
+
+	t, err := commons.ParseTemplateFile(dockerImage.DockerfilePath, e.templateOptions(dockerImage))
+	if err != nil {
+		return "", err
+	}
+	rendered, err := commons.ExecuteTemplate(t, dockerImage.DockerfilePath, e.hashProperties(dockerImage))
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(h, "dockerfile:%d\n", len(rendered))
+	h.Write(rendered)
+
+	if e.config.partials != nil {
+		for _, partial := range e.config.partials.UsedFiles(t) {
+			content := e.config.partials.Content(partial)
+			fmt.Fprintf(h, "partial:%s:%d\n", partial, len(content))
+			io.WriteString(h, content)
+		}
+	}
+
+	if err = hashBuildContext(h, dockerImage.DockerfileDir); err != nil {
+		return "", err
+	}
+
+	fmt.Fprintf(h, "parent:%s\n", e.parentContentIdentity(dockerImage))
+	return fmt.Sprintf("sha256:%x", h.Sum(nil)), nil
+}
+
+// hashProperties returns copy of the config properties with volatile properties neutralized.
+func (e *Engine) hashProperties(dockerImage *DockerImage) map[string]string {
+	properties := make(map[string]string, len(e.config.Properties))
+	for k, v := range e.config.Properties {
+		properties[k] = v
+	}
+	for _, p := range volatileProperties {
+		properties[p] = volatilePropertyValue
+	}
+	properties[dynamicImageVersionName(dockerImage.Name)] = volatilePropertyValue
+	return properties
+}
+
+// hashBuildContext writes to the hash paths, modes and contents of all files in the build context
+// that are not excluded by .dockerignore. Dockerfile and its template are skipped as the rendered Dockerfile is hashed separately.
+func hashBuildContext(h hash.Hash, contextDir string) error {
+	files := make([]string, 0)
+	err := walkBuildContext(contextDir, nil, func(relPath string, info os.FileInfo) error {
+		if !info.IsDir() && relPath != dockerfileName && relPath != dockerFileTemplateName {
+			files = append(files, relPath)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Strings(files)
+	for _, relPath := range files {
+		if err = hashFile(h, contextDir, relPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkBuildContext calls fn with the slash separated relative path of every file and directory of the build context
+// that is not excluded by .dockerignore. Paths from alwaysIncluded are passed to fn even when they are excluded.
+// Ignored directories are not passed to fn but they are still walked when the negated rules may re-include files inside them.
+func walkBuildContext(contextDir string, alwaysIncluded []string, fn func(relPath string, info os.FileInfo) error) error {
+	ignorePatterns, err := commons.ReadIgnoreFile(filepath.Join(contextDir, dockerignoreFileName))
+	if err != nil {
+		return err
+	}
+
+	return filepath.Walk(contextDir, func(sourcePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(contextDir, sourcePath)
+		if err != nil || relPath == "." {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+		if ignorePatterns.Matches(relPath) && !commons.Contains(alwaysIncluded, relPath) {
+			// files inside ignored directory can be re-included by the negated rules, as the docker CLI does
+			if info.IsDir() && !ignorePatterns.MayReinclude(relPath) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		return fn(relPath, info)
+	})
+}
+
+func hashFile(h hash.Hash, contextDir, relPath string) error {
+	fullPath := filepath.Join(contextDir, filepath.FromSlash(relPath))
+	info, err := os.Lstat(fullPath)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(h, "file:%s:%o:%d\n", relPath, info.Mode(), info.Size())
+	if info.Mode()&os.ModeSymlink != 0 {
+		link, err := os.Readlink(fullPath)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "link:%s\n", link)
+		return nil
+	}
+
+	f, err := os.Open(fullPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(h, f)
+	return err
+}
+
+// parentContentIdentity returns the identifier of the parent image content that is the same for build and push:
+// content hash of the parent processed in the current run, content hash from the lock file
+// or the parent reference from the FROM clause for the external images.
+func (e *Engine) parentContentIdentity(dockerImage *DockerImage) string {
+	for _, r := range e.commandResults {
+		if r.Name == dockerImage.DependsOnShort && r.ContentHash != "" {
+			return r.ContentHash
+		}
+	}
+	if lockedParent, isLocked := e.lock.Images[dockerImage.DependsOnShort]; isLocked && lockedParent.ContentHash != "" {
+		return lockedParent.ContentHash
+	}
+	return e.resolveVersion(dockerImage.DependsOnLong, e.config)
+}
+
+// isUpToDate checks whenever the image content hash is the same as the one recorded for the last released version.
+func (e *Engine) isUpToDate(dockerImage *DockerImage, hash string) bool {
+	lockedImage, isLocked := e.lock.Images[dockerImage.Name]
+	return isLocked && hash != "" &&
+		lockedImage.ContentHash == hash &&
+		lockedImage.Version == dockerImage.GetLatestVersionString()
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Masterminds/semver"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func givenContentHashImage(t *testing.T) (*Engine, *DockerImage) {
+	dir := t.TempDir()
+	ioutil.WriteFile(filepath.Join(dir, dockerFileTemplateName), []byte("FROM ubuntu:20.04\nLABEL version={{.IMAGE_VERSION}} built={{.BUILD_DATE}}\nCOPY app.sh /\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "app.sh"), []byte("echo app"), 0644)
+	engine := givenTestEngine(&Config{Properties: map[string]string{imageVersionPropName: "1.0.0", buildDatePropName: "2020-01-01"}})
+	return engine, &DockerImage{Name: "app", DependsOnShort: "ubuntu", DependsOnLong: "ubuntu:20.04",
+		DockerfileDir: dir, DockerfilePath: filepath.Join(dir, dockerFileTemplateName)}
+}
+
+func TestContentHashIgnoresVolatileProperties(t *testing.T) {
+	// given
+	engine, img := givenContentHashImage(t)
+	before, err := engine.contentHash(img)
+	assert.NoError(t, err)
+
+	// when
+	engine.config.Properties[imageVersionPropName] = "1.0.1"
+	engine.config.Properties[buildDatePropName] = "2020-02-02"
+	after, err := engine.contentHash(img)
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, before, after)
+}
+
+func TestContentHashChangesWithBuildContext(t *testing.T) {
+	// given
+	engine, img := givenContentHashImage(t)
+	before, err := engine.contentHash(img)
+	assert.NoError(t, err)
+
+	// when
+	ioutil.WriteFile(filepath.Join(img.DockerfileDir, "app.sh"), []byte("echo changed"), 0644)
+	after, err := engine.contentHash(img)
+
+	// then
+	assert.NoError(t, err)
+	assert.NotEqual(t, before, after)
+}
+
+func TestContentHashRespectsDockerignore(t *testing.T) {
+	// given
+	engine, img := givenContentHashImage(t)
+	ioutil.WriteFile(filepath.Join(img.DockerfileDir, dockerignoreFileName), []byte("tmp\n*.log\n"), 0644)
+	before, err := engine.contentHash(img)
+	assert.NoError(t, err)
+
+	// when
+	os.Mkdir(filepath.Join(img.DockerfileDir, "tmp"), 0755)
+	ioutil.WriteFile(filepath.Join(img.DockerfileDir, "tmp", "cache"), []byte("cache"), 0644)
+	ioutil.WriteFile(filepath.Join(img.DockerfileDir, "build.log"), []byte("log"), 0644)
+	ioutil.WriteFile(filepath.Join(img.DockerfileDir, dockerfileName), []byte("FROM ubuntu:20.04\n"), 0644)
+	after, err := engine.contentHash(img)
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, before, after)
+}
+
+func TestContentHashChangesWithFileReincludedInIgnoredDirectory(t *testing.T) {
+	// given
+	engine, img := givenContentHashImage(t)
+	ioutil.WriteFile(filepath.Join(img.DockerfileDir, dockerignoreFileName), []byte("*\n!src/main.go\n"), 0644)
+	os.Mkdir(filepath.Join(img.DockerfileDir, "src"), 0755)
+	ioutil.WriteFile(filepath.Join(img.DockerfileDir, "src", "main.go"), []byte("package main"), 0644)
+	before, err := engine.contentHash(img)
+	assert.NoError(t, err)
+
+	// when
+	ioutil.WriteFile(filepath.Join(img.DockerfileDir, "src", "main.go"), []byte("package main\n\nfunc main() {}"), 0644)
+	after, err := engine.contentHash(img)
+
+	// then
+	assert.NoError(t, err)
+	assert.NotEqual(t, before, after)
+}
+
+func TestContentHashChangesWithParentContent(t *testing.T) {
+	// given
+	engine, img := givenContentHashImage(t)
+	img.DependsOnShort = "base"
+	engine.lock.Images["base"] = &LockedImage{Version: "1.0.0", Digest: "sha256:old", ContentHash: "sha256:old-content"}
+	before, err := engine.contentHash(img)
+	assert.NoError(t, err)
+
+	// when
+	engine.commandResults = []*CommandResult{{Name: "base", NextVersion: "1.0.1", Digest: "sha256:new", ContentHash: "sha256:new-content", Status: statusSuccess}}
+	after, err := engine.contentHash(img)
+
+	// then
+	assert.NoError(t, err)
+	assert.NotEqual(t, before, after)
+}
+
+func TestContentHashIsTheSameForBuildAndPush(t *testing.T) {
+	// given
+	engine, img := givenContentHashImage(t)
+	img.DependsOnShort = "base"
+	engine.commandResults = []*CommandResult{{Name: "base", ImageID: "sha256:image-id", ContentHash: "sha256:base-content", Status: statusSuccess}}
+	built, err := engine.contentHash(img)
+	assert.NoError(t, err)
+
+	// when
+	engine.commandResults = []*CommandResult{{Name: "base", Digest: "sha256:registry-digest", ContentHash: "sha256:base-content", Status: statusSuccess}}
+	pushed, err := engine.contentHash(img)
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, built, pushed)
+}
+
+func TestIsUpToDate(t *testing.T) {
+	engine, img := givenContentHashImage(t)
+	img.latestVersion, _ = semver.NewVersion("1.0.0")
+	engine.lock.Images["app"] = &LockedImage{Version: "1.0.0", ContentHash: "sha256:app"}
+
+	assert.True(t, engine.isUpToDate(img, "sha256:app"))
+	assert.False(t, engine.isUpToDate(img, "sha256:other"))
+	assert.False(t, engine.isUpToDate(img, ""))
+
+	engine.lock.Images["app"].Version = "0.9.0"
+	assert.False(t, engine.isUpToDate(img, "sha256:app"))
+}
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	metadataImageDigestKey  = "containerimage.digest"
+	metadataConfigDigestKey = "containerimage.config.digest"
+)
+
+// outputFiles are the files where builders write the id and the digest of the processed image.
+type outputFiles struct {
+	dir    string
+	logger Logger
+	// file written with --iidfile, contains image id
+	ImageIDFile string
+	// file written with --digestfile/--digest-file, contains digest of the pushed image
+	DigestFile string
+	// file written by buildx with --metadata-file, contains json with both image id and digest
+	MetadataFile string
+}
+
+// newOutputFiles prepares paths of the output files in the new temporary directory.
+func newOutputFiles(logger Logger) (*outputFiles, error) {
+	dir, err := ioutil.TempDir("", "docker-bakery-")
+	if err != nil {
+		return nil, err
+	}
+	return &outputFiles{
+		dir:          dir,
+		logger:       logger,
+		ImageIDFile:  filepath.Join(dir, "iid"),
+		DigestFile:   filepath.Join(dir, "digest"),
+		MetadataFile: filepath.Join(dir, "metadata.json"),
+	}, nil
+}
+
+// remove removes the temporary directory with all output files.
+func (f *outputFiles) remove() {
+	os.RemoveAll(f.dir)
+}
+
+// record reads output files written by the builder and stores the image id and digest in the result.
+func (f *outputFiles) record(result *CommandResult) {
+	if imageID := readOutputFile(f.ImageIDFile); imageID != "" {
+		result.ImageID = imageID
+	}
+	if digest := readOutputFile(f.DigestFile); digest != "" {
+		result.Digest = digest
+	}
+
+	content, err := ioutil.ReadFile(f.MetadataFile)
+	if err != nil {
+		return
+	}
+	metadata := make(map[string]interface{})
+	if err = json.Unmarshal(content, &metadata); err != nil {
+		f.logger.Debugf("Unable to parse metadata file %s, err: %s", f.MetadataFile, err)
+		return
+	}
+	if imageID, ok := metadata[metadataConfigDigestKey].(string); ok && imageID != "" {
+		result.ImageID = imageID
+	}
+	if digest, ok := metadata[metadataImageDigestKey].(string); ok && digest != "" {
+		result.Digest = digest
+	}
+}
+
+func readOutputFile(fileName string) string {
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
+
+// dockerRepoDigest queries local docker for the digest of already pushed image tag.
+func (e *Engine) dockerRepoDigest(ctx context.Context, tag string) (string, error) {
+	inspectCmd := e.newCommand(ctx, "docker", "image", "inspect", "--format", "{{json .RepoDigests}}", tag)
+	out, err := inspectCmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return digestFromRepoDigests(out, tag)
+}
+
+// digestFromRepoDigests extracts digest of the tag repository from the json list of repository digests (repo@sha256:...).
+func digestFromRepoDigests(repoDigestsJSON []byte, tag string) (string, error) {
+	var repoDigests []string
+	if err := json.Unmarshal(repoDigestsJSON, &repoDigests); err != nil {
+		return "", err
+	}
+	repository, _ := splitImageReference(tag)
+	for _, repoDigest := range repoDigests {
+		parts := strings.SplitN(repoDigest, "@", 2)
+		if len(parts) == 2 && parts[0] == repository {
+			return parts[1], nil
+		}
+	}
+	return "", fmt.Errorf("no digest of %s repository found in %v", repository, repoDigests)
+}
@@ -0,0 +1,289 @@
+package service
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	dockerAPIBuilderName = "docker-api"
+	defaultDockerHost    = "unix:///var/run/docker.sock"
+	dockerHostEnvName    = "DOCKER_HOST"
+	// host part of the url is irrelevant when talking over the unix socket but it has to be a valid one
+	dockerAPIBaseURL = "http://docker"
+)
+
+// dockerAPIClient talks to the Docker Engine API in order to build, tag and push images without the docker CLI.
+type dockerAPIClient struct {
+	engine     *Engine
+	httpClient *http.Client
+	baseURL    string
+	output     io.Writer
+}
+
+// dockerJSONMessage represents single progress message streamed by the Docker Engine API.
+type dockerJSONMessage struct {
+	Stream      string `json:"stream"`
+	Status      string `json:"status"`
+	Progress    string `json:"progress"`
+	ID          string `json:"id"`
+	Error       string `json:"error"`
+	ErrorDetail *struct {
+		Message string `json:"message"`
+	} `json:"errorDetail"`
+	Aux json.RawMessage `json:"aux"`
+}
+
+// dockerAuxMessage gathers the auxiliary data sent by the Docker Engine API after build (ID) and push (Digest).
+type dockerAuxMessage struct {
+	ID     string `json:"ID"`
+	Tag    string `json:"Tag"`
+	Digest string `json:"Digest"`
+}
+
+// newDockerAPIClient initializes client for the provided docker host. Supports unix:// and tcp:// hosts.
+// When host is empty DOCKER_HOST env variable is used and falls back to the default docker socket.
+func (e *Engine) newDockerAPIClient(host string) (*dockerAPIClient, error) {
+	if host == "" {
+		host = os.Getenv(dockerHostEnvName)
+	}
+	if host == "" {
+		host = defaultDockerHost
+	}
+
+	hostURL, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse docker host %s: %s", host, err)
+	}
+
+	client := &dockerAPIClient{engine: e, output: e.commandStdout}
+	switch hostURL.Scheme {
+	case "unix":
+		socketPath := hostURL.Path
+		dialer := &net.Dialer{}
+		client.baseURL = dockerAPIBaseURL
+		client.httpClient = &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return dialer.DialContext(ctx, "unix", socketPath)
+				},
+			},
+		}
+	case "tcp", "http":
+		client.baseURL = fmt.Sprintf("http://%s", hostURL.Host)
+		client.httpClient = &http.Client{}
+	default:
+		return nil, fmt.Errorf("unsupported docker host scheme %s, expecting one of: unix, tcp", hostURL.Scheme)
+	}
+	e.debugf("Using docker host %s", host)
+	return client, nil
+}
+
+// build sends the context directory as a tarball to the docker daemon and builds the image from the Dockerfile
+// located in that directory. Optionally the target platform and labels (JSON object) can be provided. Returns the id of the built image.
+func (c *dockerAPIClient) build(ctx context.Context, contextDir, dockerfile, platform string, tags []string, labels string) (string, error) {
+	query := url.Values{}
+	query.Set("dockerfile", dockerfile)
+	query.Set("rm", "1")
+	if platform != "" {
+		query.Set("platform", platform)
+	}
+	if labels != "" {
+		query.Set("labels", labels)
+	}
+	for _, tag := range tags {
+		query.Add("t", tag)
+	}
+
+	pipeReader, pipeWriter := io.Pipe()
+	go func() {
+		pipeWriter.CloseWithError(writeContextTarball(contextDir, dockerfile, pipeWriter))
+	}()
+	defer pipeReader.Close()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url("/build", query), pipeReader)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-tar")
+
+	aux, err := c.doStreaming(req)
+	if err != nil {
+		return "", err
+	}
+	if aux.ID == "" {
+		return "", fmt.Errorf("docker daemon did not report id of the built image")
+	}
+	return aux.ID, nil
+}
+
+// tag adds the reference to the already existing image.
+func (c *dockerAPIClient) tag(ctx context.Context, image, reference string) error {
+	repository, tag := splitImageReference(reference)
+	query := url.Values{}
+	query.Set("repo", repository)
+	query.Set("tag", tag)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url(fmt.Sprintf("/images/%s/tag", image), query), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkDockerAPIResponse(resp)
+}
+
+// push pushes the image reference to its registry. Returns the digest of the pushed manifest.
+func (c *dockerAPIClient) push(ctx context.Context, reference string) (string, error) {
+	repository, tag := splitImageReference(reference)
+	query := url.Values{}
+	query.Set("tag", tag)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url(fmt.Sprintf("/images/%s/push", repository), query), nil)
+	if err != nil {
+		return "", err
+	}
+	auth, err := c.engine.registryAuth(ctx, repository)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Registry-Auth", auth)
+
+	aux, err := c.doStreaming(req)
+	if err != nil {
+		return "", err
+	}
+	return aux.Digest, nil
+}
+
+// url builds the url of the Docker Engine API endpoint.
+func (c *dockerAPIClient) url(endpoint string, query url.Values) string {
+	return fmt.Sprintf("%s%s?%s", c.baseURL, endpoint, query.Encode())
+}
+
+// doStreaming executes the request and consumes streamed json messages, printing the progress to the output.
+// Returns the last auxiliary message received from the daemon or an error if the daemon reported any.
+func (c *dockerAPIClient) doStreaming(req *http.Request) (*dockerAuxMessage, error) {
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err = checkDockerAPIResponse(resp); err != nil {
+		return nil, err
+	}
+
+	aux := &dockerAuxMessage{}
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var msg dockerJSONMessage
+		if err := decoder.Decode(&msg); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("unable to decode docker daemon response: %s", err)
+		}
+
+		if msg.ErrorDetail != nil && msg.ErrorDetail.Message != "" {
+			return nil, fmt.Errorf("docker daemon error: %s", msg.ErrorDetail.Message)
+		}
+		if msg.Error != "" {
+			return nil, fmt.Errorf("docker daemon error: %s", msg.Error)
+		}
+		if len(msg.Aux) > 0 {
+			if err := json.Unmarshal(msg.Aux, aux); err != nil {
+				c.engine.debugf("Unable to decode aux message %s, err: %s", string(msg.Aux), err)
+			}
+		}
+		c.printMessage(&msg)
+	}
+	return aux, nil
+}
+
+// printMessage prints progress message in a similar way the docker CLI does (without the progress bars).
+func (c *dockerAPIClient) printMessage(msg *dockerJSONMessage) {
+	switch {
+	case msg.Stream != "":
+		fmt.Fprint(c.output, msg.Stream)
+	case msg.Status != "" && msg.ID != "":
+		fmt.Fprintf(c.output, "%s: %s\n", msg.ID, msg.Status)
+	case msg.Status != "":
+		fmt.Fprintln(c.output, msg.Status)
+	}
+}
+
+// checkDockerAPIResponse converts non successful docker daemon response to an error.
+func checkDockerAPIResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	body, _ := io.ReadAll(resp.Body)
+	var errResp struct {
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(body, &errResp); err == nil && errResp.Message != "" {
+		return fmt.Errorf("docker daemon responded with %d: %s", resp.StatusCode, errResp.Message)
+	}
+	return fmt.Errorf("docker daemon responded with %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+}
+
+// writeContextTarball writes files of the context directory that are not excluded by .dockerignore to the tar stream.
+// Like with the docker CLI the Dockerfile and .dockerignore are always sent.
+func writeContextTarball(contextDir, dockerfile string, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	alwaysIncluded := []string{filepath.ToSlash(dockerfile), dockerignoreFileName}
+	err := walkBuildContext(contextDir, alwaysIncluded, func(relPath string, info os.FileInfo) error {
+		sourcePath := filepath.Join(contextDir, filepath.FromSlash(relPath))
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			var err error
+			if link, err = os.Readlink(sourcePath); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = relPath
+		if err = tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(sourcePath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// splitImageReference splits image reference into repository and tag. Tag defaults to latest.
+func splitImageReference(reference string) (string, string) {
+	lastSlash := strings.LastIndex(reference, "/")
+	lastColon := strings.LastIndex(reference, ":")
+	if lastColon > lastSlash {
+		return reference[:lastColon], reference[lastColon+1:]
+	}
+	return reference, "latest"
+}
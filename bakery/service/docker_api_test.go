@@ -0,0 +1,177 @@
+package service
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// startFakeDockerDaemon starts http server listening on the unix socket in the temporary directory.
+// Returns docker host pointing to that socket.
+func startFakeDockerDaemon(t *testing.T, handler http.Handler) string {
+	socketPath := filepath.Join(t.TempDir(), "docker.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Skipf("unix sockets are not supported: %s", err)
+	}
+	server := &http.Server{Handler: handler}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+	return "unix://" + socketPath
+}
+
+func newTestDockerAPIClient(t *testing.T, handler http.Handler) *dockerAPIClient {
+	client, err := givenTestEngine(&Config{}).newDockerAPIClient(startFakeDockerDaemon(t, handler))
+	assert.NoError(t, err)
+	client.output = ioutil.Discard
+	return client
+}
+
+func TestDockerAPIBuildSendsContextAndReturnsImageID(t *testing.T) {
+	// given
+	contextDir := t.TempDir()
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(contextDir, "Dockerfile"), []byte("FROM ubuntu:latest\n"), 0644))
+	assert.NoError(t, os.Mkdir(filepath.Join(contextDir, "files"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(contextDir, "files", "app.conf"), []byte("conf"), 0644))
+
+	var receivedFiles []string
+	var receivedTags []string
+	var receivedLabels string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/build", func(w http.ResponseWriter, r *http.Request) {
+		receivedTags = r.URL.Query()["t"]
+		receivedLabels = r.URL.Query().Get("labels")
+		tr := tar.NewReader(r.Body)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			assert.NoError(t, err)
+			receivedFiles = append(receivedFiles, header.Name)
+		}
+		io.WriteString(w, `{"stream":"Step 1/1 : FROM ubuntu:latest\n"}`+"\n")
+		io.WriteString(w, `{"aux":{"ID":"sha256:abc"}}`+"\n")
+		io.WriteString(w, `{"stream":"Successfully built abc\n"}`+"\n")
+	})
+	client := newTestDockerAPIClient(t, mux)
+
+	// when
+	imageID, err := client.build(context.Background(), contextDir, "Dockerfile", "", []string{"registry.local/app:1.0.0"}, `{"org.opencontainers.image.version":"1.0.0"}`)
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, "sha256:abc", imageID)
+	assert.Equal(t, []string{"registry.local/app:1.0.0"}, receivedTags)
+	assert.Equal(t, `{"org.opencontainers.image.version":"1.0.0"}`, receivedLabels)
+	assert.ElementsMatch(t, []string{"Dockerfile", "files", "files/app.conf"}, receivedFiles)
+}
+
+func TestContextTarballRespectsDockerignore(t *testing.T) {
+	// given
+	contextDir := t.TempDir()
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(contextDir, "Dockerfile"), []byte("FROM ubuntu:latest\n"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(contextDir, dockerignoreFileName), []byte("Dockerfile\n.git\n*.key\nbuild\n!build/app\n"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(contextDir, "secret.key"), []byte("secret"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(contextDir, "app.conf"), []byte("conf"), 0644))
+	assert.NoError(t, os.MkdirAll(filepath.Join(contextDir, ".git", "objects"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(contextDir, ".git", "HEAD"), []byte("ref: refs/heads/main"), 0644))
+	assert.NoError(t, os.Mkdir(filepath.Join(contextDir, "build"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(contextDir, "build", "app"), []byte("app"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(contextDir, "build", "app.o"), []byte("obj"), 0644))
+	var tarball bytes.Buffer
+
+	// when
+	err := writeContextTarball(contextDir, "Dockerfile", &tarball)
+
+	// then
+	assert.NoError(t, err)
+	var files []string
+	tr := tar.NewReader(&tarball)
+	for header, err := tr.Next(); err != io.EOF; header, err = tr.Next() {
+		assert.NoError(t, err)
+		files = append(files, header.Name)
+	}
+	assert.ElementsMatch(t, []string{"Dockerfile", dockerignoreFileName, "app.conf", "build/app"}, files)
+}
+
+func TestDockerAPIBuildReturnsStreamedError(t *testing.T) {
+	// given
+	mux := http.NewServeMux()
+	mux.HandleFunc("/build", func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(ioutil.Discard, r.Body)
+		io.WriteString(w, `{"errorDetail":{"message":"unknown instruction: FORM"},"error":"unknown instruction: FORM"}`+"\n")
+	})
+	client := newTestDockerAPIClient(t, mux)
+
+	// when
+	_, err := client.build(context.Background(), t.TempDir(), "Dockerfile", "", []string{"app:1.0.0"}, "")
+
+	// then
+	assert.EqualError(t, err, "docker daemon error: unknown instruction: FORM")
+}
+
+func TestDockerAPITagAndPushReturnsDigest(t *testing.T) {
+	// given
+	var tagQuery, pushTag, pushAuth string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/images/sha256:abc/tag", func(w http.ResponseWriter, r *http.Request) {
+		tagQuery = r.URL.RawQuery
+		w.WriteHeader(http.StatusCreated)
+	})
+	mux.HandleFunc("/images/registry.local:5000/team/app/push", func(w http.ResponseWriter, r *http.Request) {
+		pushTag = r.URL.Query().Get("tag")
+		pushAuth = r.Header.Get("X-Registry-Auth")
+		io.WriteString(w, `{"status":"Pushed","id":"123"}`+"\n")
+		io.WriteString(w, `{"aux":{"Tag":"1.0.0","Digest":"sha256:def","Size":100}}`+"\n")
+	})
+	client := newTestDockerAPIClient(t, mux)
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+
+	// when
+	tagErr := client.tag(context.Background(), "sha256:abc", "registry.local:5000/team/app:1.0.0")
+	digest, pushErr := client.push(context.Background(), "registry.local:5000/team/app:1.0.0")
+
+	// then
+	assert.NoError(t, tagErr)
+	assert.NoError(t, pushErr)
+	assert.Equal(t, "repo=registry.local%3A5000%2Fteam%2Fapp&tag=1.0.0", tagQuery)
+	assert.Equal(t, "1.0.0", pushTag)
+	assert.Equal(t, emptyRegistryAuth, pushAuth)
+	assert.Equal(t, "sha256:def", digest)
+}
+
+func TestDockerAPIReportsErrorResponse(t *testing.T) {
+	// given
+	mux := http.NewServeMux()
+	mux.HandleFunc("/images/missing/tag", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, `{"message":"No such image: missing"}`)
+	})
+	client := newTestDockerAPIClient(t, mux)
+
+	// when
+	err := client.tag(context.Background(), "missing", "app:1.0.0")
+
+	// then
+	assert.EqualError(t, err, "docker daemon responded with 404: No such image: missing")
+}
+
+func TestSplitImageReference(t *testing.T) {
+	repository, tag := splitImageReference("registry.local:5000/app:1.2.3")
+	assert.Equal(t, "registry.local:5000/app", repository)
+	assert.Equal(t, "1.2.3", tag)
+
+	repository, tag = splitImageReference("registry.local:5000/app")
+	assert.Equal(t, "registry.local:5000/app", repository)
+	assert.Equal(t, "latest", tag)
+}
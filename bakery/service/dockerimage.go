@@ -43,3 +43,8 @@ func (di *DockerImage) GetNextVersion() semver.Version {
 func (di *DockerImage) GetNextVersionString() string {
 	return di.nextVersion.String()
 }
+
+// GetPlatforms returns platforms resolved for the currently processed image. Empty when no explicit platform is used.
+func (di *DockerImage) GetPlatforms() []string {
+	return di.platforms
+}
@@ -0,0 +1,327 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/Masterminds/semver"
+	"github.com/smartrecruiters/docker-bakery/bakery/commons"
+)
+
+// Options configure the Engine, zero values fall back to the defaults used by the CLI.
+type Options struct {
+	// ConfigFile is the path of the config file, ignored when Config is provided
+	ConfigFile string
+	// Config is used instead of reading the ConfigFile, its RootDir defaults to the working directory
+	Config *Config
+	// RootDir overrides the root dir of the config
+	RootDir string
+	// Profile is the name of the config profile to apply
+	Profile string
+	// Properties override the config properties, every entry is in the key=value format
+	Properties []string
+	// Filter narrows the hierarchy down to the selected images, nil selects all images
+	Filter *ImageFilter
+	// PinDigests pins the parent images to their locked digests in the filled Dockerfiles
+	PinDigests bool
+	// DisableCache makes the engine neither read nor update the on-disk cache of the parsed templates and the remote versions
+	DisableCache bool
+	// VersionStore keeps the released image versions, defaults to the git tags store
+	VersionStore VersionStore
+	// Builder is used for all images instead of the builders selected in the config
+	Builder ImageBuilder
+	// Logger receives debug messages, defaults to the logger enabled with the DEBUG env variable
+	Logger Logger
+	// Stdout receives the progress and the output of the commands, defaults to os.Stdout
+	Stdout io.Writer
+	// Stderr receives the error output of the commands, defaults to os.Stderr
+	Stderr io.Writer
+	// LogDir is the directory where the output of the build and push of every image is written to its own file,
+	// when empty the output goes to Stdout and Stderr
+	LogDir string
+	// LogFormat is text (default) or json, json format emits the processing events as JSON lines to Stdout
+	// while the progress messages go to Stderr
+	LogFormat string
+	// ReportFormats select formats of the report written after build or push: json (default), junit or markdown
+	ReportFormats []string
+}
+
+// Engine analyzes the images hierarchy and builds, pushes and templates its images. Every engine keeps its own state,
+// so multiple engines can be used concurrently in one process. Calls of the single engine must not overlap.
+type Engine struct {
+	options     Options
+	events      io.Writer
+	initialized bool
+
+	config          *Config
+	dependencies    map[string][]*DockerImage
+	commandErrors   []error
+	commandResults  []*CommandResult
+	dockerImgParser DockerImageParser
+	versions        map[string]*semver.Version
+	hierarchy       DockerHierarchy
+	startTime       time.Time
+	// lock holds the state of images read from the lock file and updated with the images pushed in the current run
+	lock *LockFile
+	// publishedPlatforms holds platforms successfully processed in the current run, key is the image name
+	publishedPlatforms map[string][]string
+	// builders holds already initialized builders, key is the builder name
+	builders map[string]ImageBuilder
+	// processing directs the output of the commands and reports the progress of the processed images
+	processing *processingLog
+	// cacheEnabled is switched off with the DisableCache option (--no-cache flag)
+	cacheEnabled    bool
+	versionStore    VersionStore
+	builderOverride ImageBuilder
+	logger          Logger
+	stdout          io.Writer
+	stderr          io.Writer
+	// commandStdout and commandStderr receive the output of the executed commands
+	commandStdout io.Writer
+	commandStderr io.Writer
+}
+
+// NewEngine creates the engine, InitConfiguration has to be called before any other method.
+func NewEngine(options Options) *Engine {
+	e := &Engine{
+		options:            options,
+		commandErrors:      make([]error, 0),
+		commandResults:     make([]*CommandResult, 0),
+		startTime:          time.Now(),
+		lock:               newLockFile(),
+		publishedPlatforms: make(map[string][]string),
+		builders:           make(map[string]ImageBuilder),
+		cacheEnabled:       !options.DisableCache,
+		versionStore:       options.VersionStore,
+		builderOverride:    options.Builder,
+		logger:             options.Logger,
+		stdout:             options.Stdout,
+		stderr:             options.Stderr,
+	}
+	if e.versionStore == nil {
+		e.versionStore = e.newGitVersionStore()
+	}
+	if e.logger == nil {
+		e.logger = &debugLogger{}
+	}
+	if e.stdout == nil {
+		e.stdout = os.Stdout
+	}
+	if e.stderr == nil {
+		e.stderr = os.Stderr
+	}
+	if options.LogFormat == logFormatJSON {
+		e.events = e.stdout
+		e.stdout = e.stderr
+	}
+	e.processing = &processingLog{engine: e}
+	e.commandStdout = commandOutput{engine: e, console: e.stdout}
+	e.commandStderr = commandOutput{engine: e, console: e.stderr}
+	e.dockerImgParser = newDockerImageParser(e.logger, e.stdout)
+	e.hierarchy = newDockerHierarchy(e.dockerImgParser, e.logger, e.stdout)
+	return e
+}
+
+// InitConfiguration parses config and gathers docker image dependencies/hierarchy. Applies the image filter when provided.
+// Context is used to cancel the commands obtaining the latest versions and the git properties.
+func (e *Engine) InitConfiguration(ctx context.Context) error {
+	var err error
+	if e.processing, err = e.newProcessingLog(e.options.LogDir, e.options.LogFormat); err != nil {
+		return err
+	}
+	err = e.initConfiguration(ctx, e.options.Config, e.options.ConfigFile, e.options.RootDir, e.options.Profile, e.options.Properties)
+	if err != nil {
+		return err
+	}
+	if e.options.PinDigests {
+		e.config.PinDigests = true
+	}
+	if err = e.setReportFormats(e.options.ReportFormats); err != nil {
+		return err
+	}
+	if f := e.options.Filter; f != nil {
+		if err = e.setImageFilter(f.Root, f.Include, f.Exclude); err != nil {
+			return err
+		}
+	}
+	e.initialized = true
+	return nil
+}
+
+// BuildDockerfile builds provided dockerfile (or the filter root image) and potentially its dependants.
+// Prints the build report at the end of processing. When the context is cancelled the running commands receive SIGTERM,
+// the images not processed yet are reported as cancelled and the cancellation error is returned.
+func (e *Engine) BuildDockerfile(ctx context.Context, dockerfile, scope string, shouldTriggerDependantBuilds bool) error {
+	return e.runInitialized(func() error {
+		return e.buildDockerfile(ctx, dockerfile, scope, shouldTriggerDependantBuilds)
+	})
+}
+
+// PushDockerImages pushes provided dockerfile (or the filter root image) and potentially its dependants.
+// Versions of the pushed images are recorded in the version store. Prints the push report at the end of processing.
+// Cancellation is handled as in BuildDockerfile, versions of the images pushed before the cancellation are still recorded.
+func (e *Engine) PushDockerImages(ctx context.Context, dockerfile, scope string, shouldTriggerDependantBuilds bool) error {
+	return e.runInitialized(func() error {
+		return e.pushDockerImages(ctx, dockerfile, scope, shouldTriggerDependantBuilds)
+	})
+}
+
+// DumpLatestVersions saves images with their latest version in json format to file.
+// Optionally it can exclude images from directories matching the pattern.
+func (e *Engine) DumpLatestVersions(fileName, excludeDirsPattern string) error {
+	return e.runInitialized(func() error {
+		return e.dumpLatestVersions(fileName, excludeDirsPattern)
+	})
+}
+
+// GenerateImagesTree generates copies of the dependants of the image with the names changed by the replacements.
+func (e *Engine) GenerateImagesTree(previousImageName string, recursive, skipExistingDirectories bool, nameReplacements []string) error {
+	return e.runInitialized(func() error {
+		return e.generateImagesTree(previousImageName, recursive, skipExistingDirectories, nameReplacements)
+	})
+}
+
+// FillTemplate fills the Dockerfile template and stores the result under the output file.
+func (e *Engine) FillTemplate(inputFile, outputFile string) error {
+	return e.runInitialized(func() error {
+		return e.fillTemplate(inputFile, outputFile)
+	})
+}
+
+// FillAllTemplates fills templates of all images or only checks whenever their Dockerfiles are up to date.
+func (e *Engine) FillAllTemplates(check bool) error {
+	return e.runInitialized(func() error {
+		return e.fillAllTemplates(check)
+	})
+}
+
+// CheckTemplate checks whenever the output file is up to date with the filled template.
+func (e *Engine) CheckTemplate(inputFile, outputFile string) error {
+	return e.runInitialized(func() error {
+		return e.checkTemplate(inputFile, outputFile)
+	})
+}
+
+// ShowStructure prints the images hierarchy in the requested format or stores it in the provided file.
+func (e *Engine) ShowStructure(format, fileName string) error {
+	return e.runInitialized(func() error {
+		return e.showStructure(format, fileName)
+	})
+}
+
+// PrintImageHierarchy prints the images hierarchy as a tree.
+func (e *Engine) PrintImageHierarchy() error {
+	return e.runInitialized(func() error {
+		e.printImageHierarchy()
+		return nil
+	})
+}
+
+// PrintConfig prints config layers along with the effective properties, optionally for the provided dockerfile.
+func (e *Engine) PrintConfig(dockerfile string) error {
+	return e.runInitialized(func() error {
+		return e.printConfig(dockerfile)
+	})
+}
+
+// AffectedImages returns names of the images affected by the changed files.
+func (e *Engine) AffectedImages(changedFiles []string) (affected []string, err error) {
+	err = e.runInitialized(func() error {
+		affected, err = e.affectedImages(changedFiles)
+		return err
+	})
+	return affected, err
+}
+
+// PrintAffectedImages prints names of the images affected by the changed files.
+func (e *Engine) PrintAffectedImages(changedFiles []string) error {
+	return e.runInitialized(func() error {
+		return e.printAffectedImages(changedFiles)
+	})
+}
+
+// Ancestors returns the parent chain of the image, starting from its parent up to the external root image.
+func (e *Engine) Ancestors(imageName string) (ancestors []*StructureImage, err error) {
+	err = e.runInitialized(func() error {
+		ancestors, err = e.findAncestors(imageName)
+		return err
+	})
+	return ancestors, err
+}
+
+// Descendants returns images rebuilt after the image is bumped, zero depth means no limit.
+func (e *Engine) Descendants(imageName string, depth int) (descendants []*Descendant, err error) {
+	err = e.runInitialized(func() error {
+		descendants, err = e.findDescendants(imageName, depth)
+		return err
+	})
+	return descendants, err
+}
+
+// Why returns the relation linking two images of the hierarchy.
+func (e *Engine) Why(from, to string) (relation *Relation, err error) {
+	err = e.runInitialized(func() error {
+		relation, err = e.findRelation(from, to)
+		return err
+	})
+	return relation, err
+}
+
+// PrintAncestors prints the parent chain of the image in the text or json format.
+func (e *Engine) PrintAncestors(imageName, format string) error {
+	return e.runInitialized(func() error {
+		return e.printAncestors(imageName, format)
+	})
+}
+
+// PrintDescendants prints images rebuilt after the image is bumped in the text or json format.
+func (e *Engine) PrintDescendants(imageName string, depth int, format string) error {
+	return e.runInitialized(func() error {
+		return e.printDescendants(imageName, depth, format)
+	})
+}
+
+// PrintWhy prints the relation linking two images in the text or json format.
+func (e *Engine) PrintWhy(from, to, format string) error {
+	return e.runInitialized(func() error {
+		return e.printWhy(from, to, format)
+	})
+}
+
+// Validate checks config, Dockerfile templates and the images hierarchy without accessing the version store.
+// Prints all problems found and returns an error when there is any. Does not require InitConfiguration.
+func (e *Engine) Validate() error {
+	return e.validate(e.options.ConfigFile, e.options.RootDir, e.options.Profile, e.options.Properties)
+}
+
+// ValidateStructure returns all problems found in the config, Dockerfile templates and the images hierarchy.
+func (e *Engine) ValidateStructure() []ValidationProblem {
+	return e.validateStructure(e.options.ConfigFile, e.options.RootDir, e.options.Profile, e.options.Properties)
+}
+
+// Results returns results of the images processed so far.
+func (e *Engine) Results() []*CommandResult {
+	return append([]*CommandResult{}, e.commandResults...)
+}
+
+// runInitialized runs the function, fails when the configuration is not initialized.
+func (e *Engine) runInitialized(fn func() error) error {
+	if !e.initialized {
+		return fmt.Errorf("engine configuration is not initialized, InitConfiguration has to be called first")
+	}
+	return fn()
+}
+
+// debugLogger is the default Logger printing the messages when the DEBUG env variable is set.
+type debugLogger struct{}
+
+func (l *debugLogger) Debugf(format string, args ...interface{}) {
+	commons.Debugf(format, args...)
+}
+
+func (e *Engine) debugf(format string, args ...interface{}) {
+	e.logger.Debugf(format, args...)
+}
@@ -0,0 +1,215 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Masterminds/semver"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeVersionStore keeps the versions in memory
+type fakeVersionStore struct {
+	versions map[string]*semver.Version
+	tagged   []string
+	pushes   int
+}
+
+func (s *fakeVersionStore) LatestVersions(ctx context.Context) (map[string]*semver.Version, error) {
+	return s.versions, nil
+}
+
+func (s *fakeVersionStore) TagVersion(ctx context.Context, imageName, version string) error {
+	s.tagged = append(s.tagged, imageName+"@"+version)
+	return nil
+}
+
+func (s *fakeVersionStore) PushTags(ctx context.Context) error {
+	s.pushes++
+	return nil
+}
+
+// fakeBuilder records processed images instead of executing any commands
+type fakeBuilder struct {
+	built  []string
+	pushed []string
+	// failures is the number of the build attempts failing before the build succeeds
+	failures int
+	// failOn is the name of the image whose build always fails
+	failOn string
+	// cancel is invoked while building the image named cancelOn, simulating the interruption
+	cancel   context.CancelFunc
+	cancelOn string
+}
+
+func (b *fakeBuilder) Build(ctx context.Context, dockerImage *DockerImage, result *CommandResult) error {
+	if dockerImage.Name == b.cancelOn {
+		b.cancel()
+		return ctx.Err()
+	}
+	if dockerImage.Name == b.failOn {
+		return fmt.Errorf("build of %s failed", dockerImage.Name)
+	}
+	if b.failures > 0 {
+		b.failures--
+		return fmt.Errorf("flaky build")
+	}
+	b.built = append(b.built, dockerImage.Name)
+	return nil
+}
+
+func (b *fakeBuilder) Push(ctx context.Context, dockerImage *DockerImage, result *CommandResult) error {
+	b.pushed = append(b.pushed, dockerImage.Name)
+	result.Digest = "sha256:" + dockerImage.Name
+	return nil
+}
+
+// givenTestEngine creates the engine using the config with the versions kept in memory and the on-disk cache disabled.
+// Hierarchy of the engine is empty until the test adds images to it or initializes the configuration.
+func givenTestEngine(cfg *Config) *Engine {
+	engine := NewEngine(Options{Config: cfg, DisableCache: true, VersionStore: &fakeVersionStore{}})
+	engine.config = cfg
+	return engine
+}
+
+func givenEngine(t *testing.T, templates map[string]string, versions map[string]*semver.Version) (*Engine, *fakeVersionStore, *fakeBuilder, *bytes.Buffer) {
+	dir := t.TempDir()
+	for templateDir, from := range templates {
+		writeTestFile(t, filepath.Join(dir, templateDir, dockerFileTemplateName), from)
+	}
+	store := &fakeVersionStore{versions: versions}
+	builder := &fakeBuilder{}
+	output := &bytes.Buffer{}
+	engine := NewEngine(Options{
+		Config:       &Config{RootDir: dir, Properties: map[string]string{"REGISTRY": "registry.local"}},
+		DisableCache: true,
+		VersionStore: store,
+		Builder:      builder,
+		Stdout:       output,
+		Stderr:       output,
+	})
+	return engine, store, builder, output
+}
+
+func TestEngineRequiresInitializedConfiguration(t *testing.T) {
+	engine, _, _, _ := givenEngine(t, nil, nil)
+
+	assert.EqualError(t, engine.BuildDockerfile(context.Background(), "", "patch", true), "engine configuration is not initialized, InitConfiguration has to be called first")
+}
+
+func TestEnginesKeepSeparateState(t *testing.T) {
+	first, _, firstBuilder, firstOutput := givenEngine(t, map[string]string{
+		"base":     "FROM ubuntu:20.04\n",
+		"base/app": "FROM base:{{.BASE_VERSION}}\n",
+	}, map[string]*semver.Version{"base": semver.MustParse("1.0.0")})
+	second, _, secondBuilder, secondOutput := givenEngine(t, map[string]string{
+		"tools": "FROM alpine:3\n",
+	}, map[string]*semver.Version{})
+
+	// engines run concurrently as they do not share any state
+	var wg sync.WaitGroup
+	for _, run := range []struct {
+		engine     *Engine
+		dockerfile string
+		scope      string
+	}{{first, "base", "minor"}, {second, "tools", "patch"}} {
+		wg.Add(1)
+		go func(engine *Engine, dockerfile, scope string) {
+			defer wg.Done()
+			assert.NoError(t, engine.InitConfiguration(context.Background()))
+			assert.NoError(t, engine.BuildDockerfile(context.Background(), filepath.Join(engine.options.Config.RootDir, dockerfile, dockerFileTemplateName), scope, true))
+		}(run.engine, run.dockerfile, run.scope)
+	}
+	wg.Wait()
+
+	assert.Equal(t, []string{"base", "app"}, firstBuilder.built)
+	assert.Equal(t, []string{"tools"}, secondBuilder.built)
+	assert.Len(t, first.Results(), 2)
+	assert.Equal(t, "1.1.0", first.Results()[0].NextVersion)
+	assert.Len(t, second.Results(), 1)
+	assert.Equal(t, "0.0.1", second.Results()[0].NextVersion)
+	assert.Contains(t, firstOutput.String(), "Processed 2 image(s)")
+	assert.NotContains(t, firstOutput.String(), "tools")
+	assert.Contains(t, secondOutput.String(), "Processed 1 image(s)")
+}
+
+func TestEnginePushRecordsVersionsInVersionStore(t *testing.T) {
+	engine, store, builder, _ := givenEngine(t, map[string]string{
+		"base":     "FROM ubuntu:20.04\n",
+		"base/app": "FROM base:{{.BASE_VERSION}}\n",
+	}, map[string]*semver.Version{"base": semver.MustParse("1.0.0"), "app": semver.MustParse("2.3.0")})
+
+	assert.NoError(t, engine.InitConfiguration(context.Background()))
+	assert.NoError(t, engine.PushDockerImages(context.Background(), filepath.Join(engine.options.Config.RootDir, "base", dockerFileTemplateName), "patch", true))
+
+	assert.Equal(t, []string{"base", "app"}, builder.pushed)
+	assert.Equal(t, []string{"base@1.0.1", "app@2.3.1"}, store.tagged)
+	assert.Equal(t, 1, store.pushes)
+	locked, err := ReadLockFile(filepath.Join(engine.options.Config.RootDir, defaultLockFileName))
+	assert.NoError(t, err)
+	assert.Equal(t, "sha256:app", locked.Images["app"].Digest)
+}
+
+func TestEngineReportsCancelledImages(t *testing.T) {
+	engine, _, builder, output := givenEngine(t, map[string]string{
+		"base":         "FROM ubuntu:20.04\n",
+		"base/app":     "FROM base:{{.BASE_VERSION}}\n",
+		"base/app/web": "FROM app:{{.APP_VERSION}}\n",
+		"base/worker":  "FROM base:{{.BASE_VERSION}}\n",
+	}, map[string]*semver.Version{"base": semver.MustParse("1.0.0")})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder.cancel = cancel
+	builder.cancelOn = "app"
+
+	assert.NoError(t, engine.InitConfiguration(context.Background()))
+	err := engine.BuildDockerfile(ctx, filepath.Join(engine.options.Config.RootDir, "base", dockerFileTemplateName), "patch", true)
+
+	assert.EqualError(t, err, "processing of the images was cancelled: context canceled")
+	assert.Equal(t, []string{"base"}, builder.built)
+	statuses := make(map[string]string)
+	for _, result := range engine.Results() {
+		statuses[result.Name] = result.Status
+	}
+	assert.Equal(t, map[string]string{"base": statusSuccess, "app": statusCancelled, "web": statusCancelled, "worker": statusCancelled}, statuses)
+	assert.Contains(t, output.String(), "Processed 4 image(s)")
+	assert.NotContains(t, output.String(), "Following")
+}
+
+func TestCommandIsTerminatedOnCancellation(t *testing.T) {
+	if _, err := exec.LookPath("sleep"); err != nil {
+		t.Skip("sleep command is not available")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd := givenTestEngine(&Config{}).newCommand(ctx, "sleep", "30")
+	assert.NoError(t, cmd.Start())
+
+	start := time.Now()
+	cancel()
+	err := cmd.Wait()
+
+	assert.Error(t, err)
+	assert.True(t, time.Since(start) < terminationGracePeriod, "command was not terminated with SIGTERM")
+	assert.False(t, cmd.killTimer.Stop(), "kill of the process tree was not stopped once the command exited")
+}
+
+func TestEngineRetriesFailedBuild(t *testing.T) {
+	engine, _, builder, output := givenEngine(t, map[string]string{
+		"base": "FROM ubuntu:20.04\n",
+	}, map[string]*semver.Version{})
+	engine.options.Config.Build = RetryPolicy{Retries: retries(2), Backoff: "1ms"}
+	builder.failures = 1
+
+	assert.NoError(t, engine.InitConfiguration(context.Background()))
+	assert.NoError(t, engine.BuildDockerfile(context.Background(), filepath.Join(engine.options.Config.RootDir, "base", dockerFileTemplateName), "patch", true))
+
+	assert.Equal(t, 2, engine.Results()[0].Attempts)
+	assert.Contains(t, output.String(), "build of base failed (attempt 1 of 3): flaky build, retrying in 1ms")
+	assert.Contains(t, output.String(), "base 0.0.0 => 0.0.1 (2 attempts)")
+}
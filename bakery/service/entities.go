@@ -1,24 +1,107 @@
 package service
 
 import (
+	"context"
+
 	"github.com/Masterminds/semver"
-	"github.com/smartrecruiters/gotree"
+	"github.com/smartrecruiters/docker-bakery/bakery/commons"
 )
 
-// Config corresponds to the config structure in json file
+// Config corresponds to the config structure in json, yaml or toml file
 type Config struct {
-	Properties        map[string]string `json:"properties"`
-	Commands          Commands          `json:"commands"`
-	RootDir           string            `json:"rootDir"`
-	Verbose           bool              `json:"verbose"`
-	AutoBuildExcludes []string          `json:"autoBuildExcludes"`
-	ReportFileName    string            `json:"reportFileName"`
+	Version           int                     `json:"version" description:"Version of the config format. Version 2 enables strict templating by default."`
+	Properties        map[string]string       `json:"properties" description:"Properties available in the Dockerfile templates and commands."`
+	Commands          Commands                `json:"commands" description:"Templates of the build and push commands."`
+	RootDir           string                  `json:"rootDir" description:"Root directory of the Dockerfiles, defaults to the directory of the config file."`
+	Verbose           bool                    `json:"verbose" description:"Prints the properties before every command."`
+	AutoBuildExcludes []string                `json:"autoBuildExcludes" description:"Names of the images that are not built automatically as dependants of their parents."`
+	ReportFileName    string                  `json:"reportFileName" description:"File where the report of processed images is stored in JSON format. JUnit XML and Markdown reports selected with --report-format are stored next to it with .xml and .md extension."`
+	Builder           string                  `json:"builder" description:"Builder used to build and push images: command, docker-api, docker-buildx, buildah, podman or kaniko."`
+	DockerHost        string                  `json:"dockerHost" description:"Docker daemon address used by the docker-api builder."`
+	Images            map[string]*ImageConfig `json:"images" description:"Settings applied to the single image, key is the image name."`
+	Platforms         []string                `json:"platforms" description:"Platforms the images are built for, for example linux/amd64."`
+	LockFileName      string                  `json:"lockFileName" description:"Name of the lock file, defaults to bakery.lock in the root directory."`
+	PinDigests        bool                    `json:"pinDigests" description:"Pins the parent image in the FROM clause to its locked digest."`
+	SkipUpToDate      bool                    `json:"skipUpToDate" description:"Skips images whose content did not change since the locked version."`
+	Extends           string                  `json:"extends" description:"Path of the base config this config extends. Values of this config take precedence."`
+	Include           []string                `json:"include" description:"Paths of the configs merged on top of this config, in order."`
+	StrictTemplates   *bool                   `json:"strictTemplates" description:"Makes templating fail when a template references undefined property instead of rendering <no value>."`
+	Profiles          map[string]*Profile     `json:"profiles" description:"Profiles overlaying properties and commands, selected with --profile flag or BAKERY_PROFILE env variable."`
+	PartialsDir       string                  `json:"partialsDir" description:"Directory with the template partials shared across the Dockerfile templates, relative to the rootDir."`
+	Labels            LabelsConfig            `json:"labels" description:"OCI annotation and bakery labels added automatically to the built images."`
+	IgnorePaths       []string                `json:"ignorePaths" description:"Paths skipped while discovering the images, in the .gitignore format relative to the rootDir. Added to the rules from the .bakeryignore file."`
+	CacheDir          string                  `json:"cacheDir" description:"Directory of the cache of the parsed templates and the remote versions, defaults to docker-bakery in the user cache dir."`
+	VersionsCacheTTL  string                  `json:"versionsCacheTTL" description:"For how long the latest versions obtained from the git remote tags are cached, for example 30s. Versions are not cached by default, enable it only for the read-only commands (stale versions make push create existing tags)."`
+	Build             RetryPolicy             `json:"build" description:"Timeout and retries of the image builds, can be overridden in the images section."`
+	Push              RetryPolicy             `json:"push" description:"Timeout and retries of the image pushes, can be overridden in the images section."`
+	Git               RetryPolicy             `json:"git" description:"Timeout and retries of the git commands obtaining, creating and pushing the version tags."`
+
+	// config files the config was loaded from in order of increasing precedence
+	configFiles []string
+	// property name => config file that defined its value
+	propertySources map[string]string
+	// name of the selected profile
+	profile string
+	// values resolved from files and sensitive environment variables that are masked when printed
+	secrets []string
+	// properties provided with -p flags
+	runtimeProperties map[string]string
+	// directory overrides found in the analyzed structure
+	directoryOverrides []*DirectoryOverride
+	// original values of the properties overridden for the currently processed directory (nil when property was not defined)
+	overriddenProperties map[string]*string
+	// original commands when commands were overridden for the currently processed directory
+	overriddenCommands *Commands
+	// template partials loaded from the partials dir, nil when partials dir is not configured
+	partials *commons.Partials
+	// filter narrowing the images shown in the structure, dumped and built as dependants, nil when all images are selected
+	imageFilter *ImageFilter
+	// formats of the report written after build or push, json when not selected
+	reportFormats []string
+}
+
+// ImageConfig holds settings that apply only to the single image, key in the config images section is the image name
+type ImageConfig struct {
+	Builder   string      `json:"builder" description:"Builder used for the image instead of the global one."`
+	Platforms []string    `json:"platforms" description:"Platforms the image is built for instead of the global ones."`
+	Build     RetryPolicy `json:"build" description:"Timeout and retries of the image build, settings defined here take precedence over the global ones."`
+	Push      RetryPolicy `json:"push" description:"Timeout and retries of the image push, settings defined here take precedence over the global ones."`
+}
+
+// RetryPolicy holds timeout and retries of the commands, empty values fall back to the defaults
+type RetryPolicy struct {
+	Timeout string `json:"timeout" description:"Maximum duration of the single attempt, for example 30m. Process tree of the command is killed when exceeded. No timeout by default."`
+	Retries *int   `json:"retries" description:"Number of retries of the failed attempt, 0 by default."`
+	Backoff string `json:"backoff" description:"Delay before the first retry, doubled before every next retry, for example 10s. Defaults to 1s."`
+}
+
+// LabelsConfig configures labels added automatically to the built images
+type LabelsConfig struct {
+	Mode   string `json:"mode" description:"How labels are added: args (--label arguments of the builders, appended to the build command of the command builder) or dockerfile (LABEL instruction appended to the rendered Dockerfile). Labels are not added when empty."`
+	Source string `json:"source" description:"Value of the org.opencontainers.image.source label, defaults to the URL of the git remote origin."`
 }
 
 // Commands is used as part of the config to contain template of build and push commands
 type Commands struct {
-	DefaultBuildCommand string `json:"defaultBuildCommand"`
-	DefaultPushCommand  string `json:"defaultPushCommand"`
+	DefaultBuildCommand string   `json:"defaultBuildCommand" description:"Template of the command used by the command builder to build images."`
+	DefaultPushCommand  string   `json:"defaultPushCommand" description:"Template of the command used by the command builder to push images."`
+	ImageTags           []string `json:"imageTags" description:"Templates of the tags used by the built-in builders."`
+}
+
+// Profile holds properties and commands overlaid on the config when the profile is selected
+type Profile struct {
+	Properties map[string]string `json:"properties" description:"Properties overridden when the profile is selected."`
+	Commands   Commands          `json:"commands" description:"Commands overridden when the profile is selected."`
+}
+
+// DirectoryOverride holds properties and commands overridden for the images located in the directory
+// of the override file and in its subdirectories
+type DirectoryOverride struct {
+	Properties map[string]string `json:"properties" description:"Properties overridden for the images in the directory."`
+	Commands   Commands          `json:"commands" description:"Commands overridden for the images in the directory."`
+	dir        string
+	fileName   string
+	secrets    []string
 }
 
 // DockerImage represents docker image with its parent
@@ -31,11 +114,12 @@ type DockerImage struct {
 	DependsOnVersion string
 	nextVersion      semver.Version
 	latestVersion    *semver.Version
+	platforms        []string
 }
 
 // PostCommandListener is an interface that allows to plugin just after docker command is executed and before any commands on children are executed
 type PostCommandListener interface {
-	OnPostCommand(result *CommandResult)
+	OnPostCommand(ctx context.Context, result *CommandResult)
 }
 
 // DockerImageParser provides functionality related to parsing docker files
@@ -45,25 +129,64 @@ type DockerImageParser interface {
 	ExtractImageName(string) (string, error)
 }
 
-// DockerTreeItem used in graphical representation of the image hierarchy
-type DockerTreeItem struct {
-	ID       string
-	ParentID string
-	TreeItem *gotree.GTStructure
-}
-
-// CommandResult is an outcome of the docker command
+// CommandResult is an outcome of the docker command. Reason explains why the image was skipped or excluded.
 type CommandResult struct {
 	Name           string
 	DockerfileDir  string
 	NextVersion    string
 	CurrentVersion string
+	ImageID        string            `json:",omitempty"`
+	Digest         string            `json:",omitempty"`
+	Platforms      []*PlatformResult `json:",omitempty"`
+	ContentHash    string            `json:",omitempty"`
+	Status         string
+	Profile        string   `json:",omitempty"`
+	Attempts       int      `json:",omitempty"`
+	Operation      string   `json:",omitempty"`
+	DurationMs     int64    `json:",omitempty"`
+	Commands       []string `json:",omitempty"`
+	Error          string   `json:",omitempty"`
+	Reason         string   `json:",omitempty"`
 }
 
+// LockFile corresponds to the structure of the lock file with the state of all images in the hierarchy
+type LockFile struct {
+	Images map[string]*LockedImage `json:"images"`
+}
+
+// LockedImage is the locked state of the single image, key in the lock file images section is the image name
+type LockedImage struct {
+	Version      string `json:"version"`
+	Digest       string `json:"digest,omitempty"`
+	Parent       string `json:"parent"`
+	ParentDigest string `json:"parentDigest,omitempty"`
+	TemplateHash string `json:"templateHash"`
+	ContentHash  string `json:"contentHash,omitempty"`
+}
+
+// PlatformResult is an outcome of the docker command for the single platform of the multi-platform image
+type PlatformResult struct {
+	Platform string
+	Status   string
+	Error    string `json:",omitempty"`
+}
+
+// ImageBuilder builds and pushes docker images whose Dockerfiles were already prepared from the templates.
+// Details about the outcome (like image id or digest) are recorded in the provided result.
+type ImageBuilder interface {
+	// Build builds the image and tags it with the image tags
+	Build(ctx context.Context, dockerImage *DockerImage, result *CommandResult) error
+	// Push pushes already built image tags to the registry
+	Push(ctx context.Context, dockerImage *DockerImage, result *CommandResult) error
+}
+
+// BuilderAction selects which ImageBuilder operation is executed on the processed images, e.g. ImageBuilder.Build
+type BuilderAction func(builder ImageBuilder, ctx context.Context, dockerImage *DockerImage, result *CommandResult) error
+
 // DockerHierarchy represents hierarchy of docker images
 type DockerHierarchy interface {
 	// Analyzes docker files structure under given directory and constructs entire hierarchy
-	AnalyzeStructure(string, map[string]*semver.Version) error
+	AnalyzeStructure(string, map[string]*semver.Version, *commons.IgnorePatterns) error
 	// Adds docker image to the hierarchy based on the docker image parent
 	AddImage(dockerImg *DockerImage)
 	// GetImageByName returns docker image by its name. Image can be obtained after entire hierarchy has been analyzed
@@ -74,6 +197,21 @@ type DockerHierarchy interface {
 	// Returns map with docker images where key is the short docker image name and
 	// the value is docker image object
 	GetImages() map[string]*DockerImage
-	// Prints gathered hierarchy under a given root name
-	PrintImageHierarchy(string)
+	// Returns directory overrides found while analyzing the structure
+	GetDirectoryOverrides() []*DirectoryOverride
+}
+
+// VersionStore keeps track of the released image versions, by default they are stored as git tags (image@version)
+type VersionStore interface {
+	// LatestVersions returns map with the latest released version of every image, key is the image name
+	LatestVersions(ctx context.Context) (map[string]*semver.Version, error)
+	// TagVersion records new version of the image
+	TagVersion(ctx context.Context, imageName, version string) error
+	// PushTags publishes versions recorded since the last push
+	PushTags(ctx context.Context) error
+}
+
+// Logger receives debug messages, by default they are printed when the DEBUG env variable is set
+type Logger interface {
+	Debugf(format string, args ...interface{})
 }
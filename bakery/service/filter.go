@@ -0,0 +1,115 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/smartrecruiters/docker-bakery/bakery/commons"
+)
+
+// ImageFilter narrows the hierarchy down to the subtree of the root image and to the images whose names or directories
+// (relative to the root dir) match the include glob patterns and do not match the exclude ones.
+type ImageFilter struct {
+	Root    string
+	Include []string
+	Exclude []string
+	// selected holds names of the images passing the filter, determined once the hierarchy is analyzed
+	selected map[string]bool
+}
+
+// setImageFilter validates the filter and applies it to show-structure, dump-latest-versions and dependant builds.
+// Has to be called after the configuration is initialized, empty filter selects all images.
+func (e *Engine) setImageFilter(root string, include, exclude []string) error {
+	if root == "" && len(include) == 0 && len(exclude) == 0 {
+		e.config.imageFilter = nil
+		return nil
+	}
+	for _, pattern := range append(append([]string{}, include...), exclude...) {
+		if _, err := commons.GlobToRegexp(pattern); err != nil {
+			return fmt.Errorf("invalid image filter pattern %s: %s", pattern, err)
+		}
+	}
+
+	f := &ImageFilter{Root: root, Include: include, Exclude: exclude}
+	s := e.getStructure()
+	candidates := s.Images
+	if root != "" {
+		images, err := e.structureImagesWith(root)
+		if err != nil {
+			return err
+		}
+		candidates = append([]*StructureImage{images[root]}, subtree(s.children(), root)...)
+	}
+	f.selected = make(map[string]bool)
+	for _, image := range candidates {
+		if f.matches(image) {
+			f.selected[image.Name] = true
+		}
+	}
+	e.config.imageFilter = f
+	return nil
+}
+
+// subtree returns all descendants of the image.
+func subtree(children map[string][]*StructureImage, imageName string) []*StructureImage {
+	descendants := make([]*StructureImage, 0)
+	visited := map[string]bool{imageName: true}
+	queue := []string{imageName}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		for _, child := range children[name] {
+			if !visited[child.Name] {
+				visited[child.Name] = true
+				descendants = append(descendants, child)
+				queue = append(queue, child.Name)
+			}
+		}
+	}
+	return descendants
+}
+
+func (f *ImageFilter) matches(image *StructureImage) bool {
+	if len(f.Include) > 0 && !matchesAnyPattern(f.Include, image) {
+		return false
+	}
+	return !matchesAnyPattern(f.Exclude, image)
+}
+
+func matchesAnyPattern(patterns []string, image *StructureImage) bool {
+	for _, pattern := range patterns {
+		if commons.MatchGlob(pattern, image.Name) || (image.Dir != "" && commons.MatchGlob(pattern, image.Dir)) {
+			return true
+		}
+	}
+	return false
+}
+
+// selects returns true if the image passes the filter, nil filter selects all images.
+func (f *ImageFilter) selects(imageName string) bool {
+	return f == nil || f.selected[imageName]
+}
+
+// apply returns structure with the images passing the filter.
+func (f *ImageFilter) apply(s *Structure) *Structure {
+	if f == nil {
+		return s
+	}
+	filtered := &Structure{RootDir: s.RootDir, Images: make([]*StructureImage, 0)}
+	for _, image := range s.Images {
+		if f.selects(image.Name) {
+			filtered.Images = append(filtered.Images, image)
+		}
+	}
+	return filtered
+}
+
+// rootDockerfile returns template of the filter root image, empty when there is no root or it is an external image.
+func (f *ImageFilter) rootDockerfile(h DockerHierarchy) string {
+	if f == nil || f.Root == "" {
+		return ""
+	}
+	if dockerImage := h.GetImageByName(f.Root); dockerImage != nil {
+		return dockerImage.DockerfilePath
+	}
+	return ""
+}
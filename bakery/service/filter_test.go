@@ -0,0 +1,83 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/Masterminds/semver"
+	"github.com/stretchr/testify/assert"
+)
+
+func selectedNames(t *testing.T, engine *Engine, root string, include, exclude []string) []string {
+	assert.NoError(t, engine.setImageFilter(root, include, exclude))
+	return names(engine.config.imageFilter.apply(engine.getStructure()).Images)
+}
+
+func TestSetImageFilter(t *testing.T) {
+	engine := givenQueriedHierarchy(t)
+
+	assert.Equal(t, []string{"app", "base", "cron", "web", "worker"}, selectedNames(t, engine, "base", nil, nil))
+	assert.Equal(t, []string{"app", "web"}, selectedNames(t, engine, "app", nil, nil))
+	assert.Equal(t, []string{"cron", "worker"}, selectedNames(t, engine, "base", []string{"w*r", "c*"}, []string{"web"}))
+	assert.Equal(t, []string{"alpine", "tools", "ubuntu"}, selectedNames(t, engine, "", nil, []string{"base", "app", "w*", "cron"}))
+
+	assert.NoError(t, engine.setImageFilter("", nil, nil))
+	assert.Nil(t, engine.config.imageFilter)
+	assert.True(t, engine.config.imageFilter.selects("web"))
+}
+
+func TestImageFilterMatchesDirectories(t *testing.T) {
+	engine, _ := givenStructure(t)
+
+	assert.Equal(t, []string{"app"}, selectedNames(t, engine, "", []string{"base/**"}, nil))
+	assert.Equal(t, []string{"alpine", "tools", "ubuntu"}, selectedNames(t, engine, "", nil, []string{"base", "**/app"}))
+}
+
+func TestSetInvalidImageFilter(t *testing.T) {
+	engine := givenQueriedHierarchy(t)
+
+	assert.EqualError(t, engine.setImageFilter("missing", nil, nil), "image missing does not exist in the hierarchy")
+	assert.Error(t, engine.setImageFilter("", []string{"[z-a]"}, nil))
+}
+
+func TestFilteredStructureTree(t *testing.T) {
+	engine := givenQueriedHierarchy(t)
+	assert.NoError(t, engine.setImageFilter("base", nil, []string{"app"}))
+
+	s := engine.config.imageFilter.apply(engine.getStructure())
+	s.RootDir = "images"
+	content, err := renderStructure(s, structureFormatTree)
+
+	assert.NoError(t, err)
+	// dependants of the filtered out image become the roots
+	assert.Equal(t, `Dockerfiles hierarchy discovered in images
+├── base [base]
+│   └── worker [worker] [excluded]
+│       └── cron [cron]
+└── web [web]
+`, content)
+	dot, err := renderStructure(s, structureFormatDot)
+	assert.NoError(t, err)
+	assert.NotContains(t, dot, `"ubuntu" -> "base"`)
+	assert.NotContains(t, dot, `"app" -> "web"`)
+}
+
+func TestFilterOutImagesFromDirs(t *testing.T) {
+	engine := givenQueriedHierarchy(t)
+	engine.versions = map[string]*semver.Version{"tools": semver.MustParse("1.0.0"), "base": semver.MustParse("2.0.0")}
+
+	assert.EqualError(t, engine.filterOutImagesFromDirs("[a-"), "invalid exclude dirs pattern [a-: error parsing regexp: missing closing ]: `[a-`")
+	assert.NoError(t, engine.filterOutImagesFromDirs("tools$"))
+	assert.Equal(t, []string{"base"}, keys(engine.versions))
+
+	assert.NoError(t, engine.setImageFilter("", nil, []string{"base"}))
+	engine.filterOutNotSelectedImages()
+	assert.Empty(t, engine.versions)
+}
+
+func keys(versions map[string]*semver.Version) []string {
+	result := make([]string, 0, len(versions))
+	for name := range versions {
+		result = append(result, name)
+	}
+	return result
+}
@@ -3,38 +3,72 @@ package service
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
-	"os"
-	"os/exec"
+	"net/url"
 	"strings"
 
 	"time"
 
 	"github.com/Masterminds/semver"
-	"github.com/smartrecruiters/docker-bakery/bakery/commons"
 )
 
 // Implementation of the PostCommandListener.
-type postPushListener struct{}
+type postPushListener struct {
+	engine *Engine
+}
 
-// OnPostCommand executes image tagging as the PostCommand action.
-func (pcl *postPushListener) OnPostCommand(result *CommandResult) {
-	TagVersion(result.Name, result.NextVersion)
+// OnPostCommand executes image tagging as the PostCommand action and records pushed image in the lock.
+// Pushed image is tagged even when processing is cancelled, so the tags match the pushed images.
+func (pcl *postPushListener) OnPostCommand(ctx context.Context, result *CommandResult) {
+	pcl.engine.versionStore.TagVersion(context.WithoutCancel(ctx), result.Name, result.NextVersion)
+	if dockerImage := pcl.engine.hierarchy.GetImageByName(result.Name); dockerImage != nil {
+		pcl.engine.updateLockedImage(dockerImage, result)
+	}
 }
 
-// NewPostPushListener initializes new PostPushListener.
-func NewPostPushListener() PostCommandListener {
-	return &postPushListener{}
+// newPostPushListener initializes PostPushListener tagging the images in the engine version store.
+func (e *Engine) newPostPushListener() PostCommandListener {
+	return &postPushListener{engine: e}
 }
 
-// GetLatestVersions returns map with latest versions of the images based on git remote tags.
-// Image name is the key and latest version is the value.
-func GetLatestVersions() (map[string]*semver.Version, error) {
+// gitVersionStore is the VersionStore keeping the image versions as git tags in the repository of the root dir.
+type gitVersionStore struct {
+	engine *Engine
+}
+
+// newGitVersionStore initializes VersionStore keeping the image versions as image@version git tags pushed to the origin remote.
+func (e *Engine) newGitVersionStore() VersionStore {
+	return &gitVersionStore{engine: e}
+}
+
+// LatestVersions returns map with latest versions of the images based on git remote tags.
+// Image name is the key and latest version is the value. Versions are cached when versionsCacheTTL is configured.
+// Listing of the remote tags is retried according to the git retry policy.
+func (s *gitVersionStore) LatestVersions(ctx context.Context) (map[string]*semver.Version, error) {
+	if versions := s.engine.readCachedVersions(); versions != nil {
+		fmt.Fprintln(s.engine.stdout, "Using image latest versions cached from git remote tags")
+		return versions, nil
+	}
+	var versions map[string]*semver.Version
+	_, err := s.engine.withRetries(ctx, s.engine.config.gitRetrySettings(), "git ls-remote", func(ctx context.Context) (err error) {
+		versions, err = s.engine.listRemoteVersions(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.engine.writeCachedVersions(versions)
+	return versions, nil
+}
+
+// listRemoteVersions returns map with latest versions of the images based on git remote tags.
+func (e *Engine) listRemoteVersions(ctx context.Context) (map[string]*semver.Version, error) {
 	// we could use faster local tags to check the versions but checking the remote ones is safer in terms of version conflicts
 	start := time.Now()
-	listRemoteTagsCmd := exec.Command("git", "ls-remote", "--tags", "origin")
-	listRemoteTagsCmd.Dir = config.RootDir
-	fmt.Println("Obtaining image latest versions from git remote tags")
+	listRemoteTagsCmd := e.newCommand(ctx, "git", "ls-remote", "--tags", "origin")
+	listRemoteTagsCmd.Dir = e.config.RootDir
+	fmt.Fprintln(e.stdout, "Obtaining image latest versions from git remote tags")
 	out, err := listRemoteTagsCmd.Output()
 	if err != nil {
 		return nil, err
@@ -48,7 +82,7 @@ func GetLatestVersions() (map[string]*semver.Version, error) {
 		tag := lineParts[len(lineParts)-1]
 		tagParts := strings.Split(tag, "@")
 		if len(tagParts) != 2 {
-			fmt.Printf("Skipping version extraction for tag: %s\n", tag)
+			fmt.Fprintf(e.stdout, "Skipping version extraction for tag: %s\n", tag)
 			continue
 		}
 
@@ -68,49 +102,99 @@ func GetLatestVersions() (map[string]*semver.Version, error) {
 			}
 		}
 	}
-	commons.Debugf("Checking remote tags took: %v", time.Since(start))
+	e.debugf("Checking remote tags took: %v", time.Since(start))
 
 	return versions, nil
 }
 
-// PushTags pushes git tags to the remote.
-func PushTags() error {
-	pushTagsCmd := exec.Command("git", "push", "--tags")
-	pushTagsCmd.Dir = config.RootDir
-	pushTagsCmd.Stdin = os.Stdin
-	pushTagsCmd.Stdout = os.Stdout
-	pushTagsCmd.Stderr = os.Stderr
-	fmt.Printf("Executing: %s\n", pushTagsCmd.Args)
-	return pushTagsCmd.Run()
-}
-
-// TagVersion creates new tag for the image with the given version.
-func TagVersion(imageName, version string) error {
-	tagCmd := exec.Command("git", "tag", fmt.Sprintf("%s@%s", imageName, version))
-	tagCmd.Dir = config.RootDir
-	tagCmd.Stdin = os.Stdin
-	tagCmd.Stdout = os.Stdout
-	tagCmd.Stderr = os.Stderr
-	fmt.Printf("Executing: %s\n", tagCmd.Args)
-	return tagCmd.Run()
-}
-
-// GetGitUserName returns git user name obtained from configuration or error if it could not be obtained
-func GetGitUserName() (string, error) {
-	getGitUserNameCmd := exec.Command("git", "config", "user.name")
-	getGitUserNameCmd.Dir = config.RootDir
+// PushTags pushes git tags to the remote, cached remote versions are invalidated.
+func (s *gitVersionStore) PushTags(ctx context.Context) error {
+	defer s.engine.invalidateCachedVersions()
+	return s.engine.runGitCommand(ctx, "push", "--tags")
+}
+
+// TagVersion creates new tag for the image with the given version. Tag is not created again by the retries
+// when it already points at the checked out commit, e.g. when the timed out attempt managed to create it.
+func (s *gitVersionStore) TagVersion(ctx context.Context, imageName, version string) error {
+	tag := fmt.Sprintf("%s@%s", imageName, version)
+	attempt := 0
+	_, err := s.engine.withRetries(ctx, s.engine.config.gitRetrySettings(), "git tag", func(ctx context.Context) error {
+		attempt++
+		if attempt > 1 && s.engine.tagPointsAtHead(ctx, tag) {
+			fmt.Fprintf(s.engine.stdout, "Tag %s already points at the checked out commit\n", tag)
+			return nil
+		}
+		return s.engine.executeGitCommand(ctx, "tag", tag)
+	})
+	return err
+}
+
+// runGitCommand executes git command in the root dir, retried according to the git retry policy.
+func (e *Engine) runGitCommand(ctx context.Context, args ...string) error {
+	_, err := e.withRetries(ctx, e.config.gitRetrySettings(), "git "+args[0], func(ctx context.Context) error {
+		return e.executeGitCommand(ctx, args...)
+	})
+	return err
+}
+
+// executeGitCommand executes git command in the root dir and prints its output.
+func (e *Engine) executeGitCommand(ctx context.Context, args ...string) error {
+	gitCmd := e.newCommand(ctx, "git", args...)
+	gitCmd.Dir = e.config.RootDir
+	attachStdin(gitCmd)
+	return e.runLoggedCommand(gitCmd)
+}
+
+// tagPointsAtHead checks whenever the tag exists and points at the checked out commit.
+func (e *Engine) tagPointsAtHead(ctx context.Context, tag string) bool {
+	tagRevisionCmd := e.newCommand(ctx, "git", "rev-parse", "--verify", "--quiet", "refs/tags/"+tag+"^{commit}")
+	tagRevisionCmd.Dir = e.config.RootDir
+	tagRevision, err := extractCommandOutput(tagRevisionCmd)
+	if err != nil {
+		return false
+	}
+	headRevision, err := e.getGitRevision(ctx)
+	return err == nil && tagRevision == headRevision
+}
+
+// getGitUserName returns git user name obtained from configuration or error if it could not be obtained
+func (e *Engine) getGitUserName(ctx context.Context) (string, error) {
+	getGitUserNameCmd := e.newCommand(ctx, "git", "config", "user.name")
+	getGitUserNameCmd.Dir = e.config.RootDir
 	return extractCommandOutput(getGitUserNameCmd)
 }
 
-// GetGitUserEmail returns git user email obtained from configuration or error if it could not be obtained
-func GetGitUserEmail() (string, error) {
-	getGitUserEmailCmd := exec.Command("git", "config", "user.email")
-	getGitUserEmailCmd.Dir = config.RootDir
+// getGitUserEmail returns git user email obtained from configuration or error if it could not be obtained
+func (e *Engine) getGitUserEmail(ctx context.Context) (string, error) {
+	getGitUserEmailCmd := e.newCommand(ctx, "git", "config", "user.email")
+	getGitUserEmailCmd.Dir = e.config.RootDir
 	return extractCommandOutput(getGitUserEmailCmd)
 }
 
-func extractCommandOutput(command *exec.Cmd) (string, error) {
-	out, err := command.Output()
+// getGitRevision returns SHA of the currently checked out git commit
+func (e *Engine) getGitRevision(ctx context.Context) (string, error) {
+	getGitRevisionCmd := e.newCommand(ctx, "git", "rev-parse", "HEAD")
+	getGitRevisionCmd.Dir = e.config.RootDir
+	return extractCommandOutput(getGitRevisionCmd)
+}
+
+// getGitRemoteURL returns URL of the git remote origin without the credentials
+func (e *Engine) getGitRemoteURL(ctx context.Context) (string, error) {
+	getGitRemoteURLCmd := e.newCommand(ctx, "git", "remote", "get-url", "origin")
+	getGitRemoteURLCmd.Dir = e.config.RootDir
+	remoteURL, err := extractCommandOutput(getGitRemoteURLCmd)
+	if err != nil {
+		return "", err
+	}
+	if parsed, err := url.Parse(remoteURL); err == nil && parsed.User != nil {
+		parsed.User = nil
+		return parsed.String(), nil
+	}
+	return remoteURL, nil
+}
+
+func extractCommandOutput(cmd *command) (string, error) {
+	out, err := cmd.Output()
 	if err != nil {
 		return "", err
 	}
@@ -120,5 +204,5 @@ func extractCommandOutput(command *exec.Cmd) (string, error) {
 		return scanner.Text(), nil
 	}
 
-	return "", fmt.Errorf("unable to extract output from %v", command.Args)
+	return "", fmt.Errorf("unable to extract output from %v", cmd.Args)
 }
@@ -0,0 +1,56 @@
+package service
+
+import (
+	"context"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func givenGitRepository(t *testing.T) *Engine {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available")
+	}
+	dir := t.TempDir()
+	for _, args := range [][]string{
+		{"init", "--quiet"},
+		{"-c", "user.name=bakery", "-c", "user.email=bakery@localhost", "commit", "--quiet", "--allow-empty", "-m", "initial"},
+	} {
+		gitCmd := exec.Command("git", args...)
+		gitCmd.Dir = dir
+		assert.NoError(t, gitCmd.Run())
+	}
+	return givenTestEngine(&Config{RootDir: dir, Git: RetryPolicy{Retries: retries(1), Backoff: "1ms"}})
+}
+
+func TestTagVersionRetrySucceedsWhenTagPointsAtHead(t *testing.T) {
+	// given
+	engine := givenGitRepository(t)
+	store := engine.newGitVersionStore()
+	assert.NoError(t, store.TagVersion(context.Background(), "app", "1.0.0"))
+
+	// when
+	err := store.TagVersion(context.Background(), "app", "1.0.0")
+
+	// then
+	assert.NoError(t, err)
+	assert.True(t, engine.tagPointsAtHead(context.Background(), "app@1.0.0"))
+}
+
+func TestTagVersionFailsWhenTagPointsAtOtherCommit(t *testing.T) {
+	// given
+	engine := givenGitRepository(t)
+	store := engine.newGitVersionStore()
+	assert.NoError(t, store.TagVersion(context.Background(), "app", "1.0.0"))
+	commitCmd := exec.Command("git", "-c", "user.name=bakery", "-c", "user.email=bakery@localhost", "commit", "--quiet", "--allow-empty", "-m", "next")
+	commitCmd.Dir = engine.config.RootDir
+	assert.NoError(t, commitCmd.Run())
+
+	// when
+	err := store.TagVersion(context.Background(), "app", "1.0.0")
+
+	// then
+	assert.Error(t, err)
+	assert.False(t, engine.tagPointsAtHead(context.Background(), "app@1.0.0"))
+}
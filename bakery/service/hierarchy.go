@@ -2,38 +2,48 @@ package service
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sync"
 
 	"github.com/Masterminds/semver"
 	"github.com/smartrecruiters/docker-bakery/bakery/commons"
-	"github.com/smartrecruiters/gotree"
 )
 
-const dockerFileTemplateName = "Dockerfile.template"
+const (
+	dockerFileTemplateName = "Dockerfile.template"
+	dockerfileName         = "Dockerfile"
+)
 
 // NewDockerHierarchy initializes new docker hierarchy.
 func NewDockerHierarchy() DockerHierarchy {
+	return newDockerHierarchy(NewDockerImageParser(), &debugLogger{}, os.Stdout)
+}
+
+// newDockerHierarchy initializes docker hierarchy parsing the templates with the provided parser.
+func newDockerHierarchy(parser DockerImageParser, logger Logger, output io.Writer) DockerHierarchy {
 	return &dockerHierarchy{
-		images:                        make(map[string]*DockerImage),
-		imagesWithDependantsMap:       make(map[string][]*DockerImage),
-		imagesTree:                    make(map[string]*DockerTreeItem),
-		imagesTreeSlice:               make([]*DockerTreeItem, 0),
-		imagesTreePlusExternalParents: make([]*DockerTreeItem, 0)}
+		parser:                  parser,
+		logger:                  logger,
+		output:                  output,
+		images:                  make(map[string]*DockerImage),
+		imagesWithDependantsMap: make(map[string][]*DockerImage)}
 }
 
 // Implementation of the DockerHierarchy interface
 type dockerHierarchy struct {
 	// map where image name is a key, value is the slice of dependant images
 	imagesWithDependantsMap map[string][]*DockerImage
-	// map with all analyzed images where key is the image name, value is the corresponding DockerTreeItem object
-	imagesTree map[string]*DockerTreeItem
 	// map with all analyzed images where key is the image name, value is the corresponding DockerImage object
 	images map[string]*DockerImage
-	// slice with analyzed DockerTreeItem objects
-	imagesTreeSlice []*DockerTreeItem
-	// slice with analyzed DockerTreeItem objects + the external parents that were not directly analyzed but should be present in the tree structure
-	imagesTreePlusExternalParents []*DockerTreeItem
+	// slice with directory overrides found during analysis
+	directoryOverrides []*DirectoryOverride
+	// parser of the found templates
+	parser DockerImageParser
+	logger Logger
+	output io.Writer
 }
 
 func (h *dockerHierarchy) GetImageByName(imageName string) *DockerImage {
@@ -41,35 +51,91 @@ func (h *dockerHierarchy) GetImageByName(imageName string) *DockerImage {
 }
 
 // Analyzes the structure of the directory and effectively builds the entire hierarchy.
-// Searches for the presence of `Dockerfile.template` files.
+// Searches for the presence of `Dockerfile.template` files, ignored directories are not walked into.
+// Templates are parsed concurrently with the hierarchy image parser (which may be backed by the on-disk cache).
 // Uses provided map with latest versions to show it in the hierarchy.
-func (h *dockerHierarchy) AnalyzeStructure(rootDir string, latestVersions map[string]*semver.Version) error {
-	dockerImgParser := NewDockerImageParser()
-
+func (h *dockerHierarchy) AnalyzeStructure(rootDir string, latestVersions map[string]*semver.Version, ignored *commons.IgnorePatterns) error {
+	templates := make([]string, 0)
 	extractDockerImagesFn := func(sourcePath string, sourceInfo os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if isIgnoredPath(ignored, rootDir, sourcePath, sourceInfo.IsDir()) {
+			h.logger.Debugf("Ignoring %s", sourcePath)
+			if sourceInfo.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
 		name := sourceInfo.Name()
 		// we can skip analysis of pure dockerfile because if it does not have a template we will not
 		// be able to propagate dependency updates
-		if !sourceInfo.IsDir() && name == dockerFileTemplateName {
-			dockerImg, err := dockerImgParser.ParseDockerfile(sourcePath)
+		if !sourceInfo.IsDir() && isDirectoryOverrideFile(name) {
+			override, err := readDirectoryOverride(sourcePath)
 			if err != nil {
 				return err
 			}
-			if dockerImg != nil {
-				dockerImg.latestVersion = latestVersions[dockerImg.Name]
-				commons.Debugf("Adding image to hierarchy: %+v", dockerImg)
-				h.AddImage(dockerImg)
-			}
+			h.logger.Debugf("Adding directory override: %s", sourcePath)
+			h.directoryOverrides = append(h.directoryOverrides, override)
+		}
+		if !sourceInfo.IsDir() && name == dockerFileTemplateName {
+			templates = append(templates, sourcePath)
 		}
 
 		return nil
 	}
 
-	fmt.Println("Analyzing Dockerfile.template files")
-	return filepath.Walk(rootDir, extractDockerImagesFn)
+	fmt.Fprintln(h.output, "Analyzing Dockerfile.template files")
+	if err := filepath.Walk(rootDir, extractDockerImagesFn); err != nil {
+		return err
+	}
+
+	dockerImages, err := parseDockerfiles(h.parser, templates)
+	if err != nil {
+		return err
+	}
+	// images are added in the walk order, so the order of the dependant builds does not depend on the parsing order
+	for _, dockerImg := range dockerImages {
+		if dockerImg != nil {
+			dockerImg.latestVersion = latestVersions[dockerImg.Name]
+			h.logger.Debugf("Adding image to hierarchy: %+v", dockerImg)
+			h.AddImage(dockerImg)
+		}
+	}
+	return nil
+}
+
+// parseDockerfiles parses the templates with the pool of workers and returns images in the order of the templates.
+// Returns the error of the first template (in order) that could not be parsed.
+func parseDockerfiles(parser DockerImageParser, templates []string) ([]*DockerImage, error) {
+	dockerImages := make([]*DockerImage, len(templates))
+	errs := make([]error, len(templates))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				dockerImages[i], errs[i] = parser.ParseDockerfile(templates[i])
+			}
+		}()
+	}
+	for i := range templates {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return dockerImages, nil
 }
 
-// Adds image to the hierarchy, uses latest version information to include it in the hierarchy view.
+// Adds image to the hierarchy.
 // Used during analyzing process.
 // Updates internal hierarchy structures.
 func (h *dockerHierarchy) AddImage(dockerImg *DockerImage) {
@@ -77,14 +143,7 @@ func (h *dockerHierarchy) AddImage(dockerImg *DockerImage) {
 		h.imagesWithDependantsMap[dockerImg.DependsOnShort] = make([]*DockerImage, 0)
 	}
 	h.imagesWithDependantsMap[dockerImg.DependsOnShort] = append(h.imagesWithDependantsMap[dockerImg.DependsOnShort], dockerImg)
-
-	item := h.buildDockerTreeItem(dockerImg)
-	commons.Debugf("Processing %+v", item)
-
-	h.imagesTreeSlice = append(h.imagesTreeSlice, item)
-	h.imagesTree[dockerImg.Name] = item
 	h.images[dockerImg.Name] = dockerImg
-	h.imagesTreePlusExternalParents = append(h.imagesTreePlusExternalParents, item)
 }
 
 // Return the map of docker images where key is the image name and value is the slice of its dependant images.
@@ -92,73 +151,12 @@ func (h *dockerHierarchy) GetImagesWithDependants() map[string][]*DockerImage {
 	return h.imagesWithDependantsMap
 }
 
+// Return the directory overrides found during analysis.
+func (h *dockerHierarchy) GetDirectoryOverrides() []*DirectoryOverride {
+	return h.directoryOverrides
+}
+
 // Return the map of docker images where key is the image name and value is the docker image object.
 func (h *dockerHierarchy) GetImages() map[string]*DockerImage {
 	return h.images
 }
-
-// Creates the first level of the hierarchy tree. External images are qualified as first level parents.
-func (h *dockerHierarchy) createFirstLevelRoots() {
-	for _, i := range h.imagesTreeSlice {
-		if h.isExternalParent(i.ParentID) {
-			if _, exists := h.imagesTree[i.ParentID]; !exists {
-				h.imagesTree[i.ParentID] = &DockerTreeItem{
-					ID:       i.ParentID,
-					ParentID: "",
-					TreeItem: &gotree.GTStructure{
-						Name:  i.ParentID,
-						Items: make([]*gotree.GTStructure, 0)}}
-
-				h.imagesTreePlusExternalParents = append(h.imagesTreePlusExternalParents, h.imagesTree[i.ParentID])
-			}
-		}
-	}
-}
-
-// Checks whenever image is an external parent.
-// External parent is an image that appears in the hierarchy only in the `FROM` clause but it is not defined among the hierarchy itself.
-func (h *dockerHierarchy) isExternalParent(parentID string) bool {
-	for _, i := range h.imagesTreeSlice {
-		if i.ID == parentID {
-			return false
-		}
-	}
-	return true
-}
-
-// Builds an object representing image in the tree view.
-func (h *dockerHierarchy) buildDockerTreeItem(dockerImg *DockerImage) *DockerTreeItem {
-	name := dockerImg.Name
-	if dockerImg.latestVersion != nil {
-		name = fmt.Sprintf("%s (latest: %s)", dockerImg.Name, dockerImg.latestVersion.String())
-	}
-	return &DockerTreeItem{
-		ID:       dockerImg.Name,
-		ParentID: dockerImg.DependsOnShort,
-		TreeItem: &gotree.GTStructure{
-			Name:  name,
-			Items: make([]*gotree.GTStructure, 0)}}
-}
-
-// Build tree view of the hierarchy.
-func (h *dockerHierarchy) buildTree(root *gotree.GTStructure) {
-	for _, i := range h.imagesTreePlusExternalParents {
-		// if item has a parent append it to the children of that parent
-		if i.ParentID != "" {
-			myParent := h.imagesTree[i.ParentID]
-			myParent.TreeItem.Items = append(myParent.TreeItem.Items, i.TreeItem)
-		} else {
-			// otherwise it will be treated as a root item
-			root.Items = append(root.Items, i.TreeItem)
-		}
-	}
-}
-
-// Prints hierarchy of docker images using external slightly modified (pointers added) gotree library.
-func (h *dockerHierarchy) PrintImageHierarchy(rootName string) {
-	root := &gotree.GTStructure{}
-	root.Name = rootName
-	h.createFirstLevelRoots()
-	h.buildTree(root)
-	gotree.PrintTree(root)
-}
@@ -0,0 +1,37 @@
+package service
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/smartrecruiters/docker-bakery/bakery/commons"
+)
+
+const bakeryIgnoreFileName = ".bakeryignore"
+
+// defaultIgnoredPaths are ignored regardless of the .bakeryignore file and the config
+var defaultIgnoredPaths = []string{".git/"}
+
+// readIgnoredPaths returns rules of the paths skipped while discovering the images: the default ones, rules from
+// the .bakeryignore file in the root dir and the config ignorePaths, all in the .gitignore format.
+func (cfg *Config) readIgnoredPaths() (*commons.IgnorePatterns, error) {
+	rules := append(append([]string{}, defaultIgnoredPaths...), cfg.IgnorePaths...)
+	ignoreFile := filepath.Join(cfg.RootDir, bakeryIgnoreFileName)
+	patterns, err := commons.ReadGitIgnoreFile(ignoreFile, rules)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read ignored paths from %s: %s", ignoreFile, err)
+	}
+	return patterns, nil
+}
+
+// isIgnoredPath returns true if the path found while walking the root dir is ignored, nil patterns ignore nothing.
+func isIgnoredPath(ignored *commons.IgnorePatterns, rootDir, sourcePath string, isDir bool) bool {
+	if ignored == nil {
+		return false
+	}
+	relPath, err := filepath.Rel(rootDir, sourcePath)
+	if err != nil || relPath == "." {
+		return false
+	}
+	return ignored.MatchesPath(filepath.ToSlash(relPath), isDir)
+}
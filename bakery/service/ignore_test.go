@@ -0,0 +1,39 @@
+package service
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/Masterminds/semver"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnalyzeStructureSkipsIgnoredPaths(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, bakeryIgnoreFileName), "# generated content\nnode_modules\n/archive/\n")
+	writeTestFile(t, filepath.Join(dir, "base", dockerFileTemplateName), "FROM ubuntu:20.04\n")
+	writeTestFile(t, filepath.Join(dir, "web", "node_modules", "pkg", dockerFileTemplateName), "FROM node:20\n")
+	writeTestFile(t, filepath.Join(dir, "archive", "legacy", dockerFileTemplateName), "not a dockerfile\n")
+	writeTestFile(t, filepath.Join(dir, "test", "fixtures", "broken", dockerFileTemplateName), "not a dockerfile\n")
+	writeTestFile(t, filepath.Join(dir, ".git", "hooks", dockerFileTemplateName), "not a dockerfile\n")
+	engine := givenTestEngine(&Config{RootDir: dir, IgnorePaths: []string{"fixtures/"}})
+
+	ignored, err := engine.config.readIgnoredPaths()
+	assert.NoError(t, err)
+	err = engine.hierarchy.AnalyzeStructure(dir, map[string]*semver.Version{}, ignored)
+
+	assert.NoError(t, err)
+	assert.Len(t, engine.hierarchy.GetImages(), 1)
+	assert.NotNil(t, engine.hierarchy.GetImageByName("base"))
+}
+
+func TestValidateSkipsIgnoredPaths(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "config.json"), `{"properties": {}, "commands": {"defaultBuildCommand": "echo", "defaultPushCommand": "echo"}, "ignorePaths": ["archive"]}`)
+	writeTestFile(t, filepath.Join(dir, "base", dockerFileTemplateName), "FROM ubuntu:20.04\n")
+	writeTestFile(t, filepath.Join(dir, "archive", "legacy", dockerFileTemplateName), "not a dockerfile\n")
+
+	problems := givenTestEngine(nil).validateStructure(filepath.Join(dir, "config.json"), "", "", nil)
+
+	assert.Empty(t, problems)
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	envReferenceType    = "ENV"
+	fileReferenceType   = "FILE"
+	envDefaultSeparator = ":-"
+	secretMask          = "******"
+	// minSecretLength prevents masking short values like 1 or true that would corrupt the printed text
+	minSecretLength = 4
+)
+
+// referencePattern matches ${ENV:NAME}, ${ENV:NAME:-default} and ${FILE:/path/to/file} references in property values
+var referencePattern = regexp.MustCompile(`\$\{(ENV|FILE):([^}]*)\}`)
+
+// sensitiveNamePattern matches names of the environment variables and properties holding secrets
+var sensitiveNamePattern = regexp.MustCompile(`(?i)(^|_)(TOKEN|PASS|PASSWD|PASSWORD|SECRET|CREDENTIALS?|AUTH|KEY|API_KEY|PRIVATE_KEY)(_|$)`)
+
+// interpolateProperties resolves environment variable and file references in the property values.
+// Returns values that should never be printed: values read from files and values of the environment variables
+// with sensitive names or referenced by the properties with sensitive names.
+func interpolateProperties(properties map[string]string) ([]string, error) {
+	secrets := make([]string, 0)
+	for propertyName, value := range properties {
+		var resolveErr error
+		resolved := referencePattern.ReplaceAllStringFunc(value, func(reference string) string {
+			groups := referencePattern.FindStringSubmatch(reference)
+			resolvedValue, isSecret, err := resolveReference(groups[1], groups[2])
+			if err != nil {
+				if resolveErr == nil {
+					resolveErr = fmt.Errorf("unable to resolve %s in property %s: %s", reference, propertyName, err)
+				}
+				return reference
+			}
+			if (isSecret || sensitiveNamePattern.MatchString(propertyName)) && resolvedValue != "" {
+				secrets = append(secrets, resolvedValue)
+			}
+			return resolvedValue
+		})
+		if resolveErr != nil {
+			return nil, resolveErr
+		}
+		properties[propertyName] = resolved
+	}
+	return secrets, nil
+}
+
+// resolveReference returns the value of referenced environment variable or file content and whenever the value is secret.
+// File contents and values of the environment variables with sensitive names (like REGISTRY_TOKEN) are secret,
+// apart from the default values of unset environment variables, as they come from the config itself.
+func resolveReference(referenceType, reference string) (string, bool, error) {
+	if referenceType == fileReferenceType {
+		content, err := ioutil.ReadFile(reference)
+		if err != nil {
+			return "", false, err
+		}
+		return strings.TrimRight(string(content), "\r\n"), true, nil
+	}
+
+	name, defaultValue, hasDefault := reference, "", false
+	if separatorIdx := strings.Index(reference, envDefaultSeparator); separatorIdx >= 0 {
+		name, defaultValue, hasDefault = reference[:separatorIdx], reference[separatorIdx+len(envDefaultSeparator):], true
+	}
+	value, isSet := os.LookupEnv(name)
+	if hasDefault && value == "" {
+		return defaultValue, false, nil
+	}
+	if !isSet {
+		return "", false, fmt.Errorf("environment variable %s is not set", name)
+	}
+	return value, sensitiveNamePattern.MatchString(name), nil
+}
+
+// interpolate resolves references in the properties of the config.
+// References in the profiles are resolved when the profile is applied, so only the selected profile has to resolve.
+func (cfg *Config) interpolate() error {
+	secrets, err := interpolateProperties(cfg.Properties)
+	if err != nil {
+		return err
+	}
+	cfg.addSecrets(secrets)
+	return nil
+}
+
+// addSecrets registers values that have to be masked whenever they are printed. Longer values are masked first.
+// Values shorter than minSecretLength are not masked.
+func (cfg *Config) addSecrets(secrets []string) {
+	for _, secret := range secrets {
+		if len(secret) >= minSecretLength {
+			cfg.secrets = append(cfg.secrets, secret)
+		}
+	}
+	sort.SliceStable(cfg.secrets, func(i, j int) bool {
+		return len(cfg.secrets[i]) > len(cfg.secrets[j])
+	})
+}
+
+// mask replaces all secret values in the text with the mask.
+func (cfg *Config) mask(text string) string {
+	for _, secret := range cfg.secrets {
+		text = strings.Replace(text, secret, secretMask, -1)
+	}
+	return text
+}
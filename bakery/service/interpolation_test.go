@@ -0,0 +1,109 @@
+package service
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInterpolateProperties(t *testing.T) {
+	// given
+	t.Setenv("BAKERY_TEST_REGISTRY", "registry.local")
+	t.Setenv("BAKERY_TEST_EMPTY", "")
+	t.Setenv("BAKERY_TEST_REGISTRY_PASS", "env-token")
+	t.Setenv("BAKERY_TEST_USER", "ci-user")
+	tokenFile := writeTestFile(t, filepath.Join(t.TempDir(), "token"), "file-token\n")
+	properties := map[string]string{
+		"REGISTRY":        "${ENV:BAKERY_TEST_REGISTRY}:9082",
+		"MIRROR":          "${ENV:BAKERY_TEST_MISSING:-mirror.local}",
+		"EMPTY":           "${ENV:BAKERY_TEST_EMPTY:-default}",
+		"REGISTRY_TOKEN":  "${ENV:BAKERY_TEST_REGISTRY_PASS}",
+		"USER_PASSWORD":   "${ENV:BAKERY_TEST_USER}",
+		"FILE_TOKEN":      "${FILE:" + tokenFile + "}",
+		"SHELL_REFERENCE": "${HOME}",
+	}
+
+	// when
+	secrets, err := interpolateProperties(properties)
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"REGISTRY":        "registry.local:9082",
+		"MIRROR":          "mirror.local",
+		"EMPTY":           "default",
+		"REGISTRY_TOKEN":  "env-token",
+		"USER_PASSWORD":   "ci-user",
+		"FILE_TOKEN":      "file-token",
+		"SHELL_REFERENCE": "${HOME}",
+	}, properties)
+	assert.ElementsMatch(t, []string{"env-token", "ci-user", "file-token"}, secrets)
+}
+
+func TestInterpolatePropertiesReportsMissingReferences(t *testing.T) {
+	_, err := interpolateProperties(map[string]string{"REGISTRY": "${ENV:BAKERY_TEST_MISSING}"})
+	assert.EqualError(t, err, "unable to resolve ${ENV:BAKERY_TEST_MISSING} in property REGISTRY: environment variable BAKERY_TEST_MISSING is not set")
+
+	_, err = interpolateProperties(map[string]string{"TOKEN": "${FILE:/non/existing/token}"})
+	assert.Error(t, err)
+}
+
+func TestReadConfigInterpolatesAndMasksSecrets(t *testing.T) {
+	// given
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "token"), "s3cr3t")
+	configFile := writeTestFile(t, filepath.Join(dir, "config.yaml"), `
+properties:
+  TOKEN: "${FILE:`+filepath.Join(dir, "token")+`}"
+profiles:
+  prod:
+    properties:
+      PROD_TOKEN: "prod-${FILE:`+filepath.Join(dir, "token")+`}"
+  dev:
+    properties:
+      DEV_TOKEN: "${ENV:BAKERY_TEST_MISSING}"
+`)
+
+	// when
+	cfg, err := ReadConfig(configFile)
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, "s3cr3t", cfg.Properties["TOKEN"])
+	assert.NoError(t, cfg.applyProfile("prod"))
+	assert.Equal(t, "prod-s3cr3t", cfg.Properties["PROD_TOKEN"])
+	assert.Equal(t, "docker login -p ****** registry.local", cfg.mask("docker login -p s3cr3t registry.local"))
+}
+
+func TestShortAndNotSensitiveValuesAreNotMasked(t *testing.T) {
+	// given
+	t.Setenv("BAKERY_TEST_ARCH", "amd64")
+	t.Setenv("BAKERY_TEST_DEBUG", "1")
+	t.Setenv("BAKERY_TEST_PIN", "42")
+	cfg := &Config{Properties: map[string]string{
+		"ARCH":  "${ENV:BAKERY_TEST_ARCH}",
+		"DEBUG": "${ENV:BAKERY_TEST_DEBUG}",
+		"PIN":   "${ENV:BAKERY_TEST_PIN}",
+		"KEY":   "${ENV:BAKERY_TEST_PIN}",
+	}}
+
+	// when
+	err := cfg.interpolate()
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, "Executing: docker build --platform linux/amd64 -t app:1.2.3 --build-arg DEBUG=1 --build-arg PIN=42 .",
+		cfg.mask("Executing: docker build --platform linux/amd64 -t app:1.2.3 --build-arg DEBUG=1 --build-arg PIN=42 ."))
+}
+
+func TestApplyProfileResolvesReferencesOfTheSelectedProfile(t *testing.T) {
+	cfg := &Config{
+		Properties: map[string]string{},
+		Profiles: map[string]*Profile{
+			"dev": {Properties: map[string]string{"DEV_TOKEN": "${ENV:BAKERY_TEST_MISSING}"}},
+		},
+	}
+
+	assert.EqualError(t, cfg.applyProfile("dev"), "profile dev: unable to resolve ${ENV:BAKERY_TEST_MISSING} in property DEV_TOKEN: environment variable BAKERY_TEST_MISSING is not set")
+}
@@ -0,0 +1,152 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	labelsModeArgs       = "args"
+	labelsModeDockerfile = "dockerfile"
+	ociLabelPrefix       = "org.opencontainers.image."
+	bakeryLabelPrefix    = "com.smartrecruiters.docker-bakery."
+)
+
+// label is a single key value pair added to the image metadata.
+type label struct {
+	key   string
+	value string
+}
+
+// imageLabels returns OCI annotation labels and bakery labels of the currently processed image.
+// Values are taken from the dynamic properties, labels with empty values are skipped.
+func (e *Engine) imageLabels(dockerImage *DockerImage) []label {
+	source := e.config.Labels.Source
+	if source == "" {
+		source = e.config.Properties[gitRepositoryPropName]
+	}
+
+	labels := []label{
+		{ociLabelPrefix + "title", dockerImage.Name},
+		{ociLabelPrefix + "version", e.config.Properties[imageVersionPropName]},
+		{ociLabelPrefix + "revision", e.config.Properties[gitRevisionPropName]},
+		{ociLabelPrefix + "created", e.config.Properties[buildTimestampPropName]},
+		{ociLabelPrefix + "source", source},
+		{ociLabelPrefix + "base.name", e.config.Properties[parentImagePropName]},
+		{ociLabelPrefix + "base.digest", e.config.Properties[parentDigestPropName]},
+		{bakeryLabelPrefix + "hierarchy", e.imageHierarchyChain(dockerImage, e.config)},
+		{bakeryLabelPrefix + "builder.name", e.config.Properties[builderNamePropName]},
+		{bakeryLabelPrefix + "builder.email", e.config.Properties[builderEmailPropName]},
+		{bakeryLabelPrefix + "builder.host", e.config.Properties[builderHostPropName]},
+		{bakeryLabelPrefix + "profile", e.config.profile},
+	}
+
+	result := make([]label, 0, len(labels))
+	for _, l := range labels {
+		if l.value != "" && l.value != unableToDetermine {
+			result = append(result, l)
+		}
+	}
+	return result
+}
+
+// imageHierarchyChain returns chain of the image ancestors with their versions, starting from the external base image,
+// for example: ubuntu:20.04->base:1.2.0->app:1.0.1
+func (e *Engine) imageHierarchyChain(dockerImage *DockerImage, cfg *Config) string {
+	chain := []string{fmt.Sprintf("%s:%s", dockerImage.Name, dockerImage.GetNextVersionString())}
+	visited := map[string]bool{dockerImage.Name: true}
+	current := dockerImage
+	for {
+		parent := e.hierarchy.GetImageByName(current.DependsOnShort)
+		if parent == nil || visited[parent.Name] {
+			chain = append([]string{e.resolveVersion(current.DependsOnLong, cfg)}, chain...)
+			break
+		}
+		visited[parent.Name] = true
+		chain = append([]string{fmt.Sprintf("%s:%s", parent.Name, cfg.Properties[dynamicImageVersionName(parent.Name)])}, chain...)
+		current = parent
+	}
+	return strings.Join(chain, "->")
+}
+
+// knownParentDigest returns digest of the parent pushed in the current run or the locked digest of the parent version
+// referenced in the FROM clause. Returns empty string when the digest is not known.
+func (e *Engine) knownParentDigest(dockerImage *DockerImage, cfg *Config) string {
+	for _, r := range e.commandResults {
+		if r.Name == dockerImage.DependsOnShort && r.Status != statusUpToDate && r.Digest != "" {
+			return r.Digest
+		}
+	}
+	lockedParent, isLocked := e.lock.Images[dockerImage.DependsOnShort]
+	if isLocked && lockedParent.Version == e.resolveVersion(dockerImage.DependsOnVersion, cfg) {
+		return lockedParent.Digest
+	}
+	return ""
+}
+
+// setParentImage sets properties with the reference and the known digest of the parent image.
+func (e *Engine) setParentImage(dockerImage *DockerImage) {
+	e.config.Properties[parentImagePropName] = e.resolveVersion(dockerImage.DependsOnLong, e.config)
+	e.config.Properties[parentDigestPropName] = e.knownParentDigest(dockerImage, e.config)
+}
+
+// labelArgs returns --label arguments of the built-in builders, empty unless labels are added as arguments.
+func (e *Engine) labelArgs(dockerImage *DockerImage) []string {
+	args := make([]string, 0)
+	if e.config.Labels.Mode != labelsModeArgs {
+		return args
+	}
+	for _, l := range e.imageLabels(dockerImage) {
+		args = append(args, "--label", fmt.Sprintf("%s=%s", l.key, l.value))
+	}
+	return args
+}
+
+// labelsJSON returns labels encoded as JSON object accepted by the Docker Engine API, empty unless labels are added as arguments.
+func (e *Engine) labelsJSON(dockerImage *DockerImage) string {
+	if e.config.Labels.Mode != labelsModeArgs {
+		return ""
+	}
+	labels := make(map[string]string)
+	for _, l := range e.imageLabels(dockerImage) {
+		labels[l.key] = l.value
+	}
+	encoded, _ := json.Marshal(labels)
+	return string(encoded)
+}
+
+// appendLabelLines appends LABEL instruction with the image labels to the rendered Dockerfile when labels are added to the Dockerfile.
+func (e *Engine) appendLabelLines(dockerfile string, dockerImage *DockerImage) error {
+	if e.config.Labels.Mode != labelsModeDockerfile {
+		return nil
+	}
+	labels := e.imageLabels(dockerImage)
+	if len(labels) == 0 {
+		return nil
+	}
+
+	lines := make([]string, 0, len(labels))
+	for _, l := range labels {
+		lines = append(lines, fmt.Sprintf("%q=%q", l.key, l.value))
+	}
+	instruction := fmt.Sprintf("\n# labels added by docker-bakery\nLABEL %s\n", strings.Join(lines, " \\\n      "))
+
+	f, err := os.OpenFile(dockerfile, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteString(instruction)
+	return err
+}
+
+func validateLabelsMode(mode string) error {
+	switch mode {
+	case "", labelsModeArgs, labelsModeDockerfile:
+		return nil
+	default:
+		return fmt.Errorf("unknown labels mode %s, expecting one of: %s, %s", mode, labelsModeArgs, labelsModeDockerfile)
+	}
+}
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/Masterminds/semver"
+	"github.com/stretchr/testify/assert"
+)
+
+func givenImagesToLabel(t *testing.T, mode string) (*Engine, *DockerImage) {
+	base := &DockerImage{Name: "base", DependsOnLong: "ubuntu:20.04", DependsOnShort: "ubuntu", DependsOnVersion: "20.04"}
+	app := &DockerImage{Name: "app", DependsOnLong: "registry.local/base:{{.BASE_VERSION}}", DependsOnShort: "base",
+		DependsOnVersion: "{{.BASE_VERSION}}", DockerfileDir: t.TempDir()}
+	app.DockerfilePath = filepath.Join(app.DockerfileDir, dockerFileTemplateName)
+	app.latestVersion, _ = semver.NewVersion("1.0.0")
+	app.CalculateNextVersion("minor")
+	engine := givenTestEngine(&Config{Labels: LabelsConfig{Mode: mode}, Properties: map[string]string{
+		"BASE_VERSION":        "2.0.0",
+		builderNamePropName:   "John Doe",
+		builderEmailPropName:  unableToDetermine,
+		builderHostPropName:   "ci-1",
+		gitRevisionPropName:   "0123abcd",
+		gitRepositoryPropName: "https://github.com/acme/images.git",
+	}})
+	engine.hierarchy.AddImage(base)
+	engine.hierarchy.AddImage(app)
+	engine.lock.Images["base"] = &LockedImage{Version: "2.0.0", Digest: "sha256:base"}
+	engine.updateDynamicProperties(app)
+	engine.config.Properties[buildTimestampPropName] = "2024-05-01T10:00:00Z"
+	return engine, app
+}
+
+func TestImageLabels(t *testing.T) {
+	engine, app := givenImagesToLabel(t, labelsModeArgs)
+
+	assert.Equal(t, []label{
+		{"org.opencontainers.image.title", "app"},
+		{"org.opencontainers.image.version", "1.1.0"},
+		{"org.opencontainers.image.revision", "0123abcd"},
+		{"org.opencontainers.image.created", "2024-05-01T10:00:00Z"},
+		{"org.opencontainers.image.source", "https://github.com/acme/images.git"},
+		{"org.opencontainers.image.base.name", "registry.local/base:2.0.0"},
+		{"org.opencontainers.image.base.digest", "sha256:base"},
+		{"com.smartrecruiters.docker-bakery.hierarchy", "ubuntu:20.04->base:2.0.0->app:1.1.0"},
+		{"com.smartrecruiters.docker-bakery.builder.name", "John Doe"},
+		{"com.smartrecruiters.docker-bakery.builder.host", "ci-1"},
+	}, engine.imageLabels(app))
+}
+
+func TestLabelArgsKeepSpacesInValues(t *testing.T) {
+	engine, app := givenImagesToLabel(t, labelsModeArgs)
+
+	args := engine.labelArgs(app)
+	assert.Contains(t, args, "com.smartrecruiters.docker-bakery.builder.name=John Doe")
+	var labels map[string]string
+	assert.NoError(t, json.Unmarshal([]byte(engine.labelsJSON(app)), &labels))
+	assert.Equal(t, "John Doe", labels["com.smartrecruiters.docker-bakery.builder.name"])
+}
+
+func TestCommandBuilderAppendsLabelArgs(t *testing.T) {
+	if _, err := exec.LookPath("printf"); err != nil {
+		t.Skip("printf command is not available")
+	}
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "base", dockerFileTemplateName), "FROM ubuntu:20.04\n")
+	output := &bytes.Buffer{}
+	engine := NewEngine(Options{
+		Config: &Config{
+			RootDir:  dir,
+			Commands: Commands{DefaultBuildCommand: "printf [%s]"},
+			Labels:   LabelsConfig{Mode: labelsModeArgs, Source: "acme images"},
+		},
+		DisableCache: true,
+		VersionStore: &fakeVersionStore{versions: map[string]*semver.Version{}},
+		Stdout:       output,
+		Stderr:       output,
+	})
+	assert.NoError(t, engine.InitConfiguration(context.Background()))
+
+	assert.NoError(t, engine.BuildDockerfile(context.Background(), filepath.Join(dir, "base", dockerFileTemplateName), "patch", false))
+
+	assert.Contains(t, output.String(), "[--label][org.opencontainers.image.title=base]")
+	assert.Contains(t, output.String(), "[--label][org.opencontainers.image.source=acme images]")
+}
+
+func TestLabelsAreNotAddedByDefault(t *testing.T) {
+	engine, app := givenImagesToLabel(t, "")
+	dockerfile := filepath.Join(app.DockerfileDir, dockerfileName)
+	writeTestFile(t, dockerfile, "FROM base\n")
+
+	assert.Empty(t, engine.labelArgs(app))
+	assert.Empty(t, engine.labelsJSON(app))
+	assert.NoError(t, engine.appendLabelLines(dockerfile, app))
+	content, _ := ioutil.ReadFile(dockerfile)
+	assert.Equal(t, "FROM base\n", string(content))
+}
+
+func TestAppendLabelLines(t *testing.T) {
+	engine, app := givenImagesToLabel(t, labelsModeDockerfile)
+	engine.config.Labels.Source = "https://example.com/images"
+	dockerfile := filepath.Join(app.DockerfileDir, dockerfileName)
+	writeTestFile(t, dockerfile, "FROM base\n")
+
+	assert.NoError(t, engine.appendLabelLines(dockerfile, app))
+
+	content, err := ioutil.ReadFile(dockerfile)
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "FROM base\n\n# labels added by docker-bakery\nLABEL \"org.opencontainers.image.title\"=\"app\" \\\n")
+	assert.Contains(t, string(content), "\"org.opencontainers.image.source\"=\"https://example.com/images\" \\\n")
+	assert.Contains(t, string(content), "\"com.smartrecruiters.docker-bakery.builder.name\"=\"John Doe\" \\\n")
+	assert.Empty(t, engine.labelArgs(app))
+}
+
+func TestUnknownLabelsModeIsRejected(t *testing.T) {
+	configFile := writeTestFile(t, filepath.Join(t.TempDir(), "config.json"), `{"labels": {"mode": "env"}}`)
+
+	_, err := ReadConfig(configFile)
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown labels mode env")
+}
@@ -0,0 +1,146 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/smartrecruiters/docker-bakery/bakery/commons"
+)
+
+const defaultLockFileName = "bakery.lock"
+
+func newLockFile() *LockFile {
+	return &LockFile{Images: make(map[string]*LockedImage)}
+}
+
+// ReadLockFile reads the lock file from provided path. Returns empty lock when the file does not exist yet.
+func ReadLockFile(lockFileName string) (*LockFile, error) {
+	content, err := ioutil.ReadFile(lockFileName)
+	if os.IsNotExist(err) {
+		return newLockFile(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	lockFile := newLockFile()
+	if err = json.Unmarshal(content, lockFile); err != nil {
+		return nil, fmt.Errorf("could not parse lock file %s due to: %s", lockFileName, err)
+	}
+	if lockFile.Images == nil {
+		lockFile.Images = make(map[string]*LockedImage)
+	}
+	return lockFile, nil
+}
+
+// lockFilePath returns path of the lock file, relative paths are resolved against the root dir.
+func (e *Engine) lockFilePath() string {
+	lockFileName := e.config.LockFileName
+	if lockFileName == "" {
+		lockFileName = defaultLockFileName
+	}
+	if filepath.IsAbs(lockFileName) {
+		return lockFileName
+	}
+	return filepath.Join(e.config.RootDir, lockFileName)
+}
+
+// loadLockFile reads the lock file for the current configuration.
+func (e *Engine) loadLockFile() error {
+	var err error
+	e.lock, err = ReadLockFile(e.lockFilePath())
+	return err
+}
+
+// updateLockedImage records new version and digest of the processed image in the lock.
+func (e *Engine) updateLockedImage(dockerImage *DockerImage, result *CommandResult) {
+	e.lock.Images[dockerImage.Name] = &LockedImage{
+		Version:      result.NextVersion,
+		Digest:       result.Digest,
+		Parent:       dockerImage.DependsOnShort,
+		TemplateHash: e.templateHash(dockerImage),
+		ContentHash:  result.ContentHash,
+	}
+}
+
+// writeLockFile completes the lock with all images from the hierarchy and stores it in the lock file.
+// Images not processed in the current run keep their previously locked state.
+func (e *Engine) writeLockFile() error {
+	images := e.hierarchy.GetImages()
+	for imgName := range e.lock.Images {
+		if _, exists := images[imgName]; !exists {
+			delete(e.lock.Images, imgName)
+		}
+	}
+	for imgName, img := range images {
+		if _, locked := e.lock.Images[imgName]; !locked {
+			e.lock.Images[imgName] = &LockedImage{
+				Version:      img.GetLatestVersionString(),
+				Parent:       img.DependsOnShort,
+				TemplateHash: e.templateHash(img),
+			}
+		}
+	}
+	for _, lockedImage := range e.lock.Images {
+		lockedImage.ParentDigest = ""
+		if parent, isInternal := e.lock.Images[lockedImage.Parent]; isInternal {
+			lockedImage.ParentDigest = parent.Digest
+		}
+	}
+
+	lockFileName := e.lockFilePath()
+	fmt.Fprintf(e.stdout, "Writing lock file %s\n", lockFileName)
+	return commons.WriteToJSONFile(e.lock, lockFileName)
+}
+
+// templateHash returns the checksum of the image Dockerfile.template.
+func (e *Engine) templateHash(dockerImage *DockerImage) string {
+	hash, err := commons.FileSHA256(dockerImage.DockerfilePath)
+	if err != nil {
+		e.debugf("Unable to calculate template hash of %s, err: %s", dockerImage.DockerfilePath, err)
+		return ""
+	}
+	return hash
+}
+
+// pinParentDigest rewrites the FROM clause of the rendered Dockerfile so that the parent image is referenced by its
+// locked digest (FROM parent:version@sha256:...). Parent is pinned only when the referenced version is the locked one.
+func (e *Engine) pinParentDigest(content []byte, dockerImage *DockerImage) ([]byte, error) {
+	lockedParent, isLocked := e.lock.Images[dockerImage.DependsOnShort]
+	if !isLocked || lockedParent.Digest == "" {
+		fmt.Fprintf(e.stdout, "Unable to pin %s parent image, digest of %s is not locked\n", dockerImage.Name, dockerImage.DependsOnShort)
+		return content, nil
+	}
+	parentVersion := e.resolveVersion(dockerImage.DependsOnVersion, e.config)
+	if parentVersion != lockedParent.Version {
+		fmt.Fprintf(e.stdout, "Unable to pin %s parent image, locked version of %s is %s while %s is used\n",
+			dockerImage.Name, dockerImage.DependsOnShort, lockedParent.Version, parentVersion)
+		return content, nil
+	}
+
+	pinned, err := pinFromClause(string(content), lockedParent.Digest)
+	if err != nil {
+		return nil, fmt.Errorf("unable to pin parent image in %s: %s", dockerImage.DockerfilePath, err)
+	}
+	fmt.Fprintf(e.stdout, "Pinning %s parent image to %s\n", dockerImage.Name, lockedParent.Digest)
+	return []byte(pinned), nil
+}
+
+// pinFromClause appends the digest to the image reference in the first FROM clause of the Dockerfile content.
+func pinFromClause(content, digest string) (string, error) {
+	lines := strings.SplitN(content, "\n", 2)
+	fields := strings.Fields(lines[0])
+	if len(fields) < 2 || !strings.EqualFold(fields[0], strings.TrimSpace(dependencyPrefix)) {
+		return "", fmt.Errorf("first line does not start with `FROM `")
+	}
+	if strings.Contains(fields[1], "@") {
+		return content, nil
+	}
+	fields[1] = fmt.Sprintf("%s@%s", fields[1], digest)
+	lines[0] = strings.Join(fields, " ")
+	return strings.Join(lines, "\n"), nil
+}
@@ -0,0 +1,74 @@
+package service
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteLockFileKeepsNotProcessedImagesAndResolvesParentDigests(t *testing.T) {
+	// given
+	rootDir := t.TempDir()
+	engine := givenTestEngine(&Config{RootDir: rootDir})
+	base := &DockerImage{Name: "base", DependsOnShort: "ubuntu", DockerfilePath: filepath.Join(rootDir, "base", dockerFileTemplateName)}
+	app := &DockerImage{Name: "app", DependsOnShort: "base", DockerfilePath: filepath.Join(rootDir, "app", dockerFileTemplateName)}
+	engine.hierarchy.AddImage(base)
+	engine.hierarchy.AddImage(app)
+	engine.lock.Images["app"] = &LockedImage{Version: "2.0.0", Digest: "sha256:app", Parent: "base"}
+	engine.lock.Images["removed"] = &LockedImage{Version: "1.0.0"}
+
+	// when
+	engine.updateLockedImage(base, &CommandResult{Name: "base", NextVersion: "1.1.0", Digest: "sha256:base"})
+	err := engine.writeLockFile()
+	written, readErr := ReadLockFile(filepath.Join(rootDir, defaultLockFileName))
+
+	// then
+	assert.NoError(t, err)
+	assert.NoError(t, readErr)
+	assert.Len(t, written.Images, 2)
+	assert.Equal(t, &LockedImage{Version: "1.1.0", Digest: "sha256:base", Parent: "ubuntu"}, written.Images["base"])
+	assert.Equal(t, &LockedImage{Version: "2.0.0", Digest: "sha256:app", Parent: "base", ParentDigest: "sha256:base"}, written.Images["app"])
+}
+
+func TestPinFromClause(t *testing.T) {
+	pinned, err := pinFromClause("FROM registry.local/base:1.1.0 AS build\nRUN true\n", "sha256:base")
+	assert.NoError(t, err)
+	assert.Equal(t, "FROM registry.local/base:1.1.0@sha256:base AS build\nRUN true\n", pinned)
+
+	alreadyPinned, err := pinFromClause("FROM base:1.1.0@sha256:old\n", "sha256:base")
+	assert.NoError(t, err)
+	assert.Equal(t, "FROM base:1.1.0@sha256:old\n", alreadyPinned)
+
+	_, err = pinFromClause("# comment\nFROM base\n", "sha256:base")
+	assert.Error(t, err)
+}
+
+func TestOutputFilesAreRecordedInResult(t *testing.T) {
+	// given
+	files, err := newOutputFiles(&debugLogger{})
+	assert.NoError(t, err)
+	defer files.remove()
+	ioutil.WriteFile(files.ImageIDFile, []byte("sha256:config\n"), 0644)
+	ioutil.WriteFile(files.MetadataFile, []byte(`{"containerimage.digest": "sha256:manifest"}`), 0644)
+	result := &CommandResult{}
+
+	// when
+	files.record(result)
+
+	// then
+	assert.Equal(t, "sha256:config", result.ImageID)
+	assert.Equal(t, "sha256:manifest", result.Digest)
+}
+
+func TestDigestFromRepoDigests(t *testing.T) {
+	repoDigests := []byte(`["registry.local/other@sha256:other","registry.local/app@sha256:app"]`)
+
+	digest, err := digestFromRepoDigests(repoDigests, "registry.local/app:1.0.0")
+	assert.NoError(t, err)
+	assert.Equal(t, "sha256:app", digest)
+
+	_, err = digestFromRepoDigests(repoDigests, "app:1.0.0")
+	assert.Error(t, err)
+}
@@ -0,0 +1,199 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+	// number of the last lines of the failing image log printed to the console
+	logTailLines = 20
+
+	eventImageStarted    = "image-started"
+	eventCommandExecuted = "command-executed"
+	eventImageFinished   = "image-finished"
+)
+
+// processingLog writes the output of the commands to the log file of the processed image (when the log dir is set)
+// and emits the structured processing events (when the json log format is selected).
+type processingLog struct {
+	engine *Engine
+	dir    string
+	events io.Writer
+	image  *imageLog
+}
+
+// imageLog is the log of the single build or push of the image.
+type imageLog struct {
+	name      string
+	operation string
+	path      string
+	file      *os.File
+	start     time.Time
+	commands  []string
+}
+
+// logEvent is the structured processing event written as a single JSON line.
+type logEvent struct {
+	Time       string `json:"time"`
+	Event      string `json:"event"`
+	Image      string `json:"image,omitempty"`
+	Operation  string `json:"operation,omitempty"`
+	Version    string `json:"version,omitempty"`
+	Command    string `json:"command,omitempty"`
+	ExitCode   *int   `json:"exitCode,omitempty"`
+	Status     string `json:"status,omitempty"`
+	DurationMs *int64 `json:"durationMs,omitempty"`
+	Error      string `json:"error,omitempty"`
+	LogFile    string `json:"logFile,omitempty"`
+}
+
+// newProcessingLog creates the log writing image logs to the dir (empty dir keeps the output on the console)
+// and emitting events to the engine events writer when the format is json.
+func (e *Engine) newProcessingLog(dir, format string) (*processingLog, error) {
+	switch format {
+	case "", logFormatText:
+		return &processingLog{engine: e, dir: dir}, nil
+	case logFormatJSON:
+		return &processingLog{engine: e, dir: dir, events: e.events}, nil
+	default:
+		return nil, fmt.Errorf("unsupported log format %s, expected one of: %s, %s", format, logFormatText, logFormatJSON)
+	}
+}
+
+// startImage opens the log of the image operation and emits the image started event.
+func (l *processingLog) startImage(operation string, dockerImage *DockerImage) error {
+	l.image = &imageLog{name: dockerImage.Name, operation: operation, start: time.Now()}
+	if l.dir != "" {
+		if err := os.MkdirAll(l.dir, 0755); err != nil {
+			return fmt.Errorf("unable to create log dir %s: %s", l.dir, err)
+		}
+		l.image.path = filepath.Join(l.dir, fmt.Sprintf("%s-%s.log", dockerImage.Name, operation))
+		file, err := os.Create(l.image.path)
+		if err != nil {
+			return fmt.Errorf("unable to create log file %s: %s", l.image.path, err)
+		}
+		l.image.file = file
+	}
+	l.emit(logEvent{Event: eventImageStarted, Version: dockerImage.GetNextVersionString()})
+	return nil
+}
+
+// commandExecuted emits the event of the command executed for the processed image.
+func (l *processingLog) commandExecuted(command string, duration time.Duration, err error) {
+	exitCode := 0
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		exitCode = exitErr.ExitCode()
+	} else if err != nil {
+		exitCode = -1
+	}
+	if l.image != nil {
+		l.image.commands = append(l.image.commands, l.engine.config.mask(command))
+	}
+	l.emit(logEvent{Event: eventCommandExecuted, Command: l.engine.config.mask(command), ExitCode: &exitCode, DurationMs: milliseconds(duration), Error: l.engine.errorMessage(err)})
+}
+
+// finishImage closes the log of the processed image, records its duration and executed commands in the result,
+// emits the image finished event and prints the progress line.
+// Tail of the log is printed when the image failed and its output was written to the log file.
+func (l *processingLog) finishImage(ctx context.Context, result *CommandResult, err error) {
+	imgLog := l.image
+	if imgLog == nil {
+		return
+	}
+	if imgLog.file != nil {
+		imgLog.file.Close()
+	}
+	status := result.Status
+	if err != nil && ctx.Err() != nil {
+		status = statusCancelled
+	} else if err != nil {
+		status = statusFailed
+	}
+	duration := time.Since(imgLog.start)
+	result.DurationMs = duration.Milliseconds()
+	result.Commands = imgLog.commands
+	l.emit(logEvent{Event: eventImageFinished, Version: result.NextVersion, Status: status, DurationMs: milliseconds(duration), Error: l.engine.errorMessage(err), LogFile: imgLog.path})
+	l.image = nil
+
+	progress := fmt.Sprintf("%s of %s %s: %s in %v", imgLog.operation, imgLog.name, result.NextVersion, status, duration.Round(time.Millisecond))
+	if imgLog.path != "" {
+		progress += fmt.Sprintf(" (log: %s)", imgLog.path)
+	}
+	if err == nil {
+		fmt.Fprintln(l.engine.stdout, color.GreenString(progress))
+		return
+	}
+	fmt.Fprintln(l.engine.stdout, color.RedString(progress))
+	if imgLog.path != "" && status == statusFailed {
+		fmt.Fprintf(l.engine.stdout, "Last %d lines of %s:\n%s", logTailLines, imgLog.path, l.engine.logTail(imgLog.path, logTailLines))
+	}
+}
+
+func (l *processingLog) emit(event logEvent) {
+	if l.events == nil {
+		return
+	}
+	event.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	if l.image != nil {
+		event.Image = l.image.name
+		event.Operation = l.image.operation
+	}
+	line, err := json.Marshal(event)
+	if err != nil {
+		l.engine.debugf("Unable to marshal log event %v, err: %s", event, err)
+		return
+	}
+	fmt.Fprintln(l.events, string(line))
+}
+
+// logTail returns the last lines of the log file, every line terminated with the new line.
+func (e *Engine) logTail(path string, lines int) string {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Sprintf("unable to read the log: %s\n", err)
+	}
+	logLines := strings.Split(strings.TrimRight(e.config.mask(string(content)), "\n"), "\n")
+	if len(logLines) > lines {
+		logLines = logLines[len(logLines)-lines:]
+	}
+	return strings.Join(logLines, "\n") + "\n"
+}
+
+func milliseconds(duration time.Duration) *int64 {
+	ms := duration.Milliseconds()
+	return &ms
+}
+
+func (e *Engine) errorMessage(err error) string {
+	if err == nil {
+		return ""
+	}
+	return e.config.mask(err.Error())
+}
+
+// commandOutput writes to the log file of the image processed by the engine when there is one, to the console writer otherwise.
+type commandOutput struct {
+	engine  *Engine
+	console io.Writer
+}
+
+func (o commandOutput) Write(p []byte) (int, error) {
+	if image := o.engine.processing.image; image != nil && image.file != nil {
+		return image.file.Write(p)
+	}
+	return o.console.Write(p)
+}
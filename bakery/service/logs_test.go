@@ -0,0 +1,93 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Masterminds/semver"
+	"github.com/stretchr/testify/assert"
+)
+
+func givenLoggingEngine(t *testing.T, buildCommand, logFormat string) (*Engine, string, *bytes.Buffer, *bytes.Buffer) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "base", dockerFileTemplateName), "FROM ubuntu:20.04\n")
+	logDir := filepath.Join(dir, "logs")
+	output, console := &bytes.Buffer{}, &bytes.Buffer{}
+	engine := NewEngine(Options{
+		Config:       &Config{RootDir: dir, Commands: Commands{DefaultBuildCommand: buildCommand}},
+		DisableCache: true,
+		VersionStore: &fakeVersionStore{versions: map[string]*semver.Version{}},
+		Stdout:       output,
+		Stderr:       console,
+		LogDir:       logDir,
+		LogFormat:    logFormat,
+	})
+	assert.NoError(t, engine.InitConfiguration(context.Background()))
+	return engine, logDir, output, console
+}
+
+func TestEngineWritesImageLogsAndEvents(t *testing.T) {
+	if _, err := exec.LookPath("echo"); err != nil {
+		t.Skip("echo command is not available")
+	}
+	engine, logDir, output, console := givenLoggingEngine(t, "echo building {{.IMAGE_NAME}}", logFormatJSON)
+
+	assert.NoError(t, engine.BuildDockerfile(context.Background(), filepath.Join(engine.options.Config.RootDir, "base", dockerFileTemplateName), "patch", true))
+
+	imageLog, err := os.ReadFile(filepath.Join(logDir, "base-build.log"))
+	assert.NoError(t, err)
+	assert.Equal(t, "Executing: echo building base\nbuilding base\n", string(imageLog))
+	var events []logEvent
+	for _, line := range strings.Split(strings.TrimSpace(output.String()), "\n") {
+		var event logEvent
+		assert.NoError(t, json.Unmarshal([]byte(line), &event))
+		events = append(events, event)
+	}
+	if assert.Len(t, events, 3) {
+		assert.Equal(t, eventImageStarted, events[0].Event)
+		assert.Equal(t, "0.0.1", events[0].Version)
+		assert.Equal(t, eventCommandExecuted, events[1].Event)
+		assert.Equal(t, "echo building base", events[1].Command)
+		assert.Equal(t, 0, *events[1].ExitCode)
+		assert.Equal(t, eventImageFinished, events[2].Event)
+		assert.Equal(t, statusSuccess, events[2].Status)
+		assert.Equal(t, filepath.Join(logDir, "base-build.log"), events[2].LogFile)
+		assert.NotNil(t, events[2].DurationMs)
+	}
+	assert.Contains(t, console.String(), "build of base 0.0.1: success in")
+	assert.NotContains(t, console.String(), "building base")
+}
+
+func TestEnginePrintsTailOfFailingImageLog(t *testing.T) {
+	if _, err := exec.LookPath("ls"); err != nil {
+		t.Skip("ls command is not available")
+	}
+	engine, logDir, output, _ := givenLoggingEngine(t, "ls {{.IMAGE_NAME}}-missing-file", logFormatText)
+
+	assert.Error(t, engine.BuildDockerfile(context.Background(), filepath.Join(engine.options.Config.RootDir, "base", dockerFileTemplateName), "patch", true))
+
+	logFile := filepath.Join(logDir, "base-build.log")
+	assert.Contains(t, output.String(), "build of base 0.0.1: failed in")
+	assert.Contains(t, output.String(), "Last 20 lines of "+logFile+":\nExecuting: ls base-missing-file\n")
+}
+
+func TestUnsupportedLogFormat(t *testing.T) {
+	engine := NewEngine(Options{Config: &Config{RootDir: t.TempDir()}, LogFormat: "xml"})
+
+	assert.EqualError(t, engine.InitConfiguration(context.Background()), "unsupported log format xml, expected one of: text, json")
+}
+
+func TestLogTail(t *testing.T) {
+	engine := givenTestEngine(&Config{})
+	logFile := filepath.Join(t.TempDir(), "app-build.log")
+	writeTestFile(t, logFile, "first\nsecond\nthird\n")
+
+	assert.Equal(t, "second\nthird\n", engine.logTail(logFile, 2))
+	assert.Equal(t, "first\nsecond\nthird\n", engine.logTail(logFile, 5))
+}
@@ -3,21 +3,23 @@ package service
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
-
-	"github.com/smartrecruiters/docker-bakery/bakery/commons"
 )
 
 // Type holding image parser functionality.
-type dockerImageParser struct{}
+type dockerImageParser struct {
+	logger Logger
+	output io.Writer
+}
 
 // Extracts directory name of the provided dockerfile.
 func (dip *dockerImageParser) ExtractDockerFileDir(dockerfile string) (string, error) {
 	dockerfile, err := filepath.Abs(dockerfile)
-	commons.Debugf("Resolved dockerfile path to %s", dockerfile)
+	dip.logger.Debugf("Resolved dockerfile path to %s", dockerfile)
 	if err != nil {
 		return "", err
 	}
@@ -44,7 +46,7 @@ func (dip *dockerImageParser) ParseDockerfile(dockerfilePath string) (*DockerIma
 	if err != nil {
 		return nil, err
 	}
-	commons.Debugf("Resolved image name %s, dir: %s", imageName, dockerfileDir)
+	dip.logger.Debugf("Resolved image name %s, dir: %s", imageName, dockerfileDir)
 	inFile, err := os.Open(dockerfilePath)
 	defer inFile.Close()
 
@@ -63,7 +65,7 @@ func (dip *dockerImageParser) ParseDockerfile(dockerfilePath string) (*DockerIma
 		dependsOnVersion := ""
 		parts := strings.Split(dependsOnLong, "/")
 		if len(parts) <= 0 {
-			fmt.Printf("WARN: Unable to determine short base image name for: %s", dockerfilePath)
+			fmt.Fprintf(dip.output, "WARN: Unable to determine short base image name for: %s", dockerfilePath)
 		} else {
 			imgNameWithVersion := parts[len(parts)-1]
 			imgNameWithVersionParts := strings.Split(imgNameWithVersion, ":")
@@ -88,5 +90,10 @@ func (dip *dockerImageParser) ParseDockerfile(dockerfilePath string) (*DockerIma
 
 // NewDockerImageParser initializes new docker image parser.
 func NewDockerImageParser() DockerImageParser {
-	return &dockerImageParser{}
+	return newDockerImageParser(&debugLogger{}, os.Stdout)
+}
+
+// newDockerImageParser initializes docker image parser logging to the provided logger and output.
+func newDockerImageParser(logger Logger, output io.Writer) DockerImageParser {
+	return &dockerImageParser{logger: logger, output: output}
 }
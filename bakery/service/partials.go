@@ -0,0 +1,108 @@
+package service
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/smartrecruiters/docker-bakery/bakery/commons"
+)
+
+// loadPartials loads the template partials from the partials dir, relative dir is resolved against the root dir.
+func (e *Engine) loadPartials() error {
+	if e.config.PartialsDir == "" {
+		return nil
+	}
+
+	dir := e.config.PartialsDir
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(e.config.RootDir, dir)
+	}
+	partials, err := commons.LoadPartials(dir)
+	if err != nil {
+		return err
+	}
+	e.debugf("Loaded partials from %s: %s", dir, partials.FileNames())
+	e.config.partials = partials
+	return nil
+}
+
+// usedPartials returns relative paths of the partial files used by the Dockerfile template of the image.
+func (e *Engine) usedPartials(dockerImage *DockerImage) ([]string, error) {
+	if e.config.partials == nil {
+		return []string{}, nil
+	}
+	t, err := commons.ParseTemplateFile(dockerImage.DockerfilePath, e.templateOptions(dockerImage))
+	if err != nil {
+		return nil, err
+	}
+	return e.config.partials.UsedFiles(t), nil
+}
+
+// affectedImages returns sorted names of the images affected by the changed files along with their dependants.
+// Image is affected when the changed file is located in its build context or is a partial used by its template.
+func (e *Engine) affectedImages(changedFiles []string) ([]string, error) {
+	affected := make(map[string]string)
+	for _, dockerImage := range e.hierarchy.GetImages() {
+		isAffected, err := e.isAffectedBy(dockerImage, changedFiles)
+		if err != nil {
+			return nil, err
+		}
+		if isAffected {
+			e.addWithDependants(affected, dockerImage)
+		}
+	}
+	return commons.SortMapKeys(affected), nil
+}
+
+// printAffectedImages prints names of the images affected by the changed files, one per line.
+func (e *Engine) printAffectedImages(changedFiles []string) error {
+	names, err := e.affectedImages(changedFiles)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		fmt.Fprintln(e.stdout, name)
+	}
+	return nil
+}
+
+func (e *Engine) isAffectedBy(dockerImage *DockerImage, changedFiles []string) (bool, error) {
+	contextDir := absolutePath(dockerImage.DockerfileDir)
+	for _, changedFile := range changedFiles {
+		if isInDir(absolutePath(changedFile), contextDir) {
+			return true, nil
+		}
+	}
+
+	if e.config.partials == nil {
+		return false, nil
+	}
+	partials, err := e.usedPartials(dockerImage)
+	if err != nil {
+		return false, fmt.Errorf("unable to determine partials used by %s: %s", dockerImage.DockerfilePath, err)
+	}
+	partialsDir := absolutePath(e.config.partials.Dir)
+	for _, changedFile := range changedFiles {
+		relPath, err := filepath.Rel(partialsDir, absolutePath(changedFile))
+		if err == nil && commons.Contains(partials, filepath.ToSlash(relPath)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func isInDir(file, dir string) bool {
+	relPath, err := filepath.Rel(dir, file)
+	return err == nil && relPath != ".." && !strings.HasPrefix(relPath, ".."+string(filepath.Separator))
+}
+
+func (e *Engine) addWithDependants(images map[string]string, dockerImage *DockerImage) {
+	if _, added := images[dockerImage.Name]; added {
+		return
+	}
+	images[dockerImage.Name] = dockerImage.Name
+	for _, dependant := range e.hierarchy.GetImagesWithDependants()[dockerImage.Name] {
+		e.addWithDependants(images, dependant)
+	}
+}
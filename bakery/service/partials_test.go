@@ -0,0 +1,75 @@
+package service
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/Masterminds/semver"
+	"github.com/stretchr/testify/assert"
+)
+
+func givenImagesWithPartials(t *testing.T) (*Engine, string) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "partials", "labels.tmpl"), `{{define "labels"}}LABEL maintainer={{.MAINTAINER}}{{end}}`)
+	writeTestFile(t, filepath.Join(dir, "partials", "common", "cleanup.tmpl"), "{{/* removes caches */}}RUN rm -rf /var/cache/*\n")
+	writeTestFile(t, filepath.Join(dir, "partials", "common", "user.tmpl"), "USER app\n")
+	writeTestFile(t, filepath.Join(dir, "base", dockerFileTemplateName), "FROM ubuntu:20.04\n{{template \"labels\" .}}\n")
+	writeTestFile(t, filepath.Join(dir, "base", "app", dockerFileTemplateName), "FROM base:{{.BASE_VERSION}}\n{{include \"common/cleanup.tmpl\" .}}")
+	writeTestFile(t, filepath.Join(dir, "tools", dockerFileTemplateName), "FROM alpine:3\n")
+
+	engine := givenTestEngine(&Config{RootDir: dir, PartialsDir: "partials", Properties: map[string]string{"MAINTAINER": "bakery", "BASE_VERSION": "1.0.0"}})
+	assert.NoError(t, engine.loadPartials())
+	assert.NoError(t, engine.hierarchy.AnalyzeStructure(dir, map[string]*semver.Version{}, nil))
+	return engine, dir
+}
+
+func TestFillTemplateStringWithPartials(t *testing.T) {
+	engine, _ := givenImagesWithPartials(t)
+
+	result, err := engine.fillTemplateString("cmd", `{{template "labels" .}} {{include "common/user.tmpl" . | trim}}`)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "LABEL maintainer=bakery USER app", result)
+}
+
+func TestContentHashChangesWithUsedPartial(t *testing.T) {
+	// given
+	engine, dir := givenImagesWithPartials(t)
+	app := engine.hierarchy.GetImageByName("app")
+	before, err := engine.contentHash(app)
+	assert.NoError(t, err)
+
+	// when unused partial changes
+	writeTestFile(t, filepath.Join(dir, "partials", "common", "user.tmpl"), "USER nobody\n")
+	assert.NoError(t, engine.loadPartials())
+	afterUnusedChange, err := engine.contentHash(app)
+	assert.NoError(t, err)
+
+	// and used partial changes without affecting the rendered Dockerfile
+	writeTestFile(t, filepath.Join(dir, "partials", "common", "cleanup.tmpl"), "{{/* removes apt caches */}}RUN rm -rf /var/cache/*\n")
+	assert.NoError(t, engine.loadPartials())
+	afterUsedChange, err := engine.contentHash(app)
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, before, afterUnusedChange)
+	assert.NotEqual(t, before, afterUsedChange)
+}
+
+func TestAffectedImages(t *testing.T) {
+	engine, dir := givenImagesWithPartials(t)
+
+	testCases := map[string][]string{
+		filepath.Join(dir, "partials", "labels.tmpl"):             {"app", "base"},
+		filepath.Join(dir, "partials", "common", "cleanup.tmpl"):  {"app"},
+		filepath.Join(dir, "partials", "common", "user.tmpl"):     {},
+		filepath.Join(dir, "tools", "install.sh"):                 {"tools"},
+		filepath.Join(dir, "base", "app", dockerFileTemplateName): {"app", "base"},
+		filepath.Join(dir, "README.md"):                           {},
+	}
+	for changedFile, expected := range testCases {
+		affected, err := engine.affectedImages([]string{changedFile})
+		assert.NoError(t, err)
+		assert.Equal(t, expected, affected, changedFile)
+	}
+}
@@ -0,0 +1,163 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/smartrecruiters/docker-bakery/bakery/commons"
+)
+
+const (
+	statusSuccess = "success"
+	statusFailed  = "failed"
+	// statusCancelled is the status of the images not processed because the processing was cancelled
+	statusCancelled = "cancelled"
+)
+
+// imagePlatforms returns platforms the image should be built for. Platforms defined for the image
+// in the config images section take precedence over the global ones. Empty result means no explicit platform.
+func (cfg *Config) imagePlatforms(imageName string) []string {
+	if imgCfg, ok := cfg.Images[imageName]; ok && imgCfg != nil && len(imgCfg.Platforms) > 0 {
+		return imgCfg.Platforms
+	}
+	return cfg.Platforms
+}
+
+// resolvePlatforms determines platforms of the image that can be built. Child image is built only for the
+// platforms its parent published (in the current run) or declared (in the config). Platforms missing in the parent
+// are recorded in the result as failed.
+func (e *Engine) resolvePlatforms(dockerImage *DockerImage, result *CommandResult) []string {
+	requested := e.config.imagePlatforms(dockerImage.Name)
+	if len(requested) == 0 {
+		return nil
+	}
+
+	parentPlatforms, isKnown := e.parentPlatforms(dockerImage)
+	if !isKnown {
+		return requested
+	}
+
+	platforms := make([]string, 0, len(requested))
+	for _, platform := range requested {
+		if commons.Contains(parentPlatforms, platform) {
+			platforms = append(platforms, platform)
+			continue
+		}
+		result.addPlatformResult(platform, fmt.Errorf("parent image %s does not provide platform %s (parent platforms: %s)",
+			dockerImage.DependsOnShort, platform, strings.Join(parentPlatforms, ",")))
+	}
+	return platforms
+}
+
+// parentPlatforms returns platforms of the parent image and whenever they are known.
+// Platforms are unknown for the external parents and internal parents without configured platforms.
+func (e *Engine) parentPlatforms(dockerImage *DockerImage) ([]string, bool) {
+	if platforms, processed := e.publishedPlatforms[dockerImage.DependsOnShort]; processed {
+		return platforms, true
+	}
+	if e.hierarchy.GetImageByName(dockerImage.DependsOnShort) == nil {
+		return nil, false
+	}
+	platforms := e.config.imagePlatforms(dockerImage.DependsOnShort)
+	return platforms, len(platforms) > 0
+}
+
+// storePublishedPlatforms remembers platforms successfully processed for the image, so they can be verified for its dependants.
+func (e *Engine) storePublishedPlatforms(dockerImage *DockerImage, result *CommandResult) {
+	if len(dockerImage.platforms) == 0 {
+		return
+	}
+	published := make([]string, 0, len(result.Platforms))
+	for _, p := range result.Platforms {
+		if p.Status == statusSuccess {
+			published = append(published, p.Platform)
+		}
+	}
+	e.publishedPlatforms[dockerImage.Name] = published
+}
+
+// addPlatformResult records the outcome of processing the given platform. Replaces previously recorded outcome.
+func (r *CommandResult) addPlatformResult(platform string, err error) {
+	platformResult := &PlatformResult{Platform: platform, Status: statusSuccess}
+	if err != nil {
+		platformResult.Status = statusFailed
+		platformResult.Error = err.Error()
+	}
+	for i, p := range r.Platforms {
+		if p.Platform == platform {
+			r.Platforms[i] = platformResult
+			return
+		}
+	}
+	r.Platforms = append(r.Platforms, platformResult)
+}
+
+// completePlatforms marks processed platforms without recorded outcome as successful.
+// Used after the builder finished processing the image without errors.
+func (r *CommandResult) completePlatforms(platforms []string) {
+	for _, platform := range platforms {
+		if !r.hasPlatformResult(platform) {
+			r.addPlatformResult(platform, nil)
+		}
+	}
+}
+
+func (r *CommandResult) hasPlatformResult(platform string) bool {
+	for _, p := range r.Platforms {
+		if p.Platform == platform {
+			return true
+		}
+	}
+	return false
+}
+
+// platformsError returns an error when none of the processed platforms succeeded.
+func (r *CommandResult) platformsError() error {
+	failed := make([]string, 0)
+	for _, p := range r.Platforms {
+		if p.Status == statusSuccess {
+			return nil
+		}
+		failed = append(failed, fmt.Sprintf("%s: %s", p.Platform, p.Error))
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("all platforms failed (%s)", strings.Join(failed, "; "))
+}
+
+// failedPlatforms returns errors of the platforms that failed while some other platforms succeeded.
+func (r *CommandResult) failedPlatforms() []error {
+	errs := make([]error, 0)
+	for _, p := range r.Platforms {
+		if p.Status == statusFailed {
+			errs = append(errs, fmt.Errorf("error processing %s for platform %s: %s", r.Name, p.Platform, p.Error))
+		}
+	}
+	return errs
+}
+
+// copyPlatformResults returns copies of the platform results.
+func copyPlatformResults(platforms []*PlatformResult) []*PlatformResult {
+	if platforms == nil {
+		return nil
+	}
+	copied := make([]*PlatformResult, len(platforms))
+	for i, platform := range platforms {
+		platformCopy := *platform
+		copied[i] = &platformCopy
+	}
+	return copied
+}
+
+// platformsSummary returns short summary of the platform outcomes, used in the report.
+func (r *CommandResult) platformsSummary() string {
+	if len(r.Platforms) == 0 {
+		return ""
+	}
+	summaries := make([]string, 0, len(r.Platforms))
+	for _, p := range r.Platforms {
+		summaries = append(summaries, fmt.Sprintf("%s: %s", p.Platform, p.Status))
+	}
+	return fmt.Sprintf(" [%s]", strings.Join(summaries, ", "))
+}
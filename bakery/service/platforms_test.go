@@ -0,0 +1,62 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setupPlatformsTest(images map[string]*ImageConfig, platforms ...string) *Engine {
+	engine := givenTestEngine(&Config{Platforms: platforms, Images: images})
+	engine.hierarchy.AddImage(&DockerImage{Name: "base", DependsOnShort: "ubuntu"})
+	engine.hierarchy.AddImage(&DockerImage{Name: "app", DependsOnShort: "base"})
+	return engine
+}
+
+func TestChildIsBuiltOnlyForPlatformsPublishedByParent(t *testing.T) {
+	// given
+	engine := setupPlatformsTest(nil, "linux/amd64", "linux/arm64")
+	engine.publishedPlatforms["base"] = []string{"linux/amd64"}
+	app := engine.hierarchy.GetImageByName("app")
+	result := &CommandResult{Name: "app"}
+
+	// when
+	platforms := engine.resolvePlatforms(app, result)
+
+	// then
+	assert.Equal(t, []string{"linux/amd64"}, platforms)
+	assert.Len(t, result.Platforms, 1)
+	assert.Equal(t, "linux/arm64", result.Platforms[0].Platform)
+	assert.Equal(t, statusFailed, result.Platforms[0].Status)
+	assert.Len(t, result.failedPlatforms(), 1)
+}
+
+func TestPlatformsOfExternalParentAreNotVerified(t *testing.T) {
+	// given
+	engine := setupPlatformsTest(nil, "linux/amd64", "linux/arm64")
+	base := engine.hierarchy.GetImageByName("base")
+	result := &CommandResult{Name: "base"}
+
+	// when
+	platforms := engine.resolvePlatforms(base, result)
+
+	// then
+	assert.Equal(t, []string{"linux/amd64", "linux/arm64"}, platforms)
+	assert.Empty(t, result.Platforms)
+}
+
+func TestPlatformsDefinedPerImage(t *testing.T) {
+	// given
+	engine := setupPlatformsTest(map[string]*ImageConfig{"base": {Platforms: []string{"linux/amd64"}}}, "linux/amd64", "linux/arm64")
+	app := engine.hierarchy.GetImageByName("app")
+	result := &CommandResult{Name: "app"}
+
+	// when
+	platforms := engine.resolvePlatforms(app, result)
+	result.completePlatforms(platforms)
+
+	// then
+	assert.Equal(t, []string{"linux/amd64"}, platforms)
+	assert.NoError(t, result.platformsError())
+	assert.Equal(t, " [linux/arm64: failed, linux/amd64: success]", result.platformsSummary())
+}
@@ -0,0 +1,58 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+	"time"
+)
+
+// command is the process started by the bakery. Once the command is waited for, pending kill of its process tree
+// is stopped and the terminal is given back to the bakery.
+type command struct {
+	*exec.Cmd
+	// killTimer kills the process tree when it does not exit within the grace period after SIGTERM
+	killTimer *time.Timer
+	// foregroundGroup is the process group the terminal is given back to, zero when the command is not in the foreground
+	foregroundGroup int
+}
+
+// Start starts the command, terminal is given back to the bakery when the command could not be started.
+func (c *command) Start() error {
+	err := c.Cmd.Start()
+	if err != nil {
+		releaseTerminal(c)
+	}
+	return err
+}
+
+// Wait waits for the command to exit. Process group of the exited command may be reused,
+// so it must not be killed after the command was waited for.
+func (c *command) Wait() error {
+	err := c.Cmd.Wait()
+	// the timer is set by Cancel that always returns before the embedded Wait does
+	if c.killTimer != nil {
+		c.killTimer.Stop()
+	}
+	releaseTerminal(c)
+	return err
+}
+
+// Run starts the command and waits for it to exit.
+func (c *command) Run() error {
+	if err := c.Start(); err != nil {
+		return err
+	}
+	return c.Wait()
+}
+
+// Output runs the command and returns its standard output.
+func (c *command) Output() ([]byte, error) {
+	if c.Stdout != nil {
+		return nil, errors.New("exec: Stdout already set")
+	}
+	var stdout bytes.Buffer
+	c.Stdout = &stdout
+	err := c.Run()
+	return stdout.Bytes(), err
+}
@@ -0,0 +1,71 @@
+//go:build !windows
+
+package service
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// startInProcessGroup makes the command start its own process group, so the command can be stopped along with its children.
+func startInProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// attachStdin connects the command to the stdin. Command reading from the terminal is placed in the foreground,
+// as it would be stopped with SIGTTIN otherwise (e.g. when asking for the credentials). The command keeps its own
+// process group, so it is still stopped along with its children on cancellation.
+func attachStdin(cmd *command) {
+	cmd.Stdin = os.Stdin
+	if !isTerminal(os.Stdin) || cmd.SysProcAttr == nil {
+		return
+	}
+	foregroundGroup, err := unix.IoctlGetInt(int(os.Stdin.Fd()), unix.TIOCGPGRP)
+	// terminal is not taken over when the bakery itself runs in the background
+	if err != nil || foregroundGroup != syscall.Getpgrp() {
+		return
+	}
+	cmd.SysProcAttr.Foreground = true
+	cmd.SysProcAttr.Ctty = int(os.Stdin.Fd())
+	cmd.foregroundGroup = foregroundGroup
+}
+
+// releaseTerminal gives the terminal back to the process group of the bakery once the command placed in the foreground exits.
+// Interruption (Ctrl+C) was delivered only to the foreground command, so it is forwarded to the bakery.
+func releaseTerminal(cmd *command) {
+	if cmd.foregroundGroup == 0 {
+		return
+	}
+	// bakery is in the background group until the terminal is given back, it would be stopped with SIGTTOU otherwise
+	signal.Ignore(syscall.SIGTTOU)
+	defer signal.Reset(syscall.SIGTTOU)
+	unix.IoctlSetPointerInt(int(os.Stdin.Fd()), unix.TIOCSPGRP, cmd.foregroundGroup)
+
+	if cmd.ProcessState == nil {
+		return
+	}
+	if status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok && status.Signaled() && status.Signal() == syscall.SIGINT {
+		syscall.Kill(os.Getpid(), syscall.SIGINT)
+	}
+}
+
+// terminateProcessTree sends SIGTERM to the process group of the command.
+func terminateProcessTree(cmd *exec.Cmd) error {
+	return signalProcessTree(cmd, syscall.SIGTERM)
+}
+
+// killProcessTree kills the process group of the command.
+func killProcessTree(cmd *exec.Cmd) error {
+	return signalProcessTree(cmd, syscall.SIGKILL)
+}
+
+func signalProcessTree(cmd *exec.Cmd, signal syscall.Signal) error {
+	if cmd.SysProcAttr == nil || !cmd.SysProcAttr.Setpgid {
+		return cmd.Process.Signal(signal)
+	}
+	return syscall.Kill(-cmd.Process.Pid, signal)
+}
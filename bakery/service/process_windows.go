@@ -0,0 +1,32 @@
+//go:build windows
+
+package service
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+)
+
+// startInProcessGroup does nothing on Windows, the process tree is found by taskkill.
+func startInProcessGroup(cmd *exec.Cmd) {
+}
+
+// attachStdin connects the command to the stdin.
+func attachStdin(cmd *command) {
+	cmd.Stdin = os.Stdin
+}
+
+// releaseTerminal does nothing on Windows, console is shared by all processes attached to it.
+func releaseTerminal(cmd *command) {
+}
+
+// terminateProcessTree kills the process tree as console processes can't be asked to exit on Windows.
+func terminateProcessTree(cmd *exec.Cmd) error {
+	return killProcessTree(cmd)
+}
+
+// killProcessTree kills the process along with its children.
+func killProcessTree(cmd *exec.Cmd) error {
+	return exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid)).Run()
+}
@@ -0,0 +1,211 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	queryFormatText = "text"
+	queryFormatJSON = "json"
+)
+
+// Descendant is an image rebuilt after its ancestor is bumped, depth 1 stands for the direct dependant.
+// Images excluded from the automatic builds are listed, but their dependants are not as they are not rebuilt either.
+type Descendant struct {
+	*StructureImage
+	Depth int `json:"depth"`
+}
+
+// Relation describes how two images are linked in the hierarchy. Path leads from the first image to the second one
+// through their closest common ancestor, it is empty when the images are not related.
+type Relation struct {
+	From           string   `json:"from"`
+	To             string   `json:"to"`
+	CommonAncestor string   `json:"commonAncestor,omitempty"`
+	Path           []string `json:"path"`
+}
+
+// findAncestors returns the parent chain of the image, starting from its parent up to the external root image.
+func (e *Engine) findAncestors(imageName string) ([]*StructureImage, error) {
+	images, err := e.structureImagesWith(imageName)
+	if err != nil {
+		return nil, err
+	}
+	ancestors := make([]*StructureImage, 0)
+	for _, name := range ancestorNames(images, imageName)[1:] {
+		ancestors = append(ancestors, images[name])
+	}
+	return ancestors, nil
+}
+
+// findDescendants returns images rebuilt after the image is bumped in the order they appear in the hierarchy tree.
+// Depth limits how deep the descendants are searched, zero stands for no limit.
+func (e *Engine) findDescendants(imageName string, depth int) ([]*Descendant, error) {
+	if _, err := e.structureImagesWith(imageName); err != nil {
+		return nil, err
+	}
+	children := e.getStructure().children()
+	descendants := make([]*Descendant, 0)
+	visited := map[string]bool{imageName: true}
+	var collect func(name string, level int)
+	collect = func(name string, level int) {
+		if depth > 0 && level > depth {
+			return
+		}
+		for _, child := range children[name] {
+			if visited[child.Name] {
+				continue
+			}
+			visited[child.Name] = true
+			descendants = append(descendants, &Descendant{StructureImage: child, Depth: level})
+			if !child.Excluded {
+				collect(child.Name, level+1)
+			}
+		}
+	}
+	collect(imageName, 1)
+	return descendants, nil
+}
+
+// findRelation returns the relation linking two images of the hierarchy.
+func (e *Engine) findRelation(from, to string) (*Relation, error) {
+	images, err := e.structureImagesWith(from, to)
+	if err != nil {
+		return nil, err
+	}
+	relation := &Relation{From: from, To: to, Path: make([]string, 0)}
+	fromChain := ancestorNames(images, from)
+	toChain := ancestorNames(images, to)
+	for i, name := range fromChain {
+		for j := range toChain {
+			if toChain[j] != name {
+				continue
+			}
+			relation.CommonAncestor = name
+			relation.Path = append(relation.Path, fromChain[:i+1]...)
+			for k := j - 1; k >= 0; k-- {
+				relation.Path = append(relation.Path, toChain[k])
+			}
+			return relation, nil
+		}
+	}
+	return relation, nil
+}
+
+// structureImagesWith returns images of the structure by their names making sure the provided images are among them.
+func (e *Engine) structureImagesWith(imageNames ...string) (map[string]*StructureImage, error) {
+	images := make(map[string]*StructureImage)
+	for _, image := range e.getStructure().Images {
+		images[image.Name] = image
+	}
+	for _, name := range imageNames {
+		if _, exists := images[name]; !exists {
+			return nil, fmt.Errorf("image %s does not exist in the hierarchy", name)
+		}
+	}
+	return images, nil
+}
+
+// ancestorNames returns names of the image and its ancestors, up to the root image.
+func ancestorNames(images map[string]*StructureImage, imageName string) []string {
+	names := []string{imageName}
+	visited := map[string]bool{imageName: true}
+	for current := images[imageName]; current.Parent != "" && !visited[current.Parent]; current = images[current.Parent] {
+		visited[current.Parent] = true
+		names = append(names, current.Parent)
+	}
+	return names
+}
+
+// printAncestors prints the parent chain of the image in the text or json format.
+func (e *Engine) printAncestors(imageName, format string) error {
+	ancestors, err := e.findAncestors(imageName)
+	if err != nil {
+		return err
+	}
+	return e.printQueryResult(format, ancestors, func() string {
+		lines := []string{fmt.Sprintf("Ancestors of %s:", imageName)}
+		for _, ancestor := range ancestors {
+			lines = append(lines, "  "+treeItemName(ancestor))
+		}
+		return strings.Join(lines, "\n")
+	})
+}
+
+// printDescendants prints images rebuilt after the image is bumped in the text or json format.
+func (e *Engine) printDescendants(imageName string, depth int, format string) error {
+	descendants, err := e.findDescendants(imageName, depth)
+	if err != nil {
+		return err
+	}
+	return e.printQueryResult(format, descendants, func() string {
+		lines := []string{fmt.Sprintf("Descendants of %s:", imageName)}
+		for _, descendant := range descendants {
+			line := strings.Repeat("  ", descendant.Depth) + treeItemName(descendant.StructureImage)
+			if descendant.Excluded {
+				line += " - not rebuilt automatically, neither are its dependants"
+			}
+			lines = append(lines, line)
+		}
+		return strings.Join(lines, "\n")
+	})
+}
+
+// printWhy prints the path linking two images in the text or json format.
+func (e *Engine) printWhy(from, to, format string) error {
+	relation, err := e.findRelation(from, to)
+	if err != nil {
+		return err
+	}
+	return e.printQueryResult(format, relation, relation.String)
+}
+
+// String describes the relation with the paths leading from the common ancestor to the images.
+func (r *Relation) String() string {
+	if r.CommonAncestor == "" {
+		return fmt.Sprintf("%s and %s are not related", r.From, r.To)
+	}
+	if r.From == r.To {
+		return fmt.Sprintf("%s and %s are the same image", r.From, r.To)
+	}
+	fromPath, toPath := r.branches()
+	switch r.CommonAncestor {
+	case r.From:
+		return fmt.Sprintf("%s descends from %s: %s", r.To, r.From, strings.Join(toPath, " -> "))
+	case r.To:
+		return fmt.Sprintf("%s descends from %s: %s", r.From, r.To, strings.Join(fromPath, " -> "))
+	default:
+		return fmt.Sprintf("%s and %s share the ancestor %s: %s and %s", r.From, r.To, r.CommonAncestor,
+			strings.Join(fromPath, " -> "), strings.Join(toPath, " -> "))
+	}
+}
+
+// branches splits the path into paths leading from the common ancestor to the first and to the second image.
+func (r *Relation) branches() ([]string, []string) {
+	fromPath := make([]string, 0)
+	i := 0
+	for ; r.Path[i] != r.CommonAncestor; i++ {
+		fromPath = append([]string{r.Path[i]}, fromPath...)
+	}
+	fromPath = append([]string{r.CommonAncestor}, fromPath...)
+	toPath := append([]string{}, r.Path[i:]...)
+	return fromPath, toPath
+}
+
+func (e *Engine) printQueryResult(format string, result interface{}, text func() string) error {
+	switch format {
+	case "", queryFormatText:
+		fmt.Fprintln(e.stdout, text())
+	case queryFormatJSON:
+		data, err := json.MarshalIndent(result, "", "\t")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(e.stdout, string(data))
+	default:
+		return fmt.Errorf("unknown output format %s, expecting one of: %s, %s", format, queryFormatText, queryFormatJSON)
+	}
+	return nil
+}
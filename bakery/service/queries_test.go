@@ -0,0 +1,100 @@
+package service
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/Masterminds/semver"
+	"github.com/stretchr/testify/assert"
+)
+
+func givenQueriedHierarchy(t *testing.T) *Engine {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "base", dockerFileTemplateName), "FROM ubuntu:20.04\n")
+	writeTestFile(t, filepath.Join(dir, "app", dockerFileTemplateName), "FROM base:{{.BASE_VERSION}}\n")
+	writeTestFile(t, filepath.Join(dir, "web", dockerFileTemplateName), "FROM app:{{.APP_VERSION}}\n")
+	writeTestFile(t, filepath.Join(dir, "worker", dockerFileTemplateName), "FROM base:{{.BASE_VERSION}}\n")
+	writeTestFile(t, filepath.Join(dir, "cron", dockerFileTemplateName), "FROM worker:{{.WORKER_VERSION}}\n")
+	writeTestFile(t, filepath.Join(dir, "tools", dockerFileTemplateName), "FROM alpine:3\n")
+
+	engine := givenTestEngine(&Config{RootDir: dir, AutoBuildExcludes: []string{"worker"}})
+	assert.NoError(t, engine.hierarchy.AnalyzeStructure(dir, map[string]*semver.Version{}, nil))
+	return engine
+}
+
+func names(images []*StructureImage) []string {
+	result := make([]string, 0, len(images))
+	for _, image := range images {
+		result = append(result, image.Name)
+	}
+	return result
+}
+
+func TestAncestors(t *testing.T) {
+	engine := givenQueriedHierarchy(t)
+
+	ancestors, err := engine.findAncestors("web")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"app", "base", "ubuntu"}, names(ancestors))
+	assert.True(t, ancestors[2].External)
+
+	ancestors, err = engine.findAncestors("ubuntu")
+	assert.NoError(t, err)
+	assert.Empty(t, ancestors)
+
+	_, err = engine.findAncestors("missing")
+	assert.EqualError(t, err, "image missing does not exist in the hierarchy")
+}
+
+func TestDescendants(t *testing.T) {
+	engine := givenQueriedHierarchy(t)
+
+	descendants, err := engine.findDescendants("ubuntu", 0)
+	assert.NoError(t, err)
+	result := make([]string, 0)
+	for _, d := range descendants {
+		result = append(result, fmt.Sprintf("%s:%d", d.Name, d.Depth))
+	}
+	// cron is not rebuilt as its parent is excluded from the automatic builds
+	assert.Equal(t, []string{"base:1", "app:2", "web:3", "worker:2"}, result)
+
+	descendants, err = engine.findDescendants("base", 1)
+	assert.NoError(t, err)
+	assert.Len(t, descendants, 2)
+	assert.Equal(t, "app", descendants[0].Name)
+	assert.Equal(t, "worker", descendants[1].Name)
+	assert.True(t, descendants[1].Excluded)
+}
+
+func TestWhy(t *testing.T) {
+	engine := givenQueriedHierarchy(t)
+
+	testCases := []struct {
+		from, to string
+		expected Relation
+		text     string
+	}{
+		{"base", "web", Relation{CommonAncestor: "base", Path: []string{"base", "app", "web"}}, "web descends from base: base -> app -> web"},
+		{"web", "base", Relation{CommonAncestor: "base", Path: []string{"web", "app", "base"}}, "web descends from base: base -> app -> web"},
+		{"web", "worker", Relation{CommonAncestor: "base", Path: []string{"web", "app", "base", "worker"}}, "web and worker share the ancestor base: base -> app -> web and base -> worker"},
+		{"web", "tools", Relation{Path: []string{}}, "web and tools are not related"},
+		{"web", "web", Relation{CommonAncestor: "web", Path: []string{"web"}}, "web and web are the same image"},
+	}
+	for _, tc := range testCases {
+		relation, err := engine.findRelation(tc.from, tc.to)
+		assert.NoError(t, err)
+		tc.expected.From, tc.expected.To = tc.from, tc.to
+		assert.Equal(t, &tc.expected, relation)
+		assert.Equal(t, tc.text, relation.String())
+	}
+
+	_, err := engine.findRelation("web", "missing")
+	assert.EqualError(t, err, "image missing does not exist in the hierarchy")
+}
+
+func TestPrintQueryResultInUnknownFormat(t *testing.T) {
+	err := givenTestEngine(&Config{}).printQueryResult("yaml", nil, func() string { return "" })
+
+	assert.EqualError(t, err, "unknown output format yaml, expecting one of: text, json")
+}
@@ -0,0 +1,153 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// base64 encoded empty json object, docker daemon requires X-Registry-Auth header to be present during push
+	emptyRegistryAuth = "e30="
+	dockerHubRegistry = "docker.io"
+	// server address of the Docker Hub used by the docker CLI in the config file and in the credential helpers
+	dockerHubServerAddress = "https://index.docker.io/v1/"
+	// message of the credential helpers when there are no credentials stored for the server
+	credentialsNotFoundMessage = "credentials not found"
+)
+
+// dockerCLIConfig is the part of the docker CLI config file (~/.docker/config.json) holding the registry credentials.
+type dockerCLIConfig struct {
+	Auths map[string]struct {
+		Auth          string `json:"auth"`
+		IdentityToken string `json:"identitytoken"`
+	} `json:"auths"`
+	CredsStore  string            `json:"credsStore"`
+	CredHelpers map[string]string `json:"credHelpers"`
+}
+
+// registryAuth returns value of the X-Registry-Auth header for the repository. Credentials are taken from the docker CLI
+// config file: from the credential helper configured for the registry in credHelpers, from the credsStore helper or
+// from the auths section. Registry host has to match exactly, empty auth is used when no credentials are found.
+func (e *Engine) registryAuth(ctx context.Context, repository string) (string, error) {
+	registry := repositoryRegistry(repository)
+	dockerConfig, err := readDockerCLIConfig()
+	if err != nil {
+		return "", err
+	}
+
+	helper := dockerConfig.CredsStore
+	for server, serverHelper := range dockerConfig.CredHelpers {
+		if normalizeRegistryHost(server) == registry {
+			helper = serverHelper
+		}
+	}
+	if helper != "" {
+		return e.credentialHelperAuth(ctx, helper, registry)
+	}
+
+	for server, entry := range dockerConfig.Auths {
+		if normalizeRegistryHost(server) != registry {
+			continue
+		}
+		if entry.IdentityToken != "" {
+			return encodeRegistryAuth(map[string]string{"identitytoken": entry.IdentityToken, "serveraddress": server}), nil
+		}
+		decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+		if err != nil {
+			return "", fmt.Errorf("invalid auth of %s in the docker config: %s", server, err)
+		}
+		credentials := strings.SplitN(string(decoded), ":", 2)
+		if len(credentials) != 2 {
+			return "", fmt.Errorf("invalid auth of %s in the docker config: expecting username:password", server)
+		}
+		return encodeRegistryAuth(map[string]string{"username": credentials[0], "password": credentials[1], "serveraddress": server}), nil
+	}
+	return emptyRegistryAuth, nil
+}
+
+// repositoryRegistry returns host of the registry the repository belongs to, repositories without the registry host
+// belong to the Docker Hub.
+func repositoryRegistry(repository string) string {
+	if parts := strings.SplitN(repository, "/", 2); len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		return normalizeRegistryHost(parts[0])
+	}
+	return dockerHubRegistry
+}
+
+// normalizeRegistryHost strips the scheme and the path from the server address, Docker Hub aliases are normalized to docker.io.
+func normalizeRegistryHost(server string) string {
+	host := strings.TrimPrefix(strings.TrimPrefix(server, "https://"), "http://")
+	host = strings.SplitN(host, "/", 2)[0]
+	switch host {
+	case "index.docker.io", "registry-1.docker.io":
+		return dockerHubRegistry
+	}
+	return host
+}
+
+func readDockerCLIConfig() (*dockerCLIConfig, error) {
+	dockerConfigDir := os.Getenv("DOCKER_CONFIG")
+	if dockerConfigDir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return &dockerCLIConfig{}, nil
+		}
+		dockerConfigDir = filepath.Join(home, ".docker")
+	}
+	configFile := filepath.Join(dockerConfigDir, "config.json")
+	content, err := os.ReadFile(configFile)
+	if os.IsNotExist(err) {
+		return &dockerCLIConfig{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read docker config %s: %s", configFile, err)
+	}
+	dockerConfig := &dockerCLIConfig{}
+	if err = json.Unmarshal(content, dockerConfig); err != nil {
+		return nil, fmt.Errorf("unable to parse docker config %s: %s", configFile, err)
+	}
+	return dockerConfig, nil
+}
+
+// credentialHelperAuth obtains the credentials of the registry with the docker-credential-<helper> program.
+func (e *Engine) credentialHelperAuth(ctx context.Context, helper, registry string) (string, error) {
+	serverAddress := registry
+	if registry == dockerHubRegistry {
+		serverAddress = dockerHubServerAddress
+	}
+	helperCmd := e.newCommand(ctx, "docker-credential-"+helper, "get")
+	helperCmd.Stdin = strings.NewReader(serverAddress)
+	stderrOutput := &bytes.Buffer{}
+	helperCmd.Stderr = stderrOutput
+	out, err := helperCmd.Output()
+	if err != nil {
+		message := strings.TrimSpace(string(out) + stderrOutput.String())
+		if strings.Contains(message, credentialsNotFoundMessage) {
+			return emptyRegistryAuth, nil
+		}
+		return "", fmt.Errorf("unable to get credentials of %s from docker-credential-%s: %s %s", registry, helper, err, message)
+	}
+
+	var credentials struct {
+		Username string `json:"Username"`
+		Secret   string `json:"Secret"`
+	}
+	if err = json.Unmarshal(out, &credentials); err != nil {
+		return "", fmt.Errorf("unable to parse credentials of %s returned by docker-credential-%s: %s", registry, helper, err)
+	}
+	if credentials.Username == "<token>" {
+		return encodeRegistryAuth(map[string]string{"identitytoken": credentials.Secret, "serveraddress": serverAddress}), nil
+	}
+	return encodeRegistryAuth(map[string]string{"username": credentials.Username, "password": credentials.Secret, "serveraddress": serverAddress}), nil
+}
+
+func encodeRegistryAuth(authConfig map[string]string) string {
+	data, _ := json.Marshal(authConfig)
+	return base64.URLEncoding.EncodeToString(data)
+}
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func givenDockerConfig(t *testing.T, content string) string {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "config.json"), content)
+	t.Setenv("DOCKER_CONFIG", dir)
+	return dir
+}
+
+func decodeRegistryAuth(t *testing.T, auth string) map[string]string {
+	data, err := base64.URLEncoding.DecodeString(auth)
+	assert.NoError(t, err)
+	authConfig := make(map[string]string)
+	assert.NoError(t, json.Unmarshal(data, &authConfig))
+	return authConfig
+}
+
+func TestRegistryAuthMatchesRegistryHostExactly(t *testing.T) {
+	// given
+	givenDockerConfig(t, `{"auths": {
+		"myreg.io": {"auth": "`+base64.StdEncoding.EncodeToString([]byte("other:secret"))+`"},
+		"https://reg.io/v2/": {"auth": "`+base64.StdEncoding.EncodeToString([]byte("user:pass"))+`"},
+		"https://index.docker.io/v1/": {"identitytoken": "hub-token"}
+	}}`)
+
+	engine := givenTestEngine(&Config{})
+
+	// when
+	regAuth, regErr := engine.registryAuth(context.Background(), "reg.io/team/app")
+	hubAuth, hubErr := engine.registryAuth(context.Background(), "team/app")
+	unknownAuth, unknownErr := engine.registryAuth(context.Background(), "eg.io/team/app")
+
+	// then
+	assert.NoError(t, regErr)
+	assert.Equal(t, map[string]string{"username": "user", "password": "pass", "serveraddress": "https://reg.io/v2/"}, decodeRegistryAuth(t, regAuth))
+	assert.NoError(t, hubErr)
+	assert.Equal(t, map[string]string{"identitytoken": "hub-token", "serveraddress": "https://index.docker.io/v1/"}, decodeRegistryAuth(t, hubAuth))
+	assert.NoError(t, unknownErr)
+	assert.Equal(t, emptyRegistryAuth, unknownAuth)
+}
+
+func TestRegistryAuthUsesCredentialHelpers(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("credential helper stub is a shell script")
+	}
+	// given
+	binDir := t.TempDir()
+	writeTestFile(t, filepath.Join(binDir, "docker-credential-stub"), `#!/bin/sh
+read server
+case "$server" in
+  reg.io) echo '{"ServerURL":"reg.io","Username":"helper-user","Secret":"helper-pass"}' ;;
+  broken.io) echo "keychain is locked" >&2; exit 1 ;;
+  *) echo "credentials not found in native keychain"; exit 1 ;;
+esac
+`)
+	assert.NoError(t, os.Chmod(filepath.Join(binDir, "docker-credential-stub"), 0755))
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	givenDockerConfig(t, `{"credsStore": "missing", "credHelpers": {"reg.io": "stub", "other.io": "stub", "broken.io": "stub"}}`)
+	engine := givenTestEngine(&Config{})
+
+	// when
+	auth, err := engine.registryAuth(context.Background(), "reg.io/team/app")
+	notFoundAuth, notFoundErr := engine.registryAuth(context.Background(), "other.io/team/app")
+	_, brokenErr := engine.registryAuth(context.Background(), "broken.io/team/app")
+	_, missingHelperErr := engine.registryAuth(context.Background(), "docker.io/team/app")
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"username": "helper-user", "password": "helper-pass", "serveraddress": "reg.io"}, decodeRegistryAuth(t, auth))
+	assert.NoError(t, notFoundErr)
+	assert.Equal(t, emptyRegistryAuth, notFoundAuth)
+	assert.EqualError(t, brokenErr, "unable to get credentials of broken.io from docker-credential-stub: exit status 1 keychain is locked")
+	assert.ErrorContains(t, missingHelperErr, "unable to get credentials of docker.io from docker-credential-missing")
+}
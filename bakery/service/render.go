@@ -0,0 +1,130 @@
+package service
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+
+	"github.com/fatih/color"
+	"github.com/smartrecruiters/docker-bakery/bakery/commons"
+)
+
+// machineSpecificProperties differ between the machines and runs rendering the same templates,
+// therefore they are rendered as volatile when the Dockerfiles of all images are filled or checked.
+var machineSpecificProperties = []string{
+	buildDatePropName,
+	buildTimestampPropName,
+	gitRevisionPropName,
+	builderNamePropName,
+	builderEmailPropName,
+	builderHostPropName,
+	signatureValuePropName,
+	signatureEnvsPropName,
+}
+
+// fillAllTemplates renders Dockerfiles of all images in the hierarchy next to their templates.
+// Dockerfiles are rendered reproducibly (see reproducibleProperties), so the check gives the same result on every machine.
+// In check mode nothing is written, differences between the existing Dockerfiles and the rendered ones are printed instead
+// and an error is returned when any Dockerfile is out of date.
+func (e *Engine) fillAllTemplates(check bool) error {
+	outdated := 0
+	for _, dockerImage := range e.sortedImages() {
+		dockerfile := path.Join(dockerImage.DockerfileDir, dockerfileName)
+		if !check {
+			if err := e.fillTemplateFile(dockerImage.DockerfilePath, dockerfile, true); err != nil {
+				return err
+			}
+			continue
+		}
+
+		differs, err := e.checkDockerfile(dockerImage.DockerfilePath, dockerfile)
+		if err != nil {
+			return err
+		}
+		if differs {
+			outdated++
+		}
+	}
+
+	if outdated > 0 {
+		return fmt.Errorf("%d Dockerfile(s) differ from their templates, run fill-template --all to update them", outdated)
+	}
+	if check {
+		fmt.Fprintf(e.stdout, color.GreenString("All Dockerfiles are up to date with their templates\n"))
+	}
+	return nil
+}
+
+// checkTemplate compares the Dockerfile with the one rendered from its template and returns an error
+// (after printing the unified diff) when they differ.
+func (e *Engine) checkTemplate(inputFile, outputFile string) error {
+	inputFile, err := e.resolveTemplateFile(inputFile)
+	if err != nil {
+		return err
+	}
+
+	differs, err := e.checkDockerfile(inputFile, outputFile)
+	if err != nil {
+		return err
+	}
+	if differs {
+		return fmt.Errorf("%s differs from its template %s, run fill-template to update it", outputFile, inputFile)
+	}
+	fmt.Fprintf(e.stdout, color.GreenString("%s is up to date with its template\n", outputFile))
+	return nil
+}
+
+// checkDockerfile prints the unified diff between the existing Dockerfile and the one rendered from the template.
+// Returns whenever the Dockerfile differs, missing Dockerfile is treated as empty one.
+func (e *Engine) checkDockerfile(templateFile, dockerfile string) (bool, error) {
+	rendered, err := e.renderDockerfile(templateFile, true)
+	if err != nil {
+		return false, err
+	}
+	existing, err := ioutil.ReadFile(dockerfile)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+
+	diff := commons.UnifiedDiff(dockerfile, fmt.Sprintf("%s (rendered from %s)", dockerfile, templateFile), string(existing), string(rendered))
+	if diff == "" {
+		return false, nil
+	}
+	fmt.Fprintf(e.stdout, color.RedString("%s is out of date:\n", dockerfile))
+	fmt.Fprint(e.stdout, e.config.mask(diff))
+	return true, nil
+}
+
+// reproducibleProperties returns the properties with the dynamic properties of the image set (when the template
+// belongs to the image from the hierarchy) and the machine specific ones neutralized, unless they are provided with the -p flag.
+// Image is not being built, so its latest version is used as the next one.
+func (e *Engine) reproducibleProperties(dockerImage *DockerImage) map[string]string {
+	if dockerImage != nil {
+		dockerImage.nextVersion = *dockerImage.GetLatestVersion()
+		e.updateDynamicProperties(dockerImage)
+	}
+	properties := make(map[string]string, len(e.config.Properties))
+	for k, v := range e.config.Properties {
+		properties[k] = v
+	}
+	for _, p := range machineSpecificProperties {
+		if _, isRuntimeProperty := e.config.runtimeProperties[p]; !isRuntimeProperty {
+			properties[p] = volatilePropertyValue
+		}
+	}
+	return properties
+}
+
+// sortedImages returns images from the hierarchy sorted by name.
+func (e *Engine) sortedImages() []*DockerImage {
+	images := make([]*DockerImage, 0, len(e.hierarchy.GetImages()))
+	for _, dockerImage := range e.hierarchy.GetImages() {
+		images = append(images, dockerImage)
+	}
+	sort.Slice(images, func(i, j int) bool {
+		return images[i].Name < images[j].Name
+	})
+	return images
+}
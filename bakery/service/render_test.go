@@ -0,0 +1,73 @@
+package service
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/Masterminds/semver"
+	"github.com/stretchr/testify/assert"
+)
+
+func givenImagesToRender(t *testing.T) (*Engine, string) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "base", dockerFileTemplateName), "FROM ubuntu:20.04\nLABEL tier={{.TIER}} version={{.IMAGE_VERSION}}\n")
+	writeTestFile(t, filepath.Join(dir, "base", "app", dockerFileTemplateName), "FROM base:{{.BASE_VERSION}}\nLABEL name={{.IMAGE_NAME}} version={{.IMAGE_VERSION}} built-on={{.BAKERY_BUILDER_HOST}}\n")
+	versions := map[string]*semver.Version{"base": semver.MustParse("1.0.0")}
+	engine := givenTestEngine(&Config{RootDir: dir, Properties: map[string]string{"TIER": "backend"}})
+	engine.updateVersionProperties(versions)
+	assert.NoError(t, engine.hierarchy.AnalyzeStructure(dir, versions, nil))
+	return engine, dir
+}
+
+func TestFillAllTemplates(t *testing.T) {
+	engine, dir := givenImagesToRender(t)
+
+	assert.NoError(t, engine.fillAllTemplates(false))
+
+	base, err := ioutil.ReadFile(filepath.Join(dir, "base", dockerfileName))
+	assert.NoError(t, err)
+	assert.Equal(t, "FROM ubuntu:20.04\nLABEL tier=backend version=1.0.0\n", string(base))
+	app, err := ioutil.ReadFile(filepath.Join(dir, "base", "app", dockerfileName))
+	assert.NoError(t, err)
+	assert.Equal(t, "FROM base:1.0.0\nLABEL name=app version=0.0.0 built-on=<volatile>\n", string(app))
+}
+
+func TestCheckTemplatesReportsDrift(t *testing.T) {
+	// given
+	engine, dir := givenImagesToRender(t)
+	assert.NoError(t, engine.fillAllTemplates(false))
+	engine.config.Properties[builderHostPropName] = "other-host"
+	assert.NoError(t, engine.fillAllTemplates(true))
+
+	// when
+	engine.config.Properties["TIER"] = "frontend"
+	checkAllErr := engine.fillAllTemplates(true)
+	checkAppErr := engine.checkTemplate(filepath.Join(dir, "base", "app", dockerfileName), filepath.Join(dir, "base", "app", dockerfileName))
+	differs, err := engine.checkDockerfile(filepath.Join(dir, "base", dockerFileTemplateName), filepath.Join(dir, "base", dockerfileName))
+
+	// then
+	assert.EqualError(t, checkAllErr, "1 Dockerfile(s) differ from their templates, run fill-template --all to update them")
+	assert.NoError(t, checkAppErr)
+	assert.NoError(t, err)
+	assert.True(t, differs)
+}
+
+func TestFilledTemplatePassesTheCheck(t *testing.T) {
+	// given
+	engine, dir := givenImagesToRender(t)
+	engine.config.Properties[builderHostPropName] = "build-host"
+	template := filepath.Join(dir, "base", "app", dockerFileTemplateName)
+	dockerfile := filepath.Join(dir, "base", "app", dockerfileName)
+
+	// when
+	fillErr := engine.fillTemplate(template, dockerfile)
+	checkErr := engine.checkTemplate(template, dockerfile)
+
+	// then
+	assert.NoError(t, fillErr)
+	assert.NoError(t, checkErr)
+	content, err := ioutil.ReadFile(dockerfile)
+	assert.NoError(t, err)
+	assert.Equal(t, "FROM base:1.0.0\nLABEL name=app version=0.0.0 built-on=<volatile>\n", string(content))
+}
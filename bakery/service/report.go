@@ -0,0 +1,313 @@
+package service
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/smartrecruiters/docker-bakery/bakery/commons"
+)
+
+const (
+	reportFormatJSON     = "json"
+	reportFormatJUnit    = "junit"
+	reportFormatMarkdown = "markdown"
+	// used when the report format is selected while the config does not define the reportFileName
+	defaultReportFileName = "bakery-report.json"
+
+	// statusSkipped is the status of the dependants not processed because of autoBuildExcludes or the failure of their parent
+	statusSkipped = "skipped"
+	// statusExcluded is the status of the dependants not passing the image filter
+	statusExcluded = "excluded"
+)
+
+var reportFormats = []string{reportFormatJSON, reportFormatJUnit, reportFormatMarkdown}
+
+// setReportFormats validates and applies the formats of the report written after build or push.
+func (e *Engine) setReportFormats(formats []string) error {
+	for _, format := range formats {
+		if !commons.Contains(reportFormats, format) {
+			return fmt.Errorf("unsupported report format %s, expected one of: %s", format, strings.Join(reportFormats, ", "))
+		}
+	}
+	e.config.reportFormats = formats
+	return nil
+}
+
+// reportFiles returns report file names by the report format. JSON report is written to the reportFileName,
+// JUnit and Markdown reports next to it with the .xml and .md extension. No reports are written when the
+// reportFileName is not configured and no format is selected.
+func (e *Engine) reportFiles() map[string]string {
+	fileName := e.config.ReportFileName
+	formats := e.config.reportFormats
+	if len(formats) == 0 {
+		formats = []string{reportFormatJSON}
+	} else if fileName == "" {
+		fileName = defaultReportFileName
+	}
+	if fileName == "" {
+		return nil
+	}
+	baseName := strings.TrimSuffix(fileName, filepath.Ext(fileName))
+	files := make(map[string]string)
+	for _, format := range formats {
+		switch format {
+		case reportFormatJUnit:
+			files[format] = baseName + ".xml"
+		case reportFormatMarkdown:
+			files[format] = baseName + ".md"
+		default:
+			files[format] = fileName
+		}
+	}
+	return files
+}
+
+// writeReports writes the results in all selected report formats, secret values are masked.
+func (e *Engine) writeReports(results []*CommandResult, duration time.Duration) {
+	results = e.maskedResults(results)
+	files := e.reportFiles()
+	for _, format := range reportFormats {
+		fileName, selected := files[format]
+		if !selected {
+			continue
+		}
+		var err error
+		switch format {
+		case reportFormatJUnit:
+			err = os.WriteFile(fileName, e.junitReport(results, duration), 0644)
+		case reportFormatMarkdown:
+			err = os.WriteFile(fileName, markdownReport(results, duration), 0644)
+		default:
+			err = commons.WriteToJSONFile(results, fileName)
+		}
+		if err != nil {
+			fmt.Fprintf(e.stdout, "Unable to write %s report %s: %s\n", format, fileName, err)
+			continue
+		}
+		fmt.Fprintf(e.stdout, "Writing %s report %s\n", format, fileName)
+	}
+}
+
+// maskedResults returns copies of the results with the secret values masked, so they are masked before
+// being encoded (and possibly escaped) in the report.
+func (e *Engine) maskedResults(results []*CommandResult) []*CommandResult {
+	masked := make([]*CommandResult, 0, len(results))
+	for _, r := range results {
+		m := *r
+		m.Error = e.config.mask(r.Error)
+		m.Reason = e.config.mask(r.Reason)
+		m.Commands = make([]string, 0, len(r.Commands))
+		for _, command := range r.Commands {
+			m.Commands = append(m.Commands, e.config.mask(command))
+		}
+		m.Platforms = make([]*PlatformResult, 0, len(r.Platforms))
+		for _, p := range r.Platforms {
+			maskedPlatform := *p
+			maskedPlatform.Error = e.config.mask(p.Error)
+			m.Platforms = append(m.Platforms, &maskedPlatform)
+		}
+		masked = append(masked, &m)
+	}
+	return masked
+}
+
+// reportOperation returns name of the operation the results come from, used in the report titles.
+func reportOperation(results []*CommandResult) string {
+	for _, r := range results {
+		if r.Operation != "" {
+			return r.Operation
+		}
+	}
+	return buildOperationName
+}
+
+// statusCounts returns number of the results in every status, statuses are ordered by the first occurrence.
+func statusCounts(results []*CommandResult) ([]string, map[string]int) {
+	statuses := make([]string, 0)
+	counts := make(map[string]int)
+	for _, r := range results {
+		if counts[r.Status] == 0 {
+			statuses = append(statuses, r.Status)
+		}
+		counts[r.Status]++
+	}
+	return statuses, counts
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut *junitOutput  `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",cdata"`
+}
+
+type junitOutput struct {
+	Text string `xml:",cdata"`
+}
+
+// junitReport returns the results as the JUnit XML report with every image being a test case.
+// Failed images are failures, cancelled images errors, skipped and excluded images are skipped test cases.
+func (e *Engine) junitReport(results []*CommandResult, duration time.Duration) []byte {
+	operation := reportOperation(results)
+	suite := junitTestSuite{
+		Name:      "docker-bakery " + operation,
+		Time:      junitSeconds(duration),
+		Timestamp: e.startTime.UTC().Format("2006-01-02T15:04:05"),
+		TestCases: make([]junitTestCase, 0, len(results)),
+	}
+	for _, r := range results {
+		testCase := junitTestCase{
+			Name:      r.Name,
+			ClassName: "docker-bakery." + operation,
+			Time:      junitSeconds(time.Duration(r.DurationMs) * time.Millisecond),
+			SystemOut: &junitOutput{Text: resultDetails(r)},
+		}
+		switch r.Status {
+		case statusFailed:
+			testCase.Failure = &junitMessage{Message: r.Error, Text: strings.Join(r.Commands, "\n")}
+			suite.Failures++
+		case statusCancelled:
+			testCase.Error = &junitMessage{Message: statusCancelled + errorSuffix(r.Error)}
+			suite.Errors++
+		case statusSkipped, statusExcluded:
+			testCase.Skipped = &junitMessage{Message: r.Status + ": " + r.Reason}
+			suite.Skipped++
+		}
+		suite.TestCases = append(suite.TestCases, testCase)
+	}
+	suite.Tests = len(suite.TestCases)
+	suites := junitTestSuites{
+		Name:     "docker-bakery",
+		Tests:    suite.Tests,
+		Failures: suite.Failures,
+		Errors:   suite.Errors,
+		Skipped:  suite.Skipped,
+		Time:     suite.Time,
+		Suites:   []junitTestSuite{suite},
+	}
+	data, _ := xml.MarshalIndent(suites, "", "  ")
+	return append(append([]byte(xml.Header), data...), '\n')
+}
+
+// resultDetails describes versions, attempts and commands of the image, used as the test case output.
+func resultDetails(r *CommandResult) string {
+	version := r.CurrentVersion
+	if r.Status == statusSuccess {
+		version += " => " + r.NextVersion
+	}
+	details := fmt.Sprintf("%s %s (%s)%s\n", r.Name, version, r.Status, r.attemptsSummary())
+	for _, command := range r.Commands {
+		details += "Executed: " + command + "\n"
+	}
+	return details
+}
+
+func errorSuffix(err string) string {
+	if err == "" {
+		return ""
+	}
+	return ": " + err
+}
+
+func junitSeconds(duration time.Duration) string {
+	return fmt.Sprintf("%.3f", duration.Seconds())
+}
+
+var statusIcons = map[string]string{
+	statusSuccess:   "✅",
+	statusUpToDate:  "☑️",
+	statusFailed:    "❌",
+	statusCancelled: "⛔",
+	statusSkipped:   "⏭️",
+	statusExcluded:  "➖",
+}
+
+// markdownReport returns the results as the Markdown summary suitable for the pull request comment or $GITHUB_STEP_SUMMARY.
+func markdownReport(results []*CommandResult, duration time.Duration) []byte {
+	report := &bytes.Buffer{}
+	fmt.Fprintf(report, "## docker-bakery %s report\n\n", reportOperation(results))
+	statuses, counts := statusCounts(results)
+	summary := make([]string, 0, len(statuses))
+	for _, status := range statuses {
+		summary = append(summary, fmt.Sprintf("%d %s", counts[status], status))
+	}
+	fmt.Fprintf(report, "Processed %d image(s) in %v", len(results), duration.Round(time.Millisecond))
+	if len(summary) > 0 {
+		fmt.Fprintf(report, ": %s", strings.Join(summary, ", "))
+	}
+	fmt.Fprintf(report, "\n\n")
+	if len(results) == 0 {
+		return report.Bytes()
+	}
+
+	fmt.Fprintf(report, "| Image | Status | Version | Duration | Attempts | Details |\n")
+	fmt.Fprintf(report, "| --- | --- | --- | --- | --- | --- |\n")
+	for _, r := range results {
+		version := r.CurrentVersion
+		if r.Status == statusSuccess {
+			version = fmt.Sprintf("%s → %s", r.CurrentVersion, r.NextVersion)
+		}
+		durationCell, attempts := "", ""
+		if r.DurationMs > 0 {
+			durationCell = (time.Duration(r.DurationMs) * time.Millisecond).String()
+		}
+		if r.Attempts > 0 {
+			attempts = fmt.Sprint(r.Attempts)
+		}
+		details := r.Error
+		if details == "" {
+			details = r.Reason
+		}
+		fmt.Fprintf(report, "| %s | %s %s | %s | %s | %s | %s |\n", markdownCell(r.Name), statusIcons[r.Status], r.Status,
+			markdownCell(version), durationCell, attempts, markdownCell(details))
+	}
+
+	for _, r := range results {
+		if r.Status != statusFailed || len(r.Commands) == 0 {
+			continue
+		}
+		fmt.Fprintf(report, "\n<details><summary>Commands of %s</summary>\n\n```\n%s\n```\n</details>\n", r.Name, strings.Join(r.Commands, "\n"))
+	}
+	return report.Bytes()
+}
+
+// markdownCell escapes the value so it can be placed in the table cell.
+func markdownCell(value string) string {
+	value = strings.Replace(value, "|", "\\|", -1)
+	return strings.Replace(value, "\n", " ", -1)
+}
@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"encoding/xml"
+	"path/filepath"
+	"testing"
+
+	"github.com/Masterminds/semver"
+	"github.com/stretchr/testify/assert"
+)
+
+func givenReportResults() []*CommandResult {
+	return []*CommandResult{
+		{Name: "base", CurrentVersion: "1.0.0", NextVersion: "1.0.1", Status: statusSuccess, Operation: buildOperationName, DurationMs: 1500, Attempts: 2, Commands: []string{"docker build base"}},
+		{Name: "app", CurrentVersion: "2.0.0", NextVersion: "2.0.0", Status: statusFailed, Operation: buildOperationName, DurationMs: 300, Attempts: 1, Commands: []string{"docker build app"}, Error: "exit status 1"},
+		{Name: "web", CurrentVersion: "3.0.0", NextVersion: "3.0.0", Status: statusSkipped, Operation: buildOperationName, Reason: "parent app failed"},
+		{Name: "legacy|old", CurrentVersion: "0.1.0", NextVersion: "0.1.0", Status: statusExcluded, Operation: buildOperationName, Reason: "does not pass the image filter"},
+		{Name: "worker", CurrentVersion: "1.1.0", NextVersion: "1.1.0", Status: statusCancelled, Operation: buildOperationName},
+	}
+}
+
+func TestJUnitReport(t *testing.T) {
+	engine := givenTestEngine(&Config{})
+	var report junitTestSuites
+	assert.NoError(t, xml.Unmarshal(engine.junitReport(givenReportResults(), 0), &report))
+
+	assert.Equal(t, 5, report.Tests)
+	assert.Equal(t, 1, report.Failures)
+	assert.Equal(t, 1, report.Errors)
+	assert.Equal(t, 2, report.Skipped)
+	suite := report.Suites[0]
+	assert.Equal(t, "docker-bakery build", suite.Name)
+	assert.Equal(t, "1.500", suite.TestCases[0].Time)
+	assert.Equal(t, "base 1.0.0 => 1.0.1 (success) (2 attempts)\nExecuted: docker build base\n", suite.TestCases[0].SystemOut.Text)
+	assert.Equal(t, &junitMessage{Message: "exit status 1", Text: "docker build app"}, suite.TestCases[1].Failure)
+	assert.Equal(t, "skipped: parent app failed", suite.TestCases[2].Skipped.Message)
+	assert.Equal(t, "cancelled", suite.TestCases[4].Error.Message)
+}
+
+func TestMarkdownReport(t *testing.T) {
+	report := string(markdownReport(givenReportResults(), 0))
+
+	assert.Contains(t, report, "## docker-bakery build report\n\nProcessed 5 image(s) in 0s: 1 success, 1 failed, 1 skipped, 1 excluded, 1 cancelled\n")
+	assert.Contains(t, report, "| base | ✅ success | 1.0.0 → 1.0.1 | 1.5s | 2 |  |\n")
+	assert.Contains(t, report, "| app | ❌ failed | 2.0.0 | 300ms | 1 | exit status 1 |\n")
+	assert.Contains(t, report, "| legacy\\|old | ➖ excluded | 0.1.0 |  |  | does not pass the image filter |\n")
+	assert.Contains(t, report, "<details><summary>Commands of app</summary>\n\n```\ndocker build app\n```\n</details>\n")
+}
+
+func TestReportFiles(t *testing.T) {
+	engine := givenTestEngine(&Config{})
+	assert.Empty(t, engine.reportFiles())
+
+	assert.NoError(t, engine.setReportFormats([]string{reportFormatJUnit}))
+	assert.Equal(t, map[string]string{reportFormatJUnit: "bakery-report.xml"}, engine.reportFiles())
+
+	engine.config.ReportFileName = "out/report.json"
+	assert.NoError(t, engine.setReportFormats([]string{reportFormatJSON, reportFormatMarkdown}))
+	assert.Equal(t, map[string]string{reportFormatJSON: "out/report.json", reportFormatMarkdown: "out/report.md"}, engine.reportFiles())
+
+	assert.EqualError(t, engine.setReportFormats([]string{"html"}), "unsupported report format html, expected one of: json, junit, markdown")
+}
+
+func TestEngineReportsNotProcessedImages(t *testing.T) {
+	engine, _, builder, output := givenEngine(t, map[string]string{
+		"base":              "FROM ubuntu:20.04\n",
+		"base/app":          "FROM base:{{.BASE_VERSION}}\n",
+		"base/app/web":      "FROM app:{{.APP_VERSION}}\n",
+		"base/app/docs":     "FROM app:{{.APP_VERSION}}\n",
+		"base/app/old":      "FROM app:{{.APP_VERSION}}\n",
+		"base/tools":        "FROM base:{{.BASE_VERSION}}\n",
+		"base/tools/lint":   "FROM tools:{{.TOOLS_VERSION}}\n",
+		"base/legacy":       "FROM base:{{.BASE_VERSION}}\n",
+		"base/legacy/batch": "FROM legacy:{{.LEGACY_VERSION}}\n",
+	}, map[string]*semver.Version{})
+	engine.options.Config.AutoBuildExcludes = []string{"tools", "docs"}
+	engine.options.Filter = &ImageFilter{Exclude: []string{"legacy", "old"}}
+	assert.NoError(t, engine.InitConfiguration(context.Background()))
+	builder.failOn = "app"
+
+	assert.NoError(t, engine.BuildDockerfile(context.Background(), filepath.Join(engine.options.Config.RootDir, "base", dockerFileTemplateName), "patch", true))
+
+	results := make(map[string]*CommandResult)
+	for _, result := range engine.Results() {
+		results[result.Name] = result
+	}
+	assert.Len(t, results, 9)
+	assert.Equal(t, statusSuccess, results["base"].Status)
+	assert.Equal(t, statusFailed, results["app"].Status)
+	assert.Equal(t, "build of app failed", results["app"].Error)
+	assert.Equal(t, [2]string{statusSkipped, "parent app failed"}, [2]string{results["web"].Status, results["web"].Reason})
+	assert.Equal(t, [2]string{statusSkipped, "defined in the config autoBuildExcludes section"}, [2]string{results["docs"].Status, results["docs"].Reason})
+	assert.Equal(t, [2]string{statusExcluded, "does not pass the image filter"}, [2]string{results["old"].Status, results["old"].Reason})
+	assert.Equal(t, [2]string{statusSkipped, "defined in the config autoBuildExcludes section"}, [2]string{results["tools"].Status, results["tools"].Reason})
+	assert.Equal(t, [2]string{statusSkipped, "parent tools was not processed"}, [2]string{results["lint"].Status, results["lint"].Reason})
+	assert.Equal(t, [2]string{statusExcluded, "does not pass the image filter"}, [2]string{results["legacy"].Status, results["legacy"].Reason})
+	assert.Equal(t, [2]string{statusSkipped, "parent legacy was not processed"}, [2]string{results["batch"].Status, results["batch"].Reason})
+	assert.Equal(t, buildOperationName, results["web"].Operation)
+	assert.Contains(t, output.String(), "app 0.0.0 (failed)")
+	assert.Contains(t, output.String(), "web 0.0.0 (skipped: parent app failed)")
+}
+
+func TestReportsMaskSecretsBeforeEncoding(t *testing.T) {
+	engine := givenTestEngine(&Config{})
+	engine.config.addSecrets([]string{"s3<r3t"})
+	results := []*CommandResult{
+		{Name: "app", Status: statusFailed, Operation: pushOperationName, Commands: []string{"docker login -p s3<r3t"}, Error: "login with s3<r3t failed",
+			Platforms: []*PlatformResult{{Platform: "linux/amd64", Status: statusFailed, Error: "s3<r3t rejected"}}},
+	}
+
+	masked := engine.maskedResults(results)
+	report := string(engine.junitReport(masked, 0))
+
+	assert.NotContains(t, report, "s3")
+	assert.Contains(t, report, "docker login -p ******")
+	assert.Equal(t, "****** rejected", masked[0].Platforms[0].Error)
+	assert.Equal(t, "docker login -p s3<r3t", results[0].Commands[0])
+}
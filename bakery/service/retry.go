@@ -0,0 +1,145 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	defaultRetryBackoff = time.Second
+	buildOperationName  = "build"
+	pushOperationName   = "push"
+)
+
+// retrySettings are parsed values of the RetryPolicy.
+type retrySettings struct {
+	timeout time.Duration
+	retries int
+	backoff time.Duration
+}
+
+// overrideWith returns policy with the values defined in the override taking precedence.
+func (p RetryPolicy) overrideWith(override RetryPolicy) RetryPolicy {
+	if override.Timeout != "" {
+		p.Timeout = override.Timeout
+	}
+	if override.Retries != nil {
+		p.Retries = override.Retries
+	}
+	if override.Backoff != "" {
+		p.Backoff = override.Backoff
+	}
+	return p
+}
+
+// settings parses the policy, empty values fall back to the defaults.
+func (p RetryPolicy) settings() (retrySettings, error) {
+	settings := retrySettings{backoff: defaultRetryBackoff}
+	var err error
+	if p.Timeout != "" {
+		if settings.timeout, err = time.ParseDuration(p.Timeout); err != nil {
+			return settings, fmt.Errorf("invalid timeout %s: %s", p.Timeout, err)
+		}
+	}
+	if p.Backoff != "" {
+		if settings.backoff, err = time.ParseDuration(p.Backoff); err != nil {
+			return settings, fmt.Errorf("invalid backoff %s: %s", p.Backoff, err)
+		}
+	}
+	if p.Retries != nil {
+		if *p.Retries < 0 {
+			return settings, fmt.Errorf("invalid retries %d: can't be negative", *p.Retries)
+		}
+		settings.retries = *p.Retries
+	}
+	if settings.timeout < 0 || settings.backoff < 0 {
+		return settings, fmt.Errorf("timeout and backoff can't be negative")
+	}
+	return settings, nil
+}
+
+// validateRetryPolicies checks the global and per image policies, so they can be used later without checking errors.
+func (cfg *Config) validateRetryPolicies() error {
+	policies := map[string]RetryPolicy{buildOperationName: cfg.Build, pushOperationName: cfg.Push, "git": cfg.Git}
+	for imgName, imgCfg := range cfg.Images {
+		if imgCfg != nil {
+			policies[fmt.Sprintf("images.%s.%s", imgName, buildOperationName)] = imgCfg.Build
+			policies[fmt.Sprintf("images.%s.%s", imgName, pushOperationName)] = imgCfg.Push
+		}
+	}
+	for _, name := range sortedPolicyNames(policies) {
+		if _, err := policies[name].settings(); err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+func sortedPolicyNames(policies map[string]RetryPolicy) []string {
+	names := make([]string, 0, len(policies))
+	for name := range policies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// imageRetrySettings returns settings of the build or push of the image, image settings take precedence over the global ones.
+func (cfg *Config) imageRetrySettings(operation, imageName string) retrySettings {
+	policy := cfg.Build
+	if operation == pushOperationName {
+		policy = cfg.Push
+	}
+	if imgCfg, ok := cfg.Images[imageName]; ok && imgCfg != nil {
+		if operation == pushOperationName {
+			policy = policy.overrideWith(imgCfg.Push)
+		} else {
+			policy = policy.overrideWith(imgCfg.Build)
+		}
+	}
+	// policies are validated when the config is prepared
+	settings, _ := policy.settings()
+	return settings
+}
+
+// gitRetrySettings returns settings of the git commands.
+func (cfg *Config) gitRetrySettings() retrySettings {
+	settings, _ := cfg.Git.settings()
+	return settings
+}
+
+// withRetries executes the operation until it succeeds or the retries are exhausted, backoff is doubled after every attempt.
+// Every attempt is limited by the timeout, commands of the timed out attempt are killed along with their child processes.
+// Returns number of attempts made and the error of the last one. Cancellation of the context stops the retries.
+func (e *Engine) withRetries(ctx context.Context, settings retrySettings, description string, operation func(ctx context.Context) error) (int, error) {
+	backoff := settings.backoff
+	for attempt := 1; ; attempt++ {
+		err := runAttempt(ctx, settings.timeout, operation)
+		if err == nil || ctx.Err() != nil || attempt > settings.retries {
+			return attempt, err
+		}
+		fmt.Fprintf(e.stdout, "%s failed (attempt %d of %d): %s, retrying in %v\n", description, attempt, settings.retries+1, err, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return attempt, err
+		}
+		backoff *= 2
+	}
+}
+
+// runAttempt executes the operation with the timeout, zero timeout means no limit.
+func runAttempt(ctx context.Context, timeout time.Duration, operation func(ctx context.Context) error) error {
+	if timeout <= 0 {
+		return operation(ctx)
+	}
+	attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	err := operation(attemptCtx)
+	if err != nil && ctx.Err() == nil && attemptCtx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %v: %s", timeout, err)
+	}
+	return err
+}
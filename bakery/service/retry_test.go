@@ -0,0 +1,94 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func retries(count int) *int {
+	return &count
+}
+
+func TestImageRetrySettings(t *testing.T) {
+	cfg := &Config{
+		Build: RetryPolicy{Timeout: "30m", Retries: retries(2)},
+		Push:  RetryPolicy{Retries: retries(3), Backoff: "5s"},
+		Images: map[string]*ImageConfig{
+			"app": {Build: RetryPolicy{Retries: retries(0)}, Push: RetryPolicy{Timeout: "1m"}},
+		},
+	}
+	assert.NoError(t, cfg.validateRetryPolicies())
+
+	assert.Equal(t, retrySettings{timeout: 30 * time.Minute, retries: 2, backoff: time.Second}, cfg.imageRetrySettings(buildOperationName, "base"))
+	assert.Equal(t, retrySettings{timeout: 30 * time.Minute, retries: 0, backoff: time.Second}, cfg.imageRetrySettings(buildOperationName, "app"))
+	assert.Equal(t, retrySettings{timeout: time.Minute, retries: 3, backoff: 5 * time.Second}, cfg.imageRetrySettings(pushOperationName, "app"))
+	assert.Equal(t, retrySettings{backoff: time.Second}, cfg.gitRetrySettings())
+}
+
+func TestInvalidRetryPolicies(t *testing.T) {
+	testCases := []struct {
+		cfg      *Config
+		expected string
+	}{
+		{&Config{Git: RetryPolicy{Timeout: "often"}}, `git: invalid timeout often: time: invalid duration "often"`},
+		{&Config{Build: RetryPolicy{Retries: retries(-1)}}, "build: invalid retries -1: can't be negative"},
+		{&Config{Images: map[string]*ImageConfig{"app": {Push: RetryPolicy{Backoff: "-1s"}}}}, "images.app.push: timeout and backoff can't be negative"},
+	}
+	for _, tc := range testCases {
+		assert.EqualError(t, tc.cfg.validateRetryPolicies(), tc.expected)
+	}
+}
+
+func TestWithRetries(t *testing.T) {
+	engine := givenTestEngine(&Config{})
+	failures := 2
+	attempts, err := engine.withRetries(context.Background(), retrySettings{retries: 3, backoff: time.Millisecond}, "build of app", func(ctx context.Context) error {
+		if failures > 0 {
+			failures--
+			return errors.New("flaky")
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, attempts)
+
+	attempts, err = engine.withRetries(context.Background(), retrySettings{retries: 1, backoff: time.Millisecond}, "build of app", func(ctx context.Context) error {
+		return errors.New("broken")
+	})
+	assert.EqualError(t, err, "broken")
+	assert.Equal(t, 2, attempts)
+}
+
+func TestWithRetriesStopsOnCancellation(t *testing.T) {
+	engine := givenTestEngine(&Config{})
+	ctx, cancel := context.WithCancel(context.Background())
+	attempts, err := engine.withRetries(ctx, retrySettings{retries: 5, backoff: time.Hour}, "push of app", func(ctx context.Context) error {
+		cancel()
+		return ctx.Err()
+	})
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 1, attempts)
+}
+
+func TestTimedOutCommandIsKilledWithItsChildren(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+	engine := givenTestEngine(&Config{})
+	start := time.Now()
+	err := runAttempt(context.Background(), 100*time.Millisecond, func(ctx context.Context) error {
+		cmd := engine.newCommand(ctx, "sh", "-c", "sleep 30 & wait")
+		// output pipe stays open until the orphaned child exits, so the command would wait for the grace period
+		cmd.Stdout = &bytes.Buffer{}
+		return cmd.Run()
+	})
+
+	assert.EqualError(t, err, "timed out after 100ms: signal: killed")
+	assert.True(t, time.Since(start) < terminationGracePeriod, "child process was not killed")
+}
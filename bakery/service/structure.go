@@ -0,0 +1,250 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/smartrecruiters/docker-bakery/bakery/commons"
+	"github.com/smartrecruiters/gotree"
+)
+
+const (
+	structureFormatTree    = "tree"
+	structureFormatJSON    = "json"
+	structureFormatDot     = "dot"
+	structureFormatMermaid = "mermaid"
+)
+
+// Structure is a machine-readable representation of the images hierarchy.
+type Structure struct {
+	RootDir string            `json:"rootDir"`
+	Images  []*StructureImage `json:"images"`
+}
+
+// StructureImage describes a single image of the hierarchy. External images appear only in the FROM clauses
+// and are not defined in the hierarchy, so they have no directory and version.
+type StructureImage struct {
+	Name          string `json:"name"`
+	Dir           string `json:"dir,omitempty"`
+	LatestVersion string `json:"latestVersion,omitempty"`
+	Parent        string `json:"parent,omitempty"`
+	External      bool   `json:"external"`
+	Excluded      bool   `json:"excluded"`
+}
+
+// showStructure prints the images hierarchy in the provided format (tree, json, dot or mermaid)
+// or stores it in the file when the file name is provided.
+func (e *Engine) showStructure(format, fileName string) error {
+	content, err := renderStructure(e.config.imageFilter.apply(e.getStructure()), format)
+	if err != nil {
+		return err
+	}
+	if fileName != "" {
+		return ioutil.WriteFile(fileName, []byte(content), 0644)
+	}
+	fmt.Fprint(e.stdout, content)
+	return nil
+}
+
+// printImageHierarchy prints the images hierarchy as a tree.
+func (e *Engine) printImageHierarchy() {
+	content, _ := renderStructure(e.config.imageFilter.apply(e.getStructure()), structureFormatTree)
+	fmt.Fprint(e.stdout, content)
+}
+
+func renderStructure(s *Structure, format string) (string, error) {
+	switch format {
+	case "", structureFormatTree:
+		return structureTree(s), nil
+	case structureFormatJSON:
+		data, err := json.MarshalIndent(s, "", "\t")
+		if err != nil {
+			return "", err
+		}
+		return string(data) + "\n", nil
+	case structureFormatDot:
+		return structureDot(s), nil
+	case structureFormatMermaid:
+		return structureMermaid(s), nil
+	default:
+		return "", fmt.Errorf("unknown structure format %s, expecting one of: %s, %s, %s, %s", format,
+			structureFormatTree, structureFormatJSON, structureFormatDot, structureFormatMermaid)
+	}
+}
+
+// getStructure returns images from the hierarchy along with their external parents, sorted by name.
+func (e *Engine) getStructure() *Structure {
+	images := make(map[string]*StructureImage)
+	for _, dockerImage := range e.hierarchy.GetImages() {
+		image := &StructureImage{
+			Name:     dockerImage.Name,
+			Dir:      e.relativeToRootDir(dockerImage.DockerfileDir),
+			Parent:   dockerImage.DependsOnShort,
+			Excluded: commons.Contains(e.config.AutoBuildExcludes, dockerImage.Name),
+		}
+		if dockerImage.latestVersion != nil {
+			image.LatestVersion = dockerImage.latestVersion.String()
+		}
+		images[image.Name] = image
+	}
+	for _, dockerImage := range e.hierarchy.GetImages() {
+		if _, exists := images[dockerImage.DependsOnShort]; !exists {
+			images[dockerImage.DependsOnShort] = &StructureImage{Name: dockerImage.DependsOnShort, External: true}
+		}
+	}
+
+	s := &Structure{RootDir: e.config.RootDir, Images: make([]*StructureImage, 0, len(images))}
+	for _, image := range images {
+		s.Images = append(s.Images, image)
+	}
+	sort.Slice(s.Images, func(i, j int) bool {
+		return s.Images[i].Name < s.Images[j].Name
+	})
+	return s
+}
+
+func (e *Engine) relativeToRootDir(dir string) string {
+	relDir, err := filepath.Rel(e.config.RootDir, dir)
+	if err != nil {
+		return filepath.ToSlash(dir)
+	}
+	return filepath.ToSlash(relDir)
+}
+
+// children returns images of the structure grouped by their parents.
+// Images whose parents are not part of the (filtered) structure are grouped as the roots under the empty name.
+func (s *Structure) children() map[string][]*StructureImage {
+	children := make(map[string][]*StructureImage)
+	for _, image := range s.Images {
+		parent := image.Parent
+		if !s.contains(parent) {
+			parent = ""
+		}
+		children[parent] = append(children[parent], image)
+	}
+	return children
+}
+
+func (s *Structure) contains(imageName string) bool {
+	for _, image := range s.Images {
+		if image.Name == imageName {
+			return true
+		}
+	}
+	return false
+}
+
+func structureTree(s *Structure) string {
+	children := s.children()
+	var treeItem func(image *StructureImage) *gotree.GTStructure
+	treeItem = func(image *StructureImage) *gotree.GTStructure {
+		item := &gotree.GTStructure{Name: treeItemName(image), Items: make([]*gotree.GTStructure, 0)}
+		for _, child := range children[image.Name] {
+			item.Items = append(item.Items, treeItem(child))
+		}
+		return item
+	}
+
+	root := &gotree.GTStructure{Name: fmt.Sprintf("Dockerfiles hierarchy discovered in %s", s.RootDir)}
+	for _, image := range children[""] {
+		root.Items = append(root.Items, treeItem(image))
+	}
+	return gotree.StringTree(root)
+}
+
+func treeItemName(image *StructureImage) string {
+	name := image.Name
+	if image.LatestVersion != "" {
+		name = fmt.Sprintf("%s (latest: %s)", name, image.LatestVersion)
+	}
+	if image.Dir != "" {
+		name = fmt.Sprintf("%s [%s]", name, image.Dir)
+	}
+	if image.External {
+		name += " [external]"
+	}
+	if image.Excluded {
+		name += " [excluded]"
+	}
+	return name
+}
+
+// structureDot renders the structure as a Graphviz digraph with edges pointing from the parents to their children.
+// External images are drawn with dashed borders, images excluded from automatic builds are grayed out.
+func structureDot(s *Structure) string {
+	var b strings.Builder
+	b.WriteString("digraph bakery {\n")
+	b.WriteString("\trankdir=LR;\n")
+	b.WriteString("\tnode [shape=box];\n")
+	for _, image := range s.Images {
+		attributes := []string{fmt.Sprintf("label=%q", strings.Join(imageDescription(image), "\n"))}
+		if image.External {
+			attributes = append(attributes, `style=dashed`)
+		}
+		if image.Excluded {
+			attributes = append(attributes, `color=gray`, `fontcolor=gray`)
+		}
+		fmt.Fprintf(&b, "\t%q [%s];\n", image.Name, strings.Join(attributes, ", "))
+	}
+	for _, image := range s.Images {
+		if s.contains(image.Parent) {
+			fmt.Fprintf(&b, "\t%q -> %q;\n", image.Parent, image.Name)
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// structureMermaid renders the structure as a Mermaid flowchart with edges pointing from the parents to their children.
+// Nodes are identified by their position as image names may contain characters not allowed in the Mermaid ids.
+func structureMermaid(s *Structure) string {
+	ids := make(map[string]string, len(s.Images))
+	for i, image := range s.Images {
+		ids[image.Name] = fmt.Sprintf("img%d", i)
+	}
+
+	var b strings.Builder
+	b.WriteString("graph TD\n")
+	for _, image := range s.Images {
+		label := strings.Replace(strings.Join(imageDescription(image), "<br/>"), `"`, "#quot;", -1)
+		fmt.Fprintf(&b, "\t%s[\"%s\"]\n", ids[image.Name], label)
+	}
+	for _, image := range s.Images {
+		if s.contains(image.Parent) {
+			fmt.Fprintf(&b, "\t%s --> %s\n", ids[image.Parent], ids[image.Name])
+		}
+	}
+	b.WriteString("\tclassDef external stroke-dasharray: 5 5\n")
+	b.WriteString("\tclassDef excluded fill:#eeeeee,color:#999999\n")
+	for _, image := range s.Images {
+		if image.External {
+			fmt.Fprintf(&b, "\tclass %s external\n", ids[image.Name])
+		}
+		if image.Excluded {
+			fmt.Fprintf(&b, "\tclass %s excluded\n", ids[image.Name])
+		}
+	}
+	return b.String()
+}
+
+// imageDescription returns lines describing the image in the graph formats.
+func imageDescription(image *StructureImage) []string {
+	lines := []string{image.Name}
+	if image.LatestVersion != "" {
+		lines = append(lines, "latest: "+image.LatestVersion)
+	}
+	if image.Dir != "" {
+		lines = append(lines, "dir: "+image.Dir)
+	}
+	if image.External {
+		lines = append(lines, "external")
+	}
+	if image.Excluded {
+		lines = append(lines, "excluded")
+	}
+	return lines
+}
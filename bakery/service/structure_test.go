@@ -0,0 +1,113 @@
+package service
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/Masterminds/semver"
+	"github.com/stretchr/testify/assert"
+)
+
+func givenStructure(t *testing.T) (*Engine, *Structure) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "base", dockerFileTemplateName), "FROM ubuntu:20.04\n")
+	writeTestFile(t, filepath.Join(dir, "base", "app", dockerFileTemplateName), "FROM base:{{.BASE_VERSION}}\n")
+	writeTestFile(t, filepath.Join(dir, "tools", dockerFileTemplateName), "FROM alpine:3\n")
+
+	engine := givenTestEngine(&Config{RootDir: dir, AutoBuildExcludes: []string{"app"}})
+	assert.NoError(t, engine.hierarchy.AnalyzeStructure(dir, map[string]*semver.Version{"base": semver.MustParse("1.2.0")}, nil))
+	s := engine.getStructure()
+	s.RootDir = "images"
+	return engine, s
+}
+
+func TestGetStructure(t *testing.T) {
+	_, s := givenStructure(t)
+
+	assert.Equal(t, []*StructureImage{
+		{Name: "alpine", External: true},
+		{Name: "app", Dir: "base/app", Parent: "base", Excluded: true},
+		{Name: "base", Dir: "base", LatestVersion: "1.2.0", Parent: "ubuntu"},
+		{Name: "tools", Dir: "tools", Parent: "alpine"},
+		{Name: "ubuntu", External: true},
+	}, s.Images)
+}
+
+func TestRenderStructureAsJSON(t *testing.T) {
+	_, s := givenStructure(t)
+
+	content, err := renderStructure(s, structureFormatJSON)
+
+	assert.NoError(t, err)
+	decoded := &Structure{}
+	assert.NoError(t, json.Unmarshal([]byte(content), decoded))
+	assert.Equal(t, s, decoded)
+	assert.Contains(t, content, `"latestVersion": "1.2.0"`)
+	assert.Contains(t, content, `"external": true`)
+}
+
+func TestRenderStructureAsTree(t *testing.T) {
+	_, s := givenStructure(t)
+
+	content, err := renderStructure(s, structureFormatTree)
+
+	assert.NoError(t, err)
+	assert.Equal(t, `Dockerfiles hierarchy discovered in images
+├── alpine [external]
+│   └── tools [tools]
+└── ubuntu [external]
+    └── base (latest: 1.2.0) [base]
+        └── app [base/app] [excluded]
+`, content)
+}
+
+func TestRenderStructureAsDot(t *testing.T) {
+	_, s := givenStructure(t)
+
+	content, err := renderStructure(s, structureFormatDot)
+
+	assert.NoError(t, err)
+	assert.Equal(t, `digraph bakery {
+	rankdir=LR;
+	node [shape=box];
+	"alpine" [label="alpine\nexternal", style=dashed];
+	"app" [label="app\ndir: base/app\nexcluded", color=gray, fontcolor=gray];
+	"base" [label="base\nlatest: 1.2.0\ndir: base"];
+	"tools" [label="tools\ndir: tools"];
+	"ubuntu" [label="ubuntu\nexternal", style=dashed];
+	"base" -> "app";
+	"ubuntu" -> "base";
+	"alpine" -> "tools";
+}
+`, content)
+}
+
+func TestRenderStructureAsMermaid(t *testing.T) {
+	_, s := givenStructure(t)
+
+	content, err := renderStructure(s, structureFormatMermaid)
+
+	assert.NoError(t, err)
+	assert.Equal(t, `graph TD
+	img0["alpine<br/>external"]
+	img1["app<br/>dir: base/app<br/>excluded"]
+	img2["base<br/>latest: 1.2.0<br/>dir: base"]
+	img3["tools<br/>dir: tools"]
+	img4["ubuntu<br/>external"]
+	img2 --> img1
+	img4 --> img2
+	img0 --> img3
+	classDef external stroke-dasharray: 5 5
+	classDef excluded fill:#eeeeee,color:#999999
+	class img0 external
+	class img1 excluded
+	class img4 external
+`, content)
+}
+
+func TestRenderStructureInUnknownFormat(t *testing.T) {
+	_, err := renderStructure(&Structure{}, "yaml")
+
+	assert.EqualError(t, err, "unknown structure format yaml, expecting one of: tree, json, dot, mermaid")
+}
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/smartrecruiters/docker-bakery/bakery/commons"
+)
+
+const (
+	parentImageFuncName = "parentImage"
+	imageRefFuncName    = "imageRef"
+)
+
+// templateFuncs returns functions that require access to the hierarchy, available in addition to commons.TemplateFuncs:
+//   - parentImage - fully qualified reference of the parent of the templated image at its resolved version
+//   - imageRef NAME - fully qualified reference of the image from the hierarchy at its current version,
+//     rendered with the first of the configured image tags
+func (e *Engine) templateFuncs(dockerImage *DockerImage) template.FuncMap {
+	return template.FuncMap{
+		parentImageFuncName: func() (string, error) {
+			return e.parentImageRef(dockerImage)
+		},
+		imageRefFuncName: e.imageRef,
+	}
+}
+
+func (e *Engine) parentImageRef(dockerImage *DockerImage) (string, error) {
+	if dockerImage == nil {
+		return "", fmt.Errorf("%s is available only in the Dockerfile templates", parentImageFuncName)
+	}
+	if strings.Contains(dockerImage.DependsOnLong, parentImageFuncName) {
+		return "", fmt.Errorf("%s cannot be used in the FROM clause of %s", parentImageFuncName, dockerImage.DockerfilePath)
+	}
+	return e.resolveVersion(dockerImage.DependsOnLong, e.config), nil
+}
+
+func (e *Engine) imageRef(imageName string) (string, error) {
+	if e.hierarchy.GetImageByName(imageName) == nil {
+		return "", fmt.Errorf("image %s does not exist in the hierarchy", imageName)
+	}
+
+	tagTemplate := defaultImageTag
+	if len(e.config.Commands.ImageTags) > 0 {
+		tagTemplate = e.config.Commands.ImageTags[0]
+	}
+
+	properties := make(map[string]string, len(e.config.Properties))
+	for k, v := range e.config.Properties {
+		properties[k] = v
+	}
+	properties[imageNamePropName] = imageName
+	properties[imageVersionPropName] = e.config.Properties[dynamicImageVersionName(imageName)]
+
+	t, err := commons.ParseTemplate(imageRefFuncName, tagTemplate, e.templateOptions(nil))
+	if err != nil {
+		return "", err
+	}
+	ref, err := commons.ExecuteTemplate(t, imageRefFuncName, properties)
+	if err != nil {
+		return "", err
+	}
+	return string(ref), nil
+}
@@ -0,0 +1,44 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParentImageAndImageRefFunctions(t *testing.T) {
+	// given
+	base := &DockerImage{Name: "base", DependsOnLong: "ubuntu:20.04", DependsOnShort: "ubuntu", DockerfilePath: "base/Dockerfile.template"}
+	app := &DockerImage{Name: "app", DependsOnLong: "{{.REGISTRY}}/base:{{.BASE_VERSION}}", DependsOnShort: "base", DockerfilePath: "base/app/Dockerfile.template"}
+	engine := givenTestEngine(&Config{
+		Properties: map[string]string{"REGISTRY": "registry.local", "BASE_VERSION": "1.2.3", "APP_VERSION": "0.1.0"},
+		Commands:   Commands{ImageTags: []string{"{{.REGISTRY}}/{{.IMAGE_NAME}}:{{.IMAGE_VERSION}}", "{{.IMAGE_NAME}}:latest"}},
+	})
+	engine.hierarchy.AddImage(base)
+	engine.hierarchy.AddImage(app)
+
+	// when
+	funcs := engine.templateFuncs(app)
+	parent, parentErr := funcs[parentImageFuncName].(func() (string, error))()
+	ref, refErr := funcs[imageRefFuncName].(func(string) (string, error))("base")
+	_, unknownErr := engine.imageRef("unicorn")
+	_, noImageErr := engine.parentImageRef(nil)
+
+	// then
+	assert.NoError(t, parentErr)
+	assert.Equal(t, "registry.local/base:1.2.3", parent)
+	assert.NoError(t, refErr)
+	assert.Equal(t, "registry.local/base:1.2.3", ref)
+	assert.EqualError(t, unknownErr, "image unicorn does not exist in the hierarchy")
+	assert.Error(t, noImageErr)
+}
+
+func TestFillTemplateStringWithTemplateFunctions(t *testing.T) {
+	engine := givenTestEngine(&Config{Properties: map[string]string{"BASE_VERSION": "1.2.3", "IMAGE_NAME": "app"}})
+	engine.hierarchy.AddImage(&DockerImage{Name: "base"})
+
+	result, err := engine.fillTemplateString("cmd", `docker build --build-arg BASE={{imageRef "base"}} --build-arg MAJOR={{semverMajor .BASE_VERSION}} -t {{upper .IMAGE_NAME}} .`)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "docker build --build-arg BASE=base:1.2.3 --build-arg MAJOR=1 -t APP .", result)
+}
@@ -10,23 +10,23 @@ import (
 	"strings"
 )
 
-// GenerateImagesTree generate ancestors for a given image with a new parent image
-func GenerateImagesTree(previousImageName string, recursive, skipExistingDirectories bool, nameReplacements []string) error {
-	previousDockerImage := hierarchy.GetImageByName(previousImageName)
+// generateImagesTree generate ancestors for a given image with a new parent image
+func (e *Engine) generateImagesTree(previousImageName string, recursive, skipExistingDirectories bool, nameReplacements []string) error {
+	previousDockerImage := e.hierarchy.GetImageByName(previousImageName)
 	if previousDockerImage == nil {
 		return fmt.Errorf("unable to find image %s in the analyzed structure (is invocation directory correct?)", previousImageName)
 	}
 
-	replacer := createNewNameReplacer(nameReplacements)
-	return generateAncestors(previousImageName, dependencies, replacer, recursive, skipExistingDirectories)
+	replacer := e.createNewNameReplacer(nameReplacements)
+	return e.generateAncestors(previousImageName, e.dependencies, replacer, recursive, skipExistingDirectories)
 }
 
-func createNewNameReplacer(nameReplacements []string) nameReplacer {
+func (e *Engine) createNewNameReplacer(nameReplacements []string) nameReplacer {
 	replacer := newNameReplacer()
 	for _, replacement := range nameReplacements {
 		keyValuePair := strings.Split(replacement, propertyKeyValueSeparator)
 		if len(keyValuePair) != 2 {
-			fmt.Printf("Unable to parse provided replacement: %s - expecting key and value to be separated with '%s'", replacement, propertyKeyValueSeparator)
+			fmt.Fprintf(e.stdout, "Unable to parse provided replacement: %s - expecting key and value to be separated with '%s'", replacement, propertyKeyValueSeparator)
 			continue
 		}
 		replacer = replacer.add(keyValuePair[0], keyValuePair[1])
@@ -34,14 +34,14 @@ func createNewNameReplacer(nameReplacements []string) nameReplacer {
 	return replacer
 }
 
-func generateAncestors(previousImageName string, dependenciesMap map[string][]*DockerImage, replacer nameReplacer, recursive bool, skipExistingDirectories bool) error {
+func (e *Engine) generateAncestors(previousImageName string, dependenciesMap map[string][]*DockerImage, replacer nameReplacer, recursive bool, skipExistingDirectories bool) error {
 	ancestorsToGenerate, err := getChildrenToUpdate(previousImageName, dependenciesMap, replacer, recursive)
 	if err != nil {
 		return err
 	}
-	printAncestorsToGenerate(ancestorsToGenerate)
+	e.printAncestorsToGenerate(ancestorsToGenerate)
 	for _, ancestor := range ancestorsToGenerate {
-		err := generateChildProjects(ancestor, replacer, skipExistingDirectories)
+		err := e.generateChildProjects(ancestor, replacer, skipExistingDirectories)
 		if err != nil {
 			return err
 		}
@@ -49,16 +49,16 @@ func generateAncestors(previousImageName string, dependenciesMap map[string][]*D
 	return nil
 }
 
-func printAncestorsToGenerate(ancestorsToGenerate []imageToGenerate) {
-	fmt.Println("Found ancestors to recreate:")
+func (e *Engine) printAncestorsToGenerate(ancestorsToGenerate []imageToGenerate) {
+	fmt.Fprintln(e.stdout, "Found ancestors to recreate:")
 	for _, ancestor := range ancestorsToGenerate {
-		fmt.Println(ancestor.childOriginImageName)
+		fmt.Fprintln(e.stdout, ancestor.childOriginImageName)
 	}
 }
 
-func generateChildProjects(ancestor imageToGenerate, replacer nameReplacer, skipExistingDirectories bool) error {
+func (e *Engine) generateChildProjects(ancestor imageToGenerate, replacer nameReplacer, skipExistingDirectories bool) error {
 	targetPath := ancestor.newImageDirectory
-	exists, err := checkDirectoryExistence(targetPath, skipExistingDirectories)
+	exists, err := e.checkDirectoryExistence(targetPath, skipExistingDirectories)
 	if err != nil {
 		return err
 	}
@@ -66,7 +66,7 @@ func generateChildProjects(ancestor imageToGenerate, replacer nameReplacer, skip
 	if exists || ancestor.childOriginDirectory == targetPath { // when no explicit version in image name, just update the file inside
 		err = updateInPlace(ancestor, replacer)
 	} else {
-		err = copyImageDirectory(ancestor.childOriginDirectory, targetPath)
+		err = e.copyImageDirectory(ancestor.childOriginDirectory, targetPath)
 	}
 	if err != nil {
 		return err
@@ -87,11 +87,11 @@ func updateImageBaseInformation(path string, newParentImageName string, original
 	return ioutil.WriteFile(dockerFilePath, []byte(newContents), 0)
 }
 
-func copyImageDirectory(childOriginDirectory string, targetPath string) error {
+func (e *Engine) copyImageDirectory(childOriginDirectory string, targetPath string) error {
 	copyCommand := exec.Command("cp", "-r", childOriginDirectory, targetPath)
 	copyCommand.Stdin = os.Stdin
-	copyCommand.Stdout = os.Stdout
-	copyCommand.Stderr = os.Stderr
+	copyCommand.Stdout = e.stdout
+	copyCommand.Stderr = e.stderr
 	return copyCommand.Run()
 
 }
@@ -126,10 +126,10 @@ func newNameReplacer() nameReplacer {
 	return nameReplacer{replacements: make([]changeNameFn, 0)}
 }
 
-func checkDirectoryExistence(targetPath string, skipExistingDirectories bool) (bool, error) {
+func (e *Engine) checkDirectoryExistence(targetPath string, skipExistingDirectories bool) (bool, error) {
 	if _, err := os.Stat(targetPath); err == nil {
 		if skipExistingDirectories {
-			fmt.Printf("directory exists: %s, ignored explicitly\n", targetPath)
+			fmt.Fprintf(e.stdout, "directory exists: %s, ignored explicitly\n", targetPath)
 			return true, nil
 		}
 		return true, fmt.Errorf("error - target path %s already exist", targetPath)
@@ -0,0 +1,287 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/fatih/color"
+	"github.com/smartrecruiters/docker-bakery/bakery/commons"
+)
+
+// ValidationProblem describes single problem found during validation along with the file it was found in.
+type ValidationProblem struct {
+	Source  string
+	Message string
+}
+
+func (p ValidationProblem) String() string {
+	return fmt.Sprintf("%s: %s", p.Source, p.Message)
+}
+
+// validator gathers problems found in the config, templates and the hierarchy.
+type validator struct {
+	engine     *Engine
+	configFile string
+	problems   []ValidationProblem
+	// images found in the structure, key is the image name, value contains images with the same name found in different dirs
+	images    map[string][]*DockerImage
+	overrides []*DirectoryOverride
+}
+
+// validate checks config, Dockerfile templates and the images hierarchy under the root dir.
+// Prints all problems found and returns an error when there is any. Does not require access to the git remote.
+func (e *Engine) validate(configFile, rootDir, profile string, additionalProperties []string) error {
+	problems := e.validateStructure(configFile, rootDir, profile, additionalProperties)
+	if len(problems) == 0 {
+		fmt.Fprintf(e.stdout, color.GreenString("Validation passed, no problems found\n"))
+		return nil
+	}
+
+	fmt.Fprintf(e.stdout, color.RedString("Validation found (%d) problem(s):\n", len(problems)))
+	for _, problem := range problems {
+		fmt.Fprintf(e.stdout, color.RedString("\t%s\n", e.config.mask(problem.String())))
+	}
+	return fmt.Errorf("validation failed with %d problem(s)", len(problems))
+}
+
+// validateStructure returns all problems found in the config, Dockerfile templates and the images hierarchy.
+func (e *Engine) validateStructure(configFile, rootDir, profile string, additionalProperties []string) []ValidationProblem {
+	v := &validator{engine: e, configFile: configFile, problems: make([]ValidationProblem, 0), images: make(map[string][]*DockerImage)}
+
+	var err error
+	e.config, err = ReadConfig(configFile)
+	if err != nil {
+		e.config = &Config{}
+		v.addProblem(configFile, err.Error())
+		return v.problems
+	}
+	if err = e.config.applyProfile(profile); err != nil {
+		v.addProblem(configFile, err.Error())
+	}
+	e.overrideWithRuntimeProvidedProperties(additionalProperties)
+
+	if rootDir == "" {
+		rootDir = e.config.RootDir
+	}
+	if rootDir == "" {
+		rootDir = path.Dir(configFile)
+	}
+	e.config.RootDir = rootDir
+	if err = e.loadPartials(); err != nil {
+		v.addProblem(configFile, err.Error())
+	}
+
+	templates := v.walkStructure(rootDir)
+	e.config.setDirectoryOverrides(v.overrides)
+	v.validateDuplicates()
+	v.validateAutoBuildExcludes()
+	for _, templateFile := range templates {
+		v.validateTemplate(templateFile)
+	}
+	for _, name := range v.imageNames() {
+		v.validateParentReference(v.images[name][0])
+	}
+	v.validateCommands()
+	return v.problems
+}
+
+func (v *validator) addProblem(source, message string, args ...interface{}) {
+	v.problems = append(v.problems, ValidationProblem{Source: source, Message: fmt.Sprintf(message, args...)})
+}
+
+// walkStructure gathers images and directory overrides and returns paths of all found templates.
+func (v *validator) walkStructure(rootDir string) []string {
+	templates := make([]string, 0)
+	ignored, err := v.engine.config.readIgnoredPaths()
+	if err != nil {
+		v.addProblem(rootDir, err.Error())
+	}
+	err = filepath.Walk(rootDir, func(sourcePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			v.addProblem(sourcePath, err.Error())
+			return nil
+		}
+		if isIgnoredPath(ignored, rootDir, sourcePath, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if isDirectoryOverrideFile(info.Name()) {
+			override, err := readDirectoryOverride(sourcePath)
+			if err != nil {
+				v.addProblem(sourcePath, err.Error())
+			} else {
+				v.overrides = append(v.overrides, override)
+			}
+		}
+		if info.Name() != dockerFileTemplateName {
+			return nil
+		}
+
+		templates = append(templates, sourcePath)
+		dockerImg, err := v.engine.dockerImgParser.ParseDockerfile(sourcePath)
+		if err != nil {
+			v.addProblem(sourcePath, err.Error())
+		} else if dockerImg != nil {
+			v.images[dockerImg.Name] = append(v.images[dockerImg.Name], dockerImg)
+		}
+		return nil
+	})
+	if err != nil {
+		v.addProblem(rootDir, err.Error())
+	}
+	return templates
+}
+
+func (v *validator) validateDuplicates() {
+	for _, name := range v.imageNames() {
+		images := v.images[name]
+		if len(images) < 2 {
+			continue
+		}
+		dirs := make([]string, 0, len(images))
+		for _, img := range images {
+			dirs = append(dirs, img.DockerfileDir)
+		}
+		v.addProblem(images[0].DockerfilePath, "duplicate image name %s found in: %s", name, strings.Join(dirs, ", "))
+	}
+}
+
+func (v *validator) validateAutoBuildExcludes() {
+	for _, excluded := range v.engine.config.AutoBuildExcludes {
+		if _, exists := v.images[excluded]; !exists {
+			v.addProblem(v.configFile, "autoBuildExcludes contains %s which is not an image in the hierarchy", excluded)
+		}
+	}
+}
+
+func (v *validator) validateTemplate(templateFile string) {
+	t, err := commons.ParseTemplateFile(templateFile, v.engine.templateOptions(nil))
+	if err != nil {
+		v.addProblem(templateFile, "unable to parse template: %s", err)
+		return
+	}
+	v.validateReferencedFields(templateFile, t, path.Dir(absolutePath(templateFile)))
+	v.validateFunctionCalls(templateFile, t, path.Dir(templateFile))
+	for _, name := range commons.MissingTemplates(t) {
+		v.addProblem(templateFile, "template references undefined template or partial %s", name)
+	}
+}
+
+// validateParentReference checks that FROM clause of the image referring to other image from the hierarchy
+// uses the *_VERSION property, otherwise image would not be rebuilt with the new versions of its parent.
+func (v *validator) validateParentReference(img *DockerImage) {
+	if strings.Contains(img.DependsOnLong, parentImageFuncName) {
+		v.addProblem(img.DockerfilePath, "%s cannot be used in the FROM clause", parentImageFuncName)
+		return
+	}
+	if _, isInternal := v.images[img.DependsOnShort]; !isInternal {
+		return
+	}
+	versionProperty := dynamicImageVersionName(img.DependsOnShort)
+	if !strings.Contains(img.DependsOnVersion, versionProperty) {
+		v.addProblem(img.DockerfilePath, "FROM references internal image %s with version %q instead of {{.%s}}",
+			img.DependsOnShort, img.DependsOnVersion, versionProperty)
+	}
+}
+
+func (v *validator) validateCommands() {
+	v.validateCommandTemplates(v.configFile, v.engine.config.Commands, v.engine.config.RootDir)
+	for _, profileName := range v.engine.config.profileNames() {
+		if profile := v.engine.config.Profiles[profileName]; profile != nil {
+			v.validateCommandTemplates(fmt.Sprintf("%s (%s)", v.configFile, profileSource(profileName)), profile.Commands, v.engine.config.RootDir)
+		}
+	}
+	for _, override := range v.overrides {
+		v.validateCommandTemplates(override.fileName, override.Commands, override.dir)
+	}
+}
+
+func (v *validator) validateCommandTemplates(source string, commands Commands, dir string) {
+	templates := map[string]string{"defaultBuildCommand": commands.DefaultBuildCommand, "defaultPushCommand": commands.DefaultPushCommand}
+	for i, imageTag := range commands.ImageTags {
+		templates[fmt.Sprintf("imageTags[%d]", i)] = imageTag
+	}
+	for _, name := range commons.SortMapKeys(templates) {
+		if templates[name] == "" {
+			continue
+		}
+		t, err := commons.ParseTemplate(name, templates[name], v.engine.templateOptions(nil))
+		if err != nil {
+			v.addProblem(source, "unable to parse %s: %s", name, err)
+			continue
+		}
+		v.validateReferencedFields(fmt.Sprintf("%s %s", source, name), t, dir)
+		v.validateFunctionCalls(fmt.Sprintf("%s %s", source, name), t, "")
+	}
+}
+
+// validateReferencedFields checks that every property referenced in the template is defined in the config,
+// in the directory overrides applicable to the dir, provided with -p flag or is a dynamic property.
+func (v *validator) validateReferencedFields(source string, t *template.Template, dir string) {
+	for _, field := range commons.ReferencedFields(t) {
+		if !v.isPropertyDefined(field, dir) {
+			v.addProblem(source, "template references undefined property %s", field)
+		}
+	}
+}
+
+// validateFunctionCalls checks literal arguments of the template functions: images passed to imageRef have to exist
+// in the hierarchy and files passed to sha256file have to exist (relative paths are resolved against the files dir).
+func (v *validator) validateFunctionCalls(source string, t *template.Template, filesDir string) {
+	for _, call := range commons.FunctionCalls(t) {
+		if len(call.Args) == 0 || call.Args[0] == "" {
+			continue
+		}
+		switch call.Name {
+		case imageRefFuncName:
+			if _, exists := v.images[call.Args[0]]; !exists {
+				v.addProblem(source, "%s references %s which is not an image in the hierarchy", imageRefFuncName, call.Args[0])
+			}
+		case "sha256file":
+			fileName := call.Args[0]
+			if !filepath.IsAbs(fileName) {
+				fileName = filepath.Join(filesDir, fileName)
+			}
+			if _, err := os.Stat(fileName); err != nil {
+				v.addProblem(source, "sha256file references file %s which cannot be read: %s", call.Args[0], err)
+			}
+		}
+	}
+}
+
+func (v *validator) isPropertyDefined(propertyName, dir string) bool {
+	if _, defined := v.engine.config.Properties[propertyName]; defined {
+		return true
+	}
+	if commons.Contains(dynamicPropertyNames, propertyName) {
+		return true
+	}
+	for imageName := range v.images {
+		if dynamicImageVersionName(imageName) == propertyName {
+			return true
+		}
+	}
+	for _, override := range v.engine.config.directoryOverridesOf(dir) {
+		if _, defined := override.Properties[propertyName]; defined {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *validator) imageNames() []string {
+	names := make(map[string]string, len(v.images))
+	for name := range v.images {
+		names[name] = name
+	}
+	return commons.SortMapKeys(names)
+}
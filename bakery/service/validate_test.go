@@ -0,0 +1,96 @@
+package service
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateStructureReportsAllProblems(t *testing.T) {
+	// given
+	dir := t.TempDir()
+	configFile := writeTestFile(t, filepath.Join(dir, "config.yaml"), `
+properties:
+  REGISTRY: registry.local
+commands:
+  defaultBuildCommand: docker build -t {{.REGISTRY}}/{{.IMAGE_NAME}}:{{.IMAGE_VERSION}} {{.DOCKERFILE_DIR}}
+  defaultPushCommand: docker push {{.PUSH_REGISTRY}}/{{.IMAGE_NAME}}:{{.IMAGE_VERSION}}
+autoBuildExcludes: [dog, unicorn]
+`)
+	writeTestFile(t, filepath.Join(dir, "mammal", dockerFileTemplateName), "FROM {{.REGISTRY}}/ubuntu:20.04\n")
+	writeTestFile(t, filepath.Join(dir, "mammal", "dog", dockerFileTemplateName), "FROM {{.REGISTRY}}/mammal:{{.MAMMAL_VERSION}}\nLABEL tier={{.TIER}}\n")
+	writeTestFile(t, filepath.Join(dir, "mammal", "dog", "bakery-override.json"), `{"properties": {"TIER": "pets"}}`)
+	writeTestFile(t, filepath.Join(dir, "mammal", "cat", dockerFileTemplateName), "FROM {{.REGISTRY}}/mammal:1.0.0\nLABEL tier={{.TIER}}\n")
+	writeTestFile(t, filepath.Join(dir, "other", "cat", dockerFileTemplateName), "FROM ubuntu:20.04\nRUN {{if .DEBUG}}\n")
+
+	// when
+	problems := givenTestEngine(nil).validateStructure(configFile, "", "", nil)
+
+	// then
+	assert.Equal(t, []ValidationProblem{
+		{Source: filepath.Join(dir, "mammal", "cat", dockerFileTemplateName), Message: "duplicate image name cat found in: " +
+			filepath.Join(dir, "mammal", "cat") + ", " + filepath.Join(dir, "other", "cat")},
+		{Source: configFile, Message: "autoBuildExcludes contains unicorn which is not an image in the hierarchy"},
+		{Source: filepath.Join(dir, "mammal", "cat", dockerFileTemplateName), Message: "template references undefined property TIER"},
+		{Source: filepath.Join(dir, "other", "cat", dockerFileTemplateName), Message: "unable to parse template: template: Dockerfile.template:3: unexpected EOF"},
+		{Source: filepath.Join(dir, "mammal", "cat", dockerFileTemplateName), Message: `FROM references internal image mammal with version "1.0.0" instead of {{.MAMMAL_VERSION}}`},
+		{Source: configFile + " defaultPushCommand", Message: "template references undefined property PUSH_REGISTRY"},
+	}, problems)
+}
+
+func TestValidateStructureReportsInvalidConfig(t *testing.T) {
+	dir := t.TempDir()
+	configFile := writeTestFile(t, filepath.Join(dir, "config.json"), `{"autoBuildExclude": ["dog"]}`)
+
+	problems := givenTestEngine(nil).validateStructure(configFile, "", "", nil)
+
+	assert.Len(t, problems, 1)
+	assert.Contains(t, problems[0].Message, "unknown field")
+}
+
+func TestValidateStructureAcceptsRuntimeProperties(t *testing.T) {
+	dir := t.TempDir()
+	configFile := writeTestFile(t, filepath.Join(dir, "config.json"), `{}`)
+	writeTestFile(t, filepath.Join(dir, "base", dockerFileTemplateName), "FROM {{.REGISTRY}}/ubuntu:20.04\n")
+
+	assert.Len(t, givenTestEngine(nil).validateStructure(configFile, "", "", nil), 1)
+	assert.Empty(t, givenTestEngine(nil).validateStructure(configFile, "", "", []string{"REGISTRY=registry.local"}))
+}
+
+func TestValidateStructureReportsInvalidFunctionCalls(t *testing.T) {
+	// given
+	dir := t.TempDir()
+	configFile := writeTestFile(t, filepath.Join(dir, "config.json"), `{"commands": {"defaultBuildCommand": "docker build --build-arg BASE={{imageRef \"unicorn\"}} ."}}`)
+	writeTestFile(t, filepath.Join(dir, "base", dockerFileTemplateName), "FROM ubuntu:20.04\n")
+	writeTestFile(t, filepath.Join(dir, "base", "app", "requirements.txt"), "flask\n")
+	writeTestFile(t, filepath.Join(dir, "base", "app", dockerFileTemplateName),
+		"FROM {{parentImage}}\nLABEL base={{imageRef \"base\"}} tool={{imageRef \"tool\"}}\nLABEL reqs={{sha256file \"requirements.txt\"}} lock={{sha256file \"missing.lock\"}}\n")
+	appTemplate := filepath.Join(dir, "base", "app", dockerFileTemplateName)
+
+	// when
+	problems := givenTestEngine(nil).validateStructure(configFile, "", "", nil)
+
+	// then
+	assert.Len(t, problems, 4)
+	assert.Equal(t, ValidationProblem{Source: appTemplate, Message: "imageRef references tool which is not an image in the hierarchy"}, problems[0])
+	assert.Equal(t, appTemplate, problems[1].Source)
+	assert.Contains(t, problems[1].Message, "sha256file references file missing.lock which cannot be read")
+	assert.Equal(t, ValidationProblem{Source: appTemplate, Message: "parentImage cannot be used in the FROM clause"}, problems[2])
+	assert.Equal(t, ValidationProblem{Source: configFile + " defaultBuildCommand", Message: "imageRef references unicorn which is not an image in the hierarchy"}, problems[3])
+}
+
+func TestValidateStructureReportsMissingPartials(t *testing.T) {
+	dir := t.TempDir()
+	configFile := writeTestFile(t, filepath.Join(dir, "config.json"), `{"partialsDir": "partials"}`)
+	writeTestFile(t, filepath.Join(dir, "partials", "labels.tmpl"), `{{define "labels"}}LABEL tier={{.TIER}}{{end}}`)
+	writeTestFile(t, filepath.Join(dir, "base", dockerFileTemplateName), "FROM ubuntu:20.04\n{{template \"labels\" .}}\n{{include \"cleanup.tmpl\" .}}\n")
+	baseTemplate := filepath.Join(dir, "base", dockerFileTemplateName)
+
+	problems := givenTestEngine(nil).validateStructure(configFile, "", "", nil)
+
+	assert.Equal(t, []ValidationProblem{
+		{Source: baseTemplate, Message: "template references undefined property TIER"},
+		{Source: baseTemplate, Message: "template references undefined template or partial cleanup.tmpl"},
+	}, problems)
+}
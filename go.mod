@@ -5,11 +5,16 @@ go 1.22
 toolchain go1.22.2
 
 require (
+	github.com/BurntSushi/toml v1.4.0
 	github.com/Masterminds/semver v1.5.0
 	github.com/fatih/color v1.16.0
+	github.com/pmezard/go-difflib v1.0.0
 	github.com/smartrecruiters/gotree v0.0.0-20180321082247-397906871d4f
 	github.com/stretchr/testify v1.9.0
 	github.com/urfave/cli v1.22.14
+	golang.org/x/sys v0.14.0
+	golang.org/x/text v0.14.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -17,10 +22,7 @@ require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
-	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/russross/blackfriday/v2 v2.1.0 // indirect
-	golang.org/x/sys v0.14.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
 
 // https://github.com/smartrecruiters/docker-bakery/blob/5e826fe453779b9598afb836f7d9303e9420693a/Gopkg.toml#L33-L35